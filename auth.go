@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// envPattern matches ${NAME} references to environment variables in auth settings
+var envPattern = regexp.MustCompile(`\$\{(\w+)\}`)
+
+// tokenExpiryMargin renews OAuth2 tokens a little early, so one doesn't expire in flight
+const tokenExpiryMargin = 10 * time.Second
+
+// oauthToken is an access token fetched from a token endpoint
+type oauthToken struct {
+	AccessToken string
+	Expires     time.Time // Zero if the endpoint didn't say
+}
+
+// oauthTokens caches tokens by endpoint and credentials, so every test in a file
+// (and every file using the same credentials) shares one
+var (
+	oauthTokens   = make(map[string]oauthToken)
+	oauthTokensMu sync.Mutex
+)
+
+// expandEnv replaces ${NAME} references with environment variables, failing on
+// ones that aren't set rather than sending empty credentials
+func expandEnv(s string) (string, error) {
+	var missing []string
+	result := envPattern.ReplaceAllStringFunc(s, func(ref string) string {
+		name := envPattern.FindStringSubmatch(ref)[1]
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+	}
+	return result, nil
+}
+
+// expanded returns a copy of auth with environment variables filled in
+func (auth AuthConfig) expanded() (AuthConfig, error) {
+	for _, value := range []*string{&auth.Username, &auth.Password, &auth.Token, &auth.TokenURL, &auth.ClientID, &auth.ClientSecret, &auth.Scope} {
+		var err error
+		if *value, err = expandEnv(*value); err != nil {
+			return auth, fmt.Errorf("auth failed: %w", err)
+		}
+	}
+	return auth, nil
+}
+
+// applyAuth sets the Authorization header for a request. A test's own
+// Authorization header takes precedence, so it can check bad credentials.
+// Tokens are fetched through transport, the test's TLS settings, if not nil.
+func applyAuth(req *http.Request, auth *AuthConfig, transport http.RoundTripper) error {
+	if auth == nil || req.Header.Get("Authorization") != "" {
+		return nil
+	}
+	config, err := auth.expanded()
+	if err != nil {
+		return err
+	}
+
+	switch config.Type {
+	case "basic":
+		req.SetBasicAuth(config.Username, config.Password)
+	case "bearer":
+		req.Header.Set("Authorization", "Bearer "+config.Token)
+	case "oauth2":
+		token, err := fetchOAuthToken(config, transport)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	default:
+		return fmt.Errorf("auth failed: unknown type %q, expected basic, bearer or oauth2", config.Type)
+	}
+	return nil
+}
+
+// refreshAuth drops a cached OAuth2 token after a 401, so the next request fetches
+// a new one. It reports whether there was a token to refresh.
+func refreshAuth(auth *AuthConfig) bool {
+	if auth == nil || auth.Type != "oauth2" {
+		return false
+	}
+	config, err := auth.expanded()
+	if err != nil {
+		return false
+	}
+	oauthTokensMu.Lock()
+	defer oauthTokensMu.Unlock()
+	key := config.cacheKey()
+	_, ok := oauthTokens[key]
+	delete(oauthTokens, key)
+	return ok
+}
+
+// cacheKey identifies the token for an endpoint and set of credentials
+func (auth AuthConfig) cacheKey() string {
+	return strings.Join([]string{auth.TokenURL, auth.Grant, auth.ClientID, auth.ClientSecret, auth.Username, auth.Password, auth.Scope}, "\x00")
+}
+
+// fetchOAuthToken returns a cached token, or fetches one from the token endpoint if
+// there is none or it has expired
+func fetchOAuthToken(auth AuthConfig, transport http.RoundTripper) (string, error) {
+	// Held while fetching, so parallel tests wait for one token instead of each fetching their own
+	oauthTokensMu.Lock()
+	defer oauthTokensMu.Unlock()
+
+	key := auth.cacheKey()
+	if token, ok := oauthTokens[key]; ok {
+		if token.Expires.IsZero() || time.Until(token.Expires) > tokenExpiryMargin {
+			return token.AccessToken, nil
+		}
+	}
+
+	if auth.TokenURL == "" {
+		return "", fmt.Errorf("auth failed: oauth2 needs a token_url")
+	}
+	form := url.Values{}
+	switch auth.Grant {
+	case "", "client_credentials":
+		form.Set("grant_type", "client_credentials")
+	case "password":
+		form.Set("grant_type", "password")
+		form.Set("username", auth.Username)
+		form.Set("password", auth.Password)
+	default:
+		return "", fmt.Errorf("auth failed: unknown oauth2 grant %q, expected client_credentials or password", auth.Grant)
+	}
+	if auth.Scope != "" {
+		form.Set("scope", auth.Scope)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, auth.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("auth failed: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if auth.ClientID != "" {
+		req.SetBasicAuth(url.QueryEscape(auth.ClientID), url.QueryEscape(auth.ClientSecret))
+	}
+
+	waitForRateLimit(req.URL.Host)
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		return "", fmt.Errorf("oauth2 token request failed: %w", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return "", fmt.Errorf("oauth2 token request failed: %w", err)
+	}
+	bodyPreview := string(body)
+	if len(bodyPreview) > 500 {
+		bodyPreview = bodyPreview[:500] + "..."
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("oauth2 token request failed: status %d\n       Response: %s", resp.StatusCode, bodyPreview)
+	}
+
+	var result struct {
+		AccessToken string      `json:"access_token"`
+		ExpiresIn   json.Number `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil || result.AccessToken == "" {
+		return "", fmt.Errorf("oauth2 token request failed: no access_token in response\n       Response: %s", bodyPreview)
+	}
+
+	token := oauthToken{AccessToken: result.AccessToken}
+	if seconds, err := result.ExpiresIn.Float64(); err == nil && seconds > 0 {
+		token.Expires = time.Now().Add(time.Duration(seconds * float64(time.Second)))
+	}
+	oauthTokens[key] = token
+	return token.AccessToken, nil
+}
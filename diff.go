@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxDiffLines limits how much of a diff is shown in a failure message
+const maxDiffLines = 20
+
+// maxDiffValueLen limits how much of a single value is shown in a JSON diff
+const maxDiffValueLen = 80
+
+// maxTextDiffLines is the largest body (in lines) that gets a line-by-line diff
+// Larger bodies only report where they first differ
+const maxTextDiffLines = 2000
+
+// diffDetail formats diff lines for appending to an assertion error. It's shown
+// under a "Diff:" label, which quiet mode strips along with response bodies.
+func diffDetail(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	shown := lines
+	if len(shown) > maxDiffLines {
+		shown = shown[:maxDiffLines]
+	}
+
+	var b strings.Builder
+	b.WriteString("\n       Diff:")
+	for _, line := range shown {
+		b.WriteString("\n         " + line)
+	}
+	if len(lines) > len(shown) {
+		fmt.Fprintf(&b, "\n         ... %d more lines", len(lines)-len(shown))
+	}
+	return b.String()
+}
+
+// jsonMatchOptions changes how expected JSON is compared against a response
+type jsonMatchOptions struct {
+	IgnoreOrder bool // Arrays match if they hold the same items in any order
+	Subset      bool // Objects may have extra fields and arrays may have extra items, in any order
+}
+
+// jsonDiff lists the differences between two parsed JSON values, one per line,
+// identified by their dot-notation path (e.g. "items.0.name"). Matchers such as
+// "<uuid>" in the expected value accept any actual value they match.
+func jsonDiff(path string, expected, actual interface{}, opts jsonMatchOptions) []string {
+	if matcher, ok := expected.(string); ok && isMatcher(matcher) {
+		if err := matchValue(matcher, actual); err != nil {
+			return []string{fmt.Sprintf("%s: %v", diffPath(path), err)}
+		}
+		return nil
+	}
+
+	switch exp := expected.(type) {
+	case map[string]interface{}:
+		act, ok := actual.(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected an object, got %s", diffPath(path), formatDiffValue(actual))}
+		}
+
+		keys := make([]string, 0, len(exp)+len(act))
+		for key := range exp {
+			keys = append(keys, key)
+		}
+		for key := range act {
+			if _, ok := exp[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		var lines []string
+		for _, key := range keys {
+			expValue, inExpected := exp[key]
+			actValue, inActual := act[key]
+			switch {
+			case !inActual:
+				lines = append(lines, fmt.Sprintf("%s: missing, expected %s", diffPath(joinPath(path, key)), formatDiffValue(expValue)))
+			case !inExpected:
+				if opts.Subset {
+					continue
+				}
+				lines = append(lines, fmt.Sprintf("%s: unexpected field with %s", diffPath(joinPath(path, key)), formatDiffValue(actValue)))
+			default:
+				lines = append(lines, jsonDiff(joinPath(path, key), expValue, actValue, opts)...)
+			}
+		}
+		return lines
+
+	case []interface{}:
+		act, ok := actual.([]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected an array, got %s", diffPath(path), formatDiffValue(actual))}
+		}
+
+		if opts.Subset {
+			return unorderedDiff(path, exp, act, opts)
+		}
+
+		var lines []string
+		if len(exp) != len(act) {
+			lines = append(lines, fmt.Sprintf("%s: expected %d items, got %d", diffPath(path), len(exp), len(act)))
+		}
+		if opts.IgnoreOrder {
+			return append(lines, unorderedDiff(path, exp, act, opts)...)
+		}
+		for i := 0; i < len(exp) || i < len(act); i++ {
+			itemPath := joinPath(path, strconv.Itoa(i))
+			switch {
+			case i >= len(act):
+				lines = append(lines, fmt.Sprintf("%s: missing, expected %s", diffPath(itemPath), formatDiffValue(exp[i])))
+			case i >= len(exp):
+				lines = append(lines, fmt.Sprintf("%s: unexpected item %s", diffPath(itemPath), formatDiffValue(act[i])))
+			default:
+				lines = append(lines, jsonDiff(itemPath, exp[i], act[i], opts)...)
+			}
+		}
+		return lines
+
+	default:
+		if !reflect.DeepEqual(expected, actual) {
+			return []string{fmt.Sprintf("%s: expected %s, got %s", diffPath(path), formatDiffValue(expected), formatDiffValue(actual))}
+		}
+		return nil
+	}
+}
+
+// unorderedDiff pairs each expected array item with a matching actual item in any
+// position, and lists the items left over on either side (or only the expected
+// items without a match, for subset matching). Pairs are found with augmenting
+// paths, so a loose expected item never takes the only match of a stricter one.
+func unorderedDiff(path string, expected, actual []interface{}, opts jsonMatchOptions) []string {
+	candidates := make([][]int, len(expected))
+	for i, expItem := range expected {
+		for j, actItem := range actual {
+			if len(jsonDiff("", expItem, actItem, opts)) == 0 {
+				candidates[i] = append(candidates[i], j)
+			}
+		}
+	}
+
+	// pairedWith[j] is the expected item paired with actual item j, or -1
+	pairedWith := make([]int, len(actual))
+	for j := range pairedWith {
+		pairedWith[j] = -1
+	}
+	var tryPair func(i int, visited []bool) bool
+	tryPair = func(i int, visited []bool) bool {
+		for _, j := range candidates[i] {
+			if visited[j] {
+				continue
+			}
+			visited[j] = true
+			if pairedWith[j] == -1 || tryPair(pairedWith[j], visited) {
+				pairedWith[j] = i
+				return true
+			}
+		}
+		return false
+	}
+
+	paired := make([]bool, len(expected))
+	for i := range expected {
+		paired[i] = tryPair(i, make([]bool, len(actual)))
+	}
+
+	var lines []string
+	for i, expItem := range expected {
+		if !paired[i] {
+			lines = append(lines, fmt.Sprintf("%s: no item matches expected %s", diffPath(joinPath(path, strconv.Itoa(i))), formatDiffValue(expItem)))
+		}
+	}
+	if opts.Subset {
+		return lines
+	}
+	for j, actItem := range actual {
+		if pairedWith[j] == -1 {
+			lines = append(lines, fmt.Sprintf("%s: unexpected item %s", diffPath(joinPath(path, strconv.Itoa(j))), formatDiffValue(actItem)))
+		}
+	}
+	return lines
+}
+
+// joinPath appends a key or index to a dot-notation path
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// diffPath names a path in a diff line, using "(root)" for the whole body
+func diffPath(path string) string {
+	if path == "" {
+		return "(root)"
+	}
+	return path
+}
+
+// formatDiffValue formats a JSON value for a diff line, shortening long values
+func formatDiffValue(value interface{}) string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	s := strings.TrimSuffix(buf.String(), "\n")
+	if len(s) > maxDiffValueLen {
+		s = s[:maxDiffValueLen] + "..."
+	}
+	return s
+}
+
+// binaryDiff describes how two binary contents differ without printing them
+func binaryDiff(expected, actual []byte) []string {
+	lines := []string{
+		"expected " + describeBinary(expected),
+		"got      " + describeBinary(actual),
+	}
+	for i := 0; i < len(expected) && i < len(actual); i++ {
+		if expected[i] != actual[i] {
+			return append(lines, fmt.Sprintf("first difference at byte %d", i))
+		}
+	}
+	return append(lines, fmt.Sprintf("one is a prefix of the other, differing from byte %d", min(len(expected), len(actual))))
+}
+
+// textDiff returns a unified diff of two texts, with 3 lines of context around
+// each change
+func textDiff(expected, actual string) []string {
+	a := strings.Split(strings.TrimSuffix(expected, "\n"), "\n")
+	b := strings.Split(strings.TrimSuffix(actual, "\n"), "\n")
+
+	if len(a) > maxTextDiffLines || len(b) > maxTextDiffLines {
+		for i := 0; i < len(a) && i < len(b); i++ {
+			if a[i] != b[i] {
+				return []string{fmt.Sprintf("first difference at line %d", i+1)}
+			}
+		}
+		return []string{fmt.Sprintf("expected %d lines, got %d", len(a), len(b))}
+	}
+
+	// Longest common subsequence table: lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	// Walk the table to produce an edit script
+	type edit struct {
+		op   byte // ' ', '-' or '+'
+		text string
+		aPos int // 1-indexed line in expected, for hunk headers
+		bPos int // 1-indexed line in actual
+	}
+	var edits []edit
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i], i + 1, j + 1})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', a[i], i + 1, j + 1})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j], i + 1, j + 1})
+			j++
+		}
+	}
+
+	// Group changes into hunks with surrounding context
+	const context = 3
+	var lines []string
+	for start := 0; start < len(edits); {
+		if edits[start].op == ' ' {
+			start++
+			continue
+		}
+
+		from := start - context
+		if from < 0 {
+			from = 0
+		}
+		to := start
+		for k := start; k < len(edits); k++ {
+			if edits[k].op != ' ' {
+				to = k
+			} else if k-to > 2*context {
+				break
+			}
+		}
+		to += context
+		if to >= len(edits) {
+			to = len(edits) - 1
+		}
+
+		var aCount, bCount int
+		var hunk []string
+		for _, e := range edits[from : to+1] {
+			if e.op != '+' {
+				aCount++
+			}
+			if e.op != '-' {
+				bCount++
+			}
+			hunk = append(hunk, string(e.op)+e.text)
+		}
+		lines = append(lines, fmt.Sprintf("@@ -%d,%d +%d,%d @@", edits[from].aPos, aCount, edits[from].bPos, bCount))
+		lines = append(lines, hunk...)
+		start = to + 1
+	}
+	return lines
+}
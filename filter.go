@@ -0,0 +1,382 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// tagPattern matches a single @tag in a test header
+var tagPattern = regexp.MustCompile(`(?:^|\s)@([\w.-]+)`)
+
+// splitNameTags separates @tags from a test header
+// e.g. "Create user @smoke @slow" returns ("Create user", ["smoke", "slow"])
+func splitNameTags(header string) (string, []string) {
+	var tags []string
+	for _, match := range tagPattern.FindAllStringSubmatch(header, -1) {
+		tags = append(tags, match[1])
+	}
+	name := strings.TrimSpace(tagPattern.ReplaceAllString(header, ""))
+	if name == "" {
+		return header, nil
+	}
+	return name, tags
+}
+
+// tagFilter selects tests by tag, e.g. "--tags=smoke,!slow"
+// A test must have at least one of the Include tags (if any are given)
+// and none of the Exclude tags
+type tagFilter struct {
+	Include []string
+	Exclude []string
+}
+
+// parseTagFilter parses a comma-separated tag list where "!" negates a tag
+func parseTagFilter(s string) (tagFilter, error) {
+	var filter tagFilter
+	for _, tag := range strings.Split(s, ",") {
+		tag = strings.TrimSpace(tag)
+		exclude := strings.HasPrefix(tag, "!")
+		tag = strings.TrimPrefix(strings.TrimPrefix(tag, "!"), "@")
+		if tag == "" {
+			return tagFilter{}, fmt.Errorf("invalid tag list %q", s)
+		}
+		if exclude {
+			filter.Exclude = append(filter.Exclude, tag)
+		} else {
+			filter.Include = append(filter.Include, tag)
+		}
+	}
+	return filter, nil
+}
+
+// matches reports whether a test's tags satisfy the filter
+func (f tagFilter) matches(tags []string) bool {
+	hasTag := func(want string) bool {
+		for _, tag := range tags {
+			if strings.EqualFold(tag, want) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, tag := range f.Exclude {
+		if hasTag(tag) {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, tag := range f.Include {
+		if hasTag(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterTests keeps only the tests whose name matches grep (if set) and whose tags
+// satisfy the tag filter. Files left without tests are dropped.
+func filterTests(testFiles []TestFile, grep *regexp.Regexp, tags tagFilter) []TestFile {
+	var filtered []TestFile
+	for _, tf := range testFiles {
+		var tests []Test
+		for _, test := range tf.Tests {
+			if grep != nil && !grep.MatchString(test.Name) {
+				continue
+			}
+			if !tags.matches(test.Tags) {
+				continue
+			}
+			tests = append(tests, test)
+		}
+		if len(tests) > 0 {
+			tf.Tests = tests
+			filtered = append(filtered, tf)
+		}
+	}
+	return filtered
+}
+
+// testSelector selects a range of tests by number for --only and --skip
+// e.g. "3", "5-8", "users.md:2" or "users.md:2-4"
+type testSelector struct {
+	File string // Empty to number tests across all files, otherwise numbers are within this file
+	From int    // 1-indexed, inclusive
+	To   int    // 1-indexed, inclusive
+}
+
+// String formats the selector as it was written on the command line
+func (s testSelector) String() string {
+	result := strconv.Itoa(s.From)
+	if s.To != s.From {
+		result += "-" + strconv.Itoa(s.To)
+	}
+	if s.File != "" {
+		result = s.File + ":" + result
+	}
+	return result
+}
+
+// parseSelectors parses a comma-separated list of test numbers and ranges,
+// optionally qualified by file (e.g., "2,5-8,users.md:3")
+func parseSelectors(s string) ([]testSelector, error) {
+	var selectors []testSelector
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		var sel testSelector
+		if i := strings.LastIndex(item, ":"); i != -1 {
+			sel.File = item[:i]
+			item = item[i+1:]
+		}
+
+		from, to, isRange := strings.Cut(item, "-")
+		var err error
+		sel.From, err = strconv.Atoi(from)
+		if err != nil || sel.From < 1 {
+			return nil, fmt.Errorf("invalid test number %q", item)
+		}
+		sel.To = sel.From
+		if isRange {
+			sel.To, err = strconv.Atoi(to)
+			if err != nil || sel.To < sel.From {
+				return nil, fmt.Errorf("invalid test range %q", item)
+			}
+		}
+		selectors = append(selectors, sel)
+	}
+	return selectors, nil
+}
+
+// fileMatches reports whether a file selector refers to the test file at path
+// Selectors can be the full path, a trailing part of it, or just the file name
+func fileMatches(selector, path string) bool {
+	selector = filepath.ToSlash(filepath.Clean(selector))
+	path = filepath.ToSlash(filepath.Clean(path))
+	return path == selector || strings.HasSuffix(path, "/"+selector)
+}
+
+// matches reports whether the selector includes a test, given the test's number
+// across all files and its number within its own file
+func (s testSelector) matches(path string, globalNum, fileNum int) bool {
+	if s.File == "" {
+		return globalNum >= s.From && globalNum <= s.To
+	}
+	return fileMatches(s.File, path) && fileNum >= s.From && fileNum <= s.To
+}
+
+// validateSelectors checks that every selected test exists
+func validateSelectors(testFiles []TestFile, selectors []testSelector) error {
+	totalTests := 0
+	for _, tf := range testFiles {
+		totalTests += len(tf.Tests)
+	}
+
+	for _, sel := range selectors {
+		if sel.File == "" {
+			if sel.To > totalTests {
+				return fmt.Errorf("test %d does not exist (file has %d tests)", sel.To, totalTests)
+			}
+			continue
+		}
+
+		found := false
+		for _, tf := range testFiles {
+			if !fileMatches(sel.File, tf.Path) {
+				continue
+			}
+			found = true
+			if sel.To > len(tf.Tests) {
+				return fmt.Errorf("test %s does not exist (%s has %d tests)", sel, tf.Path, len(tf.Tests))
+			}
+		}
+		if !found {
+			return fmt.Errorf("no test file matches %q", sel.File)
+		}
+	}
+	return nil
+}
+
+// selectTests keeps the tests matched by any of the only selectors (or all tests if
+// there are none) and removes the tests matched by any of the skip selectors.
+// Numbers refer to the tests before any are removed. Tests picked with --only are
+// noted with their number. Files left without tests are dropped.
+func selectTests(testFiles []TestFile, only, skip []testSelector) ([]TestFile, error) {
+	if err := validateSelectors(testFiles, only); err != nil {
+		return nil, err
+	}
+	if err := validateSelectors(testFiles, skip); err != nil {
+		return nil, err
+	}
+
+	anyMatch := func(selectors []testSelector, path string, globalNum, fileNum int) bool {
+		for _, sel := range selectors {
+			if sel.matches(path, globalNum, fileNum) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var selected []TestFile
+	globalNum := 0
+	for _, tf := range testFiles {
+		var tests []Test
+		for i, test := range tf.Tests {
+			globalNum++
+			if len(only) > 0 && !anyMatch(only, tf.Path, globalNum, i+1) {
+				continue
+			}
+			if anyMatch(skip, tf.Path, globalNum, i+1) {
+				continue
+			}
+			if len(only) > 0 {
+				test.Note = fmt.Sprintf("#%d", globalNum)
+			}
+			tests = append(tests, test)
+		}
+		if len(tests) > 0 {
+			tf.Tests = tests
+			selected = append(selected, tf)
+		}
+	}
+	return selected, nil
+}
+
+// splitTargetLine splits a "path/to/file.md:42" target into the path and line number
+// Targets without a line number, or that exist on disk as-is, are returned unchanged
+func splitTargetLine(target string) (string, int) {
+	i := strings.LastIndex(target, ":")
+	if i == -1 {
+		return target, 0
+	}
+	if _, err := os.Stat(target); err == nil {
+		return target, 0
+	}
+	line, err := strconv.Atoi(target[i+1:])
+	if err != nil || line < 1 {
+		return target, 0
+	}
+	return target[:i], line
+}
+
+// selectLine keeps only the test whose block contains the given line of its file
+func selectLine(testFiles []TestFile, line int) ([]TestFile, error) {
+	for _, tf := range testFiles {
+		for _, test := range tf.Tests {
+			if line >= test.Line && line <= test.EndLine {
+				tf.Tests = []Test{test}
+				return []TestFile{tf}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no test found at line %d", line)
+}
+
+// variablePattern matches {{variable}} placeholders
+var variablePattern = regexp.MustCompile(`\{\{([^{}\s]+)\}\}`)
+
+// testVariables returns the names of the saved variables a test's request uses
+func testVariables(test Test) []string {
+	sources := []string{test.URL, test.Body, test.Variables}
+	for _, value := range test.Headers {
+		sources = append(sources, value)
+	}
+	for _, part := range test.Multipart {
+		sources = append(sources, part.Value)
+	}
+
+	var names []string
+	for _, source := range sources {
+		for _, match := range variablePattern.FindAllStringSubmatch(source, -1) {
+			names = append(names, match[1])
+		}
+	}
+
+	// A WS test can also use values it saved from earlier messages
+	saved := make(map[string]bool)
+	for _, step := range test.Steps {
+		for _, match := range variablePattern.FindAllStringSubmatch(step.Message, -1) {
+			if !saved[match[1]] {
+				names = append(names, match[1])
+			}
+		}
+		for _, sf := range step.SaveFields {
+			saved[sf.Variable] = true
+		}
+	}
+	return names
+}
+
+// addPrerequisites adds the earlier tests that selected tests depend on for their
+// {{variables}}. For each variable, the closest preceding test that saves it is run,
+// along with anything that test needs in turn. all holds every test before selection.
+func addPrerequisites(selected, all []TestFile) []TestFile {
+	var result []TestFile
+	for _, tf := range selected {
+		var original *TestFile
+		for i := range all {
+			if all[i].Path == tf.Path {
+				original = &all[i]
+				break
+			}
+		}
+		if original == nil {
+			result = append(result, tf)
+			continue
+		}
+
+		// Selected tests are identified by their header line, since names may repeat
+		selectedByLine := make(map[int]Test)
+		for _, test := range tf.Tests {
+			selectedByLine[test.Line] = test
+		}
+
+		// Walk backwards, pulling in the closest earlier test that saves each needed variable
+		include := make([]bool, len(original.Tests))
+		needed := make(map[string]bool)
+		for i := len(original.Tests) - 1; i >= 0; i-- {
+			test := original.Tests[i]
+			_, isSelected := selectedByLine[test.Line]
+			if !isSelected {
+				for _, sf := range test.SaveFields {
+					if needed[sf.Variable] {
+						include[i] = true
+						break
+					}
+				}
+			}
+			if !isSelected && !include[i] {
+				continue
+			}
+			include[i] = true
+			for _, sf := range test.SaveFields {
+				delete(needed, sf.Variable)
+			}
+			for _, name := range testVariables(test) {
+				needed[name] = true
+			}
+		}
+
+		var tests []Test
+		for i, test := range original.Tests {
+			if !include[i] {
+				continue
+			}
+			if selectedTest, ok := selectedByLine[test.Line]; ok {
+				tests = append(tests, selectedTest)
+			} else {
+				test.Note = "prerequisite"
+				tests = append(tests, test)
+			}
+		}
+		tf.Tests = tests
+		result = append(result, tf)
+	}
+	return result
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// encodeGraphQL wraps a query and its optional JSON variables in the standard
+// GraphQL request body
+func encodeGraphQL(query, variables string) (string, error) {
+	request := map[string]interface{}{"query": query}
+	if strings.TrimSpace(variables) != "" {
+		var parsed interface{}
+		if err := json.Unmarshal([]byte(variables), &parsed); err != nil {
+			return "", fmt.Errorf("invalid GraphQL variables: %w", err)
+		}
+		request["variables"] = parsed
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// graphQLErrors returns the messages from the top-level errors array of a GraphQL
+// response, or nil if there are none
+func graphQLErrors(response map[string]interface{}) []string {
+	errs, ok := response["errors"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var messages []string
+	for _, e := range errs {
+		if obj, ok := e.(map[string]interface{}); ok {
+			if message, ok := obj["message"].(string); ok {
+				messages = append(messages, message)
+				continue
+			}
+		}
+		encoded, _ := json.Marshal(e)
+		messages = append(messages, string(encoded))
+	}
+	return messages
+}
+
+// hasAssertion reports whether a test has an assertion of the given type
+func hasAssertion(test Test, assertionType string) bool {
+	for _, assertion := range test.Assertions {
+		if assertion.Type == assertionType {
+			return true
+		}
+	}
+	return false
+}
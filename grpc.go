@@ -0,0 +1,509 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// grpcStatusNames are the gRPC status codes, indexed by code
+var grpcStatusNames = []string{
+	"OK", "CANCELLED", "UNKNOWN", "INVALID_ARGUMENT", "DEADLINE_EXCEEDED", "NOT_FOUND",
+	"ALREADY_EXISTS", "PERMISSION_DENIED", "RESOURCE_EXHAUSTED", "FAILED_PRECONDITION",
+	"ABORTED", "OUT_OF_RANGE", "UNIMPLEMENTED", "INTERNAL", "UNAVAILABLE", "DATA_LOSS",
+	"UNAUTHENTICATED",
+}
+
+// grpcStatusName returns the name of a status code, e.g. 5 -> "NOT_FOUND"
+func grpcStatusName(code int) string {
+	if code >= 0 && code < len(grpcStatusNames) {
+		return grpcStatusNames[code]
+	}
+	return strconv.Itoa(code)
+}
+
+// parseGRPCStatus reads a status code by name ("NOT_FOUND", any case) or number
+func parseGRPCStatus(s string) (int, bool) {
+	s = strings.TrimSpace(s)
+	for code, name := range grpcStatusNames {
+		if strings.EqualFold(s, name) {
+			return code, true
+		}
+	}
+	code, err := strconv.Atoi(s)
+	return code, err == nil
+}
+
+// isGRPC reports whether a test is a gRPC or gRPC-Web call
+func isGRPC(test Test) bool {
+	return test.Method == "GRPC" || test.Method == "GRPC-WEB"
+}
+
+// grpcCall is a unary gRPC call, resolved against its schema
+type grpcCall struct {
+	Web    bool // gRPC-Web over HTTP/1.1 instead of gRPC over HTTP/2
+	Client *http.Client
+	Input  *protoMessage
+	Output *protoMessage
+}
+
+// grpcResult is a decoded gRPC response
+type grpcResult struct {
+	Status  int
+	Message string
+	Body    []byte // Response message as JSON, or the status as JSON if the call failed
+}
+
+var (
+	// grpcTLSTransport sends gRPC calls to https:// URLs, over HTTP/2
+	grpcTLSTransport http.RoundTripper = &http.Transport{Proxy: http.ProxyFromEnvironment, ForceAttemptHTTP2: true}
+
+	// grpcH2CTransport sends gRPC calls to http:// URLs, over HTTP/2 without TLS
+	grpcH2CTransport     http.RoundTripper
+	grpcH2CTransportErr  error
+	grpcH2CTransportOnce sync.Once
+)
+
+// newGRPCCall loads the schema for a gRPC test, from its .proto file or by server
+// reflection, and finds the method named by the last two parts of the URL path,
+// e.g. https://localhost:50051/shop.v1.Orders/GetOrder. transport, if not nil, has
+// the test's TLS settings.
+func newGRPCCall(test Test, transport http.RoundTripper) (*grpcCall, error) {
+	u, err := url.Parse(test.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid gRPC URL %q, expected http(s)://host/package.Service/Method", test.URL)
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid gRPC URL %q, expected http(s)://host/package.Service/Method", test.URL)
+	}
+	serviceName, methodName := parts[len(parts)-2], parts[len(parts)-1]
+
+	call := &grpcCall{Web: test.Method == "GRPC-WEB", Client: &http.Client{Transport: transport}}
+	if !call.Web {
+		// A transport with the test's TLS settings attempts HTTP/2 already
+		switch {
+		case u.Scheme == "http":
+			grpcH2CTransportOnce.Do(func() {
+				grpcH2CTransport, grpcH2CTransportErr = newH2CTransport()
+			})
+			if grpcH2CTransportErr != nil {
+				return nil, grpcH2CTransportErr
+			}
+			call.Client.Transport = grpcH2CTransport
+		case transport == nil:
+			call.Client.Transport = grpcTLSTransport
+		}
+	}
+
+	var schema *protoSchema
+	if test.ProtoFile != "" {
+		schema, err = loadProtoSchema(test.ProtoFile)
+	} else if call.Web {
+		err = fmt.Errorf("gRPC-Web calls need a .proto file (- Proto: path/to/service.proto); server reflection only works over gRPC")
+	} else {
+		base := *u
+		base.Path = "/" + strings.Join(parts[:len(parts)-2], "/")
+		base.RawQuery = ""
+		schema, err = reflectSchema(call, strings.TrimSuffix(base.String(), "/"), serviceName, test.Headers)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	service := schema.Services[serviceName]
+	if service == nil {
+		return nil, fmt.Errorf("gRPC service %s not found", serviceName)
+	}
+	method := service.Methods[methodName]
+	if method == nil {
+		return nil, fmt.Errorf("gRPC method %s not found in service %s", methodName, serviceName)
+	}
+	if method.ClientStreaming || method.ServerStreaming {
+		return nil, fmt.Errorf("gRPC method %s/%s is streaming; only unary calls are supported", serviceName, methodName)
+	}
+	call.Input = schema.Messages[method.Input]
+	call.Output = schema.Messages[method.Output]
+	return call, nil
+}
+
+// encodeRequest encodes a JSON request body as a length-prefixed gRPC message
+func (c *grpcCall) encodeRequest(body string) ([]byte, error) {
+	if strings.TrimSpace(body) == "" {
+		body = "{}"
+	}
+	decoder := json.NewDecoder(strings.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("invalid gRPC request body: %w", err)
+	}
+	message, err := encodeProto(c.Input, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gRPC request body for %s: %w", c.Input.Name, err)
+	}
+
+	frame := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	return append(frame, message...), nil
+}
+
+// setHeaders sets the headers gRPC needs on a request. Other headers are sent as
+// metadata.
+func (c *grpcCall) setHeaders(req *http.Request) {
+	req.Method = http.MethodPost
+	if c.Web {
+		req.Header.Set("Content-Type", "application/grpc-web+proto")
+		req.Header.Set("Accept", "application/grpc-web+proto")
+		req.Header.Set("X-Grpc-Web", "1")
+	} else {
+		req.Header.Set("Content-Type", "application/grpc")
+		req.Header.Set("TE", "trailers")
+	}
+}
+
+// decodeResponse reads the status and message from a gRPC response. The status comes
+// from the trailers (a trailer frame for gRPC-Web), or the headers if the server sent
+// no message.
+func (c *grpcCall) decodeResponse(resp *http.Response, body []byte) (grpcResult, error) {
+	var result grpcResult
+	if resp.StatusCode != http.StatusOK {
+		return result, fmt.Errorf("gRPC request failed: HTTP %d", resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "application/grpc") {
+		return result, fmt.Errorf("gRPC request failed: unexpected Content-Type %q", contentType)
+	}
+
+	trailers := resp.Trailer
+	var messages [][]byte
+	for len(body) > 0 {
+		if len(body) < 5 {
+			return result, fmt.Errorf("gRPC response is truncated")
+		}
+		flags, length := body[0], binary.BigEndian.Uint32(body[1:5])
+		if uint64(len(body)-5) < uint64(length) {
+			return result, fmt.Errorf("gRPC response is truncated")
+		}
+		frame := body[5 : 5+length]
+		body = body[5+length:]
+
+		switch {
+		case flags&0x80 != 0:
+			// gRPC-Web trailers, as HTTP/1 header lines
+			header, err := textproto.NewReader(bufio.NewReader(strings.NewReader(string(frame) + "\r\n\r\n"))).ReadMIMEHeader()
+			if err != nil && err != io.EOF {
+				return result, fmt.Errorf("invalid gRPC-Web trailers: %w", err)
+			}
+			trailers = http.Header(header)
+		case flags&0x01 != 0:
+			return result, fmt.Errorf("compressed gRPC messages are not supported")
+		default:
+			messages = append(messages, frame)
+		}
+	}
+
+	status := trailers.Get("Grpc-Status")
+	if status == "" {
+		status = resp.Header.Get("Grpc-Status")
+		trailers = resp.Header
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return result, fmt.Errorf("gRPC response has no grpc-status")
+	}
+	result.Status = code
+	result.Message, _ = url.PathUnescape(trailers.Get("Grpc-Message"))
+
+	if code != 0 {
+		result.Body, _ = json.Marshal(map[string]interface{}{
+			"code":    code,
+			"status":  grpcStatusName(code),
+			"message": result.Message,
+		})
+		return result, nil
+	}
+	if len(messages) != 1 {
+		return result, fmt.Errorf("expected one gRPC response message, got %d", len(messages))
+	}
+	value, err := decodeProto(c.Output, messages[0])
+	if err != nil {
+		return result, fmt.Errorf("invalid gRPC response: %w", err)
+	}
+	result.Body, err = json.Marshal(value)
+	return result, err
+}
+
+// checkGRPCStatus validates a "gRPC status is ..." assertion
+func checkGRPCStatus(assertion Assertion, result grpcResult) error {
+	expected, ok := parseGRPCStatus(assertion.Value)
+	if !ok {
+		return fmt.Errorf("invalid gRPC status in assertion: %s", assertion.Value)
+	}
+	if result.Status != expected {
+		detail := ""
+		if result.Message != "" {
+			detail = ": " + result.Message
+		}
+		return fmt.Errorf("gRPC status assertion failed: expected %s, got %s%s", grpcStatusName(expected), grpcStatusName(result.Status), detail)
+	}
+	return nil
+}
+
+// invoke makes a unary call outside a test, for server reflection
+func (c *grpcCall) invoke(url string, headers map[string]string, request interface{}) (interface{}, grpcResult, error) {
+	frame, err := c.encodeRequest(mustMarshalJSON(request))
+	if err != nil {
+		return nil, grpcResult{}, err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(frame))
+	if err != nil {
+		return nil, grpcResult{}, err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	c.setHeaders(req)
+
+	waitForRateLimit(req.URL.Host)
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, grpcResult{}, fmt.Errorf("request failed: %w", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, grpcResult{}, fmt.Errorf("failed to read response: %w", err)
+	}
+	result, err := c.decodeResponse(resp, body)
+	if err != nil || result.Status != 0 {
+		return nil, result, err
+	}
+	var response interface{}
+	err = json.Unmarshal(result.Body, &response)
+	return response, result, err
+}
+
+func mustMarshalJSON(value interface{}) string {
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+var (
+	reflectedSchemas   = make(map[string]*protoSchema)
+	reflectedSchemasMu sync.Mutex
+)
+
+// reflectSchema loads the schema of a service from the server reflection service,
+// trying v1 and then v1alpha
+func reflectSchema(call *grpcCall, baseURL, service string, headers map[string]string) (*protoSchema, error) {
+	key := baseURL + " " + service
+	reflectedSchemasMu.Lock()
+	defer reflectedSchemasMu.Unlock()
+	if schema, ok := reflectedSchemas[key]; ok {
+		return schema, nil
+	}
+
+	builtins, err := loadProtoSchema("grpc/reflection/v1/reflection.proto")
+	if err != nil {
+		return nil, err
+	}
+	reflection := &grpcCall{
+		Client: call.Client,
+		Input:  builtins.Messages["grpc.reflection.v1.ServerReflectionRequest"],
+		Output: builtins.Messages["grpc.reflection.v1.ServerReflectionResponse"],
+	}
+
+	endpoint := baseURL + "/grpc.reflection.v1.ServerReflection/ServerReflectionInfo"
+	fetch := func(request map[string]interface{}) ([]interface{}, error) {
+		response, result, err := reflection.invoke(endpoint, headers, request)
+		if err == nil && result.Status == 12 && strings.Contains(endpoint, ".v1.") {
+			// UNIMPLEMENTED: older servers only have v1alpha, which has the same messages
+			endpoint = baseURL + "/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo"
+			response, result, err = reflection.invoke(endpoint, headers, request)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("server reflection failed: %w", err)
+		}
+		if result.Status != 0 {
+			return nil, fmt.Errorf("server reflection failed: %s: %s (use - Proto: to give a .proto file instead)", grpcStatusName(result.Status), result.Message)
+		}
+		obj, _ := response.(map[string]interface{})
+		if errResponse, ok := obj["errorResponse"].(map[string]interface{}); ok {
+			return nil, fmt.Errorf("server reflection failed: %v", errResponse["errorMessage"])
+		}
+		files, _ := obj["fileDescriptorResponse"].(map[string]interface{})
+		encoded, _ := files["fileDescriptorProto"].([]interface{})
+		return encoded, nil
+	}
+
+	fileType := builtins.Messages["google.protobuf.FileDescriptorProto"]
+	descriptors := make(map[string]map[string]interface{})
+	add := func(encoded []interface{}) error {
+		for _, item := range encoded {
+			data, err := decodeBase64(item.(string))
+			if err != nil {
+				return err
+			}
+			value, err := decodeProto(fileType, data)
+			if err != nil {
+				return fmt.Errorf("server reflection returned an invalid descriptor: %w", err)
+			}
+			fd := value.(map[string]interface{})
+			name, _ := fd["name"].(string)
+			descriptors[name] = fd
+		}
+		return nil
+	}
+
+	encoded, err := fetch(map[string]interface{}{"fileContainingSymbol": service})
+	if err != nil {
+		return nil, err
+	}
+	if err := add(encoded); err != nil {
+		return nil, err
+	}
+	// Servers usually send every dependency up front; ask for any that are missing
+	for missing := true; missing; {
+		missing = false
+		for _, fd := range descriptors {
+			deps, _ := fd["dependency"].([]interface{})
+			for _, dep := range deps {
+				name := dep.(string)
+				if _, ok := descriptors[name]; ok {
+					continue
+				}
+				encoded, err := fetch(map[string]interface{}{"fileByFilename": name})
+				if err != nil {
+					return nil, err
+				}
+				if err := add(encoded); err != nil {
+					return nil, err
+				}
+				if _, ok := descriptors[name]; !ok {
+					// Don't ask again; a type from it fails to resolve if it's needed
+					descriptors[name] = map[string]interface{}{"name": name}
+				}
+				missing = true
+			}
+		}
+	}
+
+	schema := newProtoSchema()
+	for _, fd := range descriptors {
+		addFileDescriptor(schema, fd)
+	}
+	if err := schema.resolve(); err != nil {
+		return nil, fmt.Errorf("server reflection: %w", err)
+	}
+	reflectedSchemas[key] = schema
+	return schema, nil
+}
+
+// addFileDescriptor adds the definitions from a decoded FileDescriptorProto to schema
+func addFileDescriptor(schema *protoSchema, fd map[string]interface{}) {
+	pkg, _ := fd["package"].(string)
+	syntax, _ := fd["syntax"].(string)
+	proto3 := syntax == "proto3" || syntax == "editions"
+
+	for _, m := range descriptorList(fd, "messageType") {
+		addMessageDescriptor(schema, pkg, m, proto3)
+	}
+	for _, e := range descriptorList(fd, "enumType") {
+		addEnumDescriptor(schema, pkg, e)
+	}
+	for _, s := range descriptorList(fd, "service") {
+		name, _ := s["name"].(string)
+		service := &protoService{Name: qualifyProtoName(pkg, name), Methods: make(map[string]*protoMethod)}
+		for _, m := range descriptorList(s, "method") {
+			method := &protoMethod{Scope: pkg}
+			method.Name, _ = m["name"].(string)
+			method.Input, _ = m["inputType"].(string)
+			method.Output, _ = m["outputType"].(string)
+			method.ClientStreaming, _ = m["clientStreaming"].(bool)
+			method.ServerStreaming, _ = m["serverStreaming"].(bool)
+			service.Methods[method.Name] = method
+		}
+		schema.Services[service.Name] = service
+	}
+}
+
+func addMessageDescriptor(schema *protoSchema, scope string, m map[string]interface{}, proto3 bool) {
+	name, _ := m["name"].(string)
+	msg := &protoMessage{Name: qualifyProtoName(scope, name)}
+	if options, ok := m["options"].(map[string]interface{}); ok {
+		msg.MapEntry, _ = options["mapEntry"].(bool)
+	}
+	schema.Messages[msg.Name] = msg
+
+	for _, f := range descriptorList(m, "field") {
+		field := &protoField{}
+		field.Name, _ = f["name"].(string)
+		field.JSONName, _ = f["jsonName"].(string)
+		if field.JSONName == "" {
+			field.JSONName = protoJSONName(field.Name)
+		}
+		number, _ := jsonToInt(f["number"], 32)
+		field.Number = int(number)
+		label, _ := f["label"].(string)
+		field.Repeated = label == "LABEL_REPEATED"
+		_, inOneof := f["oneofIndex"]
+		field.Optional = inOneof || (!proto3 && label == "LABEL_OPTIONAL")
+
+		kind, _ := f["type"].(string)
+		kind = strings.ToLower(strings.TrimPrefix(kind, "TYPE_"))
+		if kind == "message" || kind == "enum" {
+			typeName, _ := f["typeName"].(string)
+			field.setKind(typeName, msg.Name)
+		} else {
+			field.setKind(kind, msg.Name)
+		}
+
+		packed := proto3
+		if options, ok := f["options"].(map[string]interface{}); ok {
+			if p, ok := options["packed"].(bool); ok {
+				packed = p
+			}
+		}
+		field.Packed = field.Repeated && packed
+		msg.Fields = append(msg.Fields, field)
+	}
+
+	for _, nested := range descriptorList(m, "nestedType") {
+		addMessageDescriptor(schema, msg.Name, nested, proto3)
+	}
+	for _, e := range descriptorList(m, "enumType") {
+		addEnumDescriptor(schema, msg.Name, e)
+	}
+}
+
+func addEnumDescriptor(schema *protoSchema, scope string, e map[string]interface{}) {
+	name, _ := e["name"].(string)
+	enum := &protoEnum{Name: qualifyProtoName(scope, name), Values: make(map[string]int32), Names: make(map[int32]string)}
+	for _, v := range descriptorList(e, "value") {
+		valueName, _ := v["name"].(string)
+		number, _ := jsonToInt(v["number"], 32)
+		enum.addValue(valueName, int32(number))
+	}
+	schema.Enums[enum.Name] = enum
+}
+
+// descriptorList returns a repeated message field of a decoded descriptor
+func descriptorList(obj map[string]interface{}, key string) []map[string]interface{} {
+	var list []map[string]interface{}
+	items, _ := obj[key].([]interface{})
+	for _, item := range items {
+		if m, ok := item.(map[string]interface{}); ok {
+			list = append(list, m)
+		}
+	}
+	return list
+}
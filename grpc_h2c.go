@@ -0,0 +1,13 @@
+//go:build go1.24
+
+package main
+
+import "net/http"
+
+// newH2CTransport returns a transport that speaks HTTP/2 without TLS (h2c), which
+// gRPC servers use on plaintext ports
+func newH2CTransport() (http.RoundTripper, error) {
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	return &http.Transport{Proxy: http.ProxyFromEnvironment, Protocols: protocols}, nil
+}
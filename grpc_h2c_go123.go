@@ -0,0 +1,14 @@
+//go:build !go1.24
+
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// newH2CTransport would return an HTTP/2 transport without TLS, but net/http only
+// supports that from Go 1.24
+func newH2CTransport() (http.RoundTripper, error) {
+	return nil, errors.New("gRPC over http:// (h2c) needs marcus built with Go 1.24 or later; use an https:// URL or GRPC-WEB")
+}
@@ -1,16 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 // interpolateVariables replaces {{variable}} placeholders with saved values
@@ -29,16 +34,68 @@ func interpolateVariables(s string, vars map[string]interface{}) string {
 // runTest executes a single test and validates its assertions
 // vars contains saved variables from previous tests, and returns updated variables
 func runTest(test Test, vars map[string]interface{}) (map[string]interface{}, error) {
+	vars, _, err := runTestTimed(test, vars)
+	return vars, err
+}
+
+// runTestTimed executes a test, repeating it if requested, and returns the duration
+// of each repetition. Assertions on duration statistics are checked once all
+// repetitions have finished.
+func runTestTimed(test Test, vars map[string]interface{}) (map[string]interface{}, []time.Duration, error) {
+	repeat := test.Repeat
+	if repeat < 1 {
+		repeat = 1
+	}
+
+	var durations []time.Duration
+	for i := 0; i < repeat; i++ {
+		var duration time.Duration
+		var err error
+		vars, duration, err = runRequest(test, vars)
+		if err != nil {
+			if repeat > 1 {
+				err = fmt.Errorf("repetition %d of %d: %w", i+1, repeat, err)
+			}
+			return vars, durations, err
+		}
+		durations = append(durations, duration)
+	}
+
+	for _, assertion := range test.Assertions {
+		if assertion.Type == "duration_stat" {
+			if err := validateDurationStat(assertion, durations); err != nil {
+				return vars, durations, err
+			}
+		}
+	}
+
+	return vars, durations, nil
+}
+
+// runRequest sends a test's request once (polling if it waits for a status or field)
+// and validates its assertions against the response
+func runRequest(test Test, vars map[string]interface{}) (map[string]interface{}, time.Duration, error) {
 	if vars == nil {
 		vars = make(map[string]interface{})
 	}
 
-	// Interpolate variables in URL, headers, and body
+	// Interpolate variables in URL, headers, and body (unless the body is binary)
+	// Headers are copied so a test that runs more than once (e.g. a hook) keeps its placeholders
 	test.URL = interpolateVariables(test.URL, vars)
-	test.Body = interpolateVariables(test.Body, vars)
+	if !test.RawBody {
+		test.Body = interpolateVariables(test.Body, vars)
+	}
+	test.Variables = interpolateVariables(test.Variables, vars)
+	headers := make(map[string]string, len(test.Headers))
 	for key, value := range test.Headers {
-		test.Headers[key] = interpolateVariables(value, vars)
+		headers[key] = interpolateVariables(value, vars)
 	}
+	test.Headers = headers
+
+	if test.Method == "WS" {
+		return runWebSocket(test, vars)
+	}
+
 	// Apply retry defaults
 	retryDelay := test.RetryDelay
 	if retryDelay == 0 {
@@ -51,8 +108,21 @@ func runTest(test Test, vars map[string]interface{}) (map[string]interface{}, er
 
 	// Prepare the request body content (needed for potential retries)
 	var bodyContent string
-	if test.Body != "" {
-		if test.ContentType == "application/x-www-form-urlencoded" {
+	if test.GraphQL {
+		var err error
+		bodyContent, err = encodeGraphQL(test.Body, test.Variables)
+		if err != nil {
+			return vars, 0, err
+		}
+	} else if len(test.Multipart) > 0 {
+		// The Content-Type carries the boundary, so it replaces any set on the test
+		var err error
+		bodyContent, test.ContentType, err = encodeMultipart(test.Multipart, vars)
+		if err != nil {
+			return vars, 0, err
+		}
+	} else if test.Body != "" {
+		if test.ContentType == "application/x-www-form-urlencoded" && !test.RawBody {
 			formData := url.Values{}
 			for _, line := range strings.Split(test.Body, "\n") {
 				line = strings.TrimSpace(line)
@@ -70,9 +140,29 @@ func runTest(test Test, vars map[string]interface{}) (map[string]interface{}, er
 		}
 	}
 
-	client := &http.Client{}
+	// gRPC calls send the body as a protobuf message and read the response back as JSON
+	transport, err := tlsTransport(test)
+	if err != nil {
+		return vars, 0, err
+	}
+	client := &http.Client{Transport: transport}
+	var rpc *grpcCall
+	if isGRPC(test) {
+		if rpc, err = newGRPCCall(test, transport); err != nil {
+			return vars, 0, err
+		}
+		frame, err := rpc.encodeRequest(bodyContent)
+		if err != nil {
+			return vars, 0, err
+		}
+		bodyContent = string(frame)
+		client = rpc.Client
+	}
+
 	var lastStatusCode int
 	var attempt int
+	var duration time.Duration
+	var reauthorized bool
 
 	for {
 		attempt++
@@ -85,7 +175,7 @@ func runTest(test Test, vars map[string]interface{}) (map[string]interface{}, er
 
 		req, err := http.NewRequest(test.Method, test.URL, bodyReader)
 		if err != nil {
-			return vars, fmt.Errorf("failed to create request: %w", err)
+			return vars, duration, fmt.Errorf("failed to create request: %w", err)
 		}
 
 		// Set headers
@@ -95,28 +185,67 @@ func runTest(test Test, vars map[string]interface{}) (map[string]interface{}, er
 		if test.ContentType != "" {
 			req.Header.Set("Content-Type", test.ContentType)
 		}
+		if rpc != nil {
+			rpc.setHeaders(req)
+		}
+		usesAuth := req.Header.Get("Authorization") == ""
+		if err := applyAuth(req, test.Auth, transport); err != nil {
+			return vars, duration, err
+		}
+
+		// Respect global and per-host rate limits before sending
+		waitForRateLimit(req.URL.Host)
+
+		// Signed last, after waiting, so the signature covers the final headers and body
+		// and its timestamp is fresh
+		if err := signRequest(req, []byte(bodyContent), test.Signing); err != nil {
+			return vars, duration, err
+		}
 
 		// Execute request and measure duration
 		start := time.Now()
 		resp, err := client.Do(req)
 		if err != nil {
-			return vars, fmt.Errorf("request failed: %w", err)
+			return vars, duration, fmt.Errorf("request failed: %w", err)
 		}
 
-		// Read response body
-		respBody, err := io.ReadAll(resp.Body)
+		// Read response body. Event streams may never end, so they are read until the
+		// test has the events it wants.
+		var respBody []byte
+		var events []sseEvent
+		if isEventStream(resp.Header) {
+			events, respBody, err = readEvents(resp, test)
+		} else {
+			respBody, err = io.ReadAll(resp.Body)
+		}
 		resp.Body.Close()
-		duration := time.Since(start)
+		duration = time.Since(start)
 		if err != nil {
-			return vars, fmt.Errorf("failed to read response: %w", err)
+			return vars, duration, fmt.Errorf("failed to read response: %w", err)
 		}
 
 		lastStatusCode = resp.StatusCode
 
+		// An OAuth2 token can be revoked before it expires, so a 401 gets one retry
+		// with a new token
+		if resp.StatusCode == http.StatusUnauthorized && usesAuth && !reauthorized && refreshAuth(test.Auth) {
+			reauthorized = true
+			attempt--
+			continue
+		}
+
+		var rpcResult grpcResult
+		if rpc != nil {
+			if rpcResult, err = rpc.decodeResponse(resp, respBody); err != nil {
+				return vars, duration, err
+			}
+			respBody = rpcResult.Body
+		}
+
 		// If waiting for a specific status and we haven't got it yet
 		if test.WaitForStatus != 0 && resp.StatusCode != test.WaitForStatus {
 			if attempt >= retryMax {
-				return vars, fmt.Errorf("wait for status %d failed: got %d after %d attempts", test.WaitForStatus, lastStatusCode, attempt)
+				return vars, duration, fmt.Errorf("wait for status %d failed: got %d after %d attempts", test.WaitForStatus, lastStatusCode, attempt)
 			}
 			time.Sleep(retryDelay)
 			continue
@@ -133,19 +262,55 @@ func runTest(test Test, vars map[string]interface{}) (map[string]interface{}, er
 			if err != nil || !valuesEqual(actual, expected) {
 				if attempt >= retryMax {
 					if err != nil {
-						return vars, fmt.Errorf("wait for field `%s` failed: field not found after %d attempts", test.WaitForField, attempt)
+						return vars, duration, fmt.Errorf("wait for field `%s` failed: field not found after %d attempts", test.WaitForField, attempt)
 					}
-					return vars, fmt.Errorf("wait for field `%s` equals `%s` failed: got `%v` after %d attempts", test.WaitForField, test.WaitForValue, actual, attempt)
+					return vars, duration, fmt.Errorf("wait for field `%s` equals `%s` failed: got `%v` after %d attempts", test.WaitForField, test.WaitForValue, actual, attempt)
 				}
 				time.Sleep(retryDelay)
 				continue
 			}
 		}
 
+		// GraphQL reports errors in the body, often with a 200 status, so they fail the
+		// test unless it checks for them
+		if test.GraphQL && !hasAssertion(test, "graphql_errors_contain") {
+			if messages := graphQLErrors(respJSON); len(messages) > 0 {
+				return vars, duration, fmt.Errorf("graphql request failed: %s", strings.Join(messages, "; "))
+			}
+		}
+
+		// gRPC errors also come with a 200 status, so a status other than OK fails the
+		// test unless it checks for one
+		if rpc != nil {
+			if !hasAssertion(test, "grpc_status") && rpcResult.Status != 0 {
+				return vars, duration, fmt.Errorf("grpc request failed: %s: %s", grpcStatusName(rpcResult.Status), rpcResult.Message)
+			}
+			for _, assertion := range test.Assertions {
+				if assertion.Type == "grpc_status" {
+					if err := checkGRPCStatus(assertion, rpcResult); err != nil {
+						return vars, duration, err
+					}
+				}
+			}
+		}
+
+		for _, assertion := range test.Assertions {
+			if strings.HasPrefix(assertion.Type, "event_") {
+				if err := checkEventAssertion(assertion, events); err != nil {
+					return vars, duration, err
+				}
+			}
+			if assertion.Type == "tls_version" || assertion.Type == "cert_expiry" {
+				if err := checkTLSAssertion(assertion, resp.TLS); err != nil {
+					return vars, duration, err
+				}
+			}
+		}
+
 		// Validate assertions
 		for _, assertion := range test.Assertions {
 			if err := validateAssertion(assertion, resp.StatusCode, respBody, respJSON, duration); err != nil {
-				return vars, err
+				return vars, duration, err
 			}
 		}
 
@@ -153,12 +318,12 @@ func runTest(test Test, vars map[string]interface{}) (map[string]interface{}, er
 		for _, sf := range test.SaveFields {
 			value, err := getJSONField(respJSON, sf.Field)
 			if err != nil {
-				return vars, fmt.Errorf("save field failed: %w", err)
+				return vars, duration, fmt.Errorf("save field failed: %w", err)
 			}
 			vars[sf.Variable] = value
 		}
 
-		return vars, nil
+		return vars, duration, nil
 	}
 }
 
@@ -173,7 +338,9 @@ func validateAssertion(assertion Assertion, statusCode int, body []byte, jsonBod
 		if statusCode != expected {
 			// Include response body in error for debugging (truncate if too long)
 			bodyPreview := string(body)
-			if len(bodyPreview) > 500 {
+			if isBinary(body) {
+				bodyPreview = describeBinary(body)
+			} else if len(bodyPreview) > 500 {
 				bodyPreview = bodyPreview[:500] + "..."
 			}
 			if bodyPreview != "" {
@@ -231,6 +398,18 @@ func validateAssertion(assertion Assertion, statusCode int, body []byte, jsonBod
 			}
 		}
 
+	case "duration_stat":
+		// Checked across all repetitions by runTestTimed
+
+	case "grpc_status":
+		// Checked by runRequest, which has the gRPC status
+
+	case "event_count", "event_type", "event_id", "event_data", "event_data_includes", "event_field":
+		// Checked by runRequest, which has the events
+
+	case "tls_version", "cert_expiry":
+		// Checked by runRequest, which has the connection state
+
 	case "duration":
 		maxDuration, err := parseDuration(assertion.Value)
 		if err != nil {
@@ -245,22 +424,103 @@ func validateAssertion(assertion Assertion, statusCode int, body []byte, jsonBod
 		if err != nil {
 			return fmt.Errorf("body matches file assertion failed: could not read file '%s': %w", assertion.Value, err)
 		}
-		// Normalize JSON for comparison (re-marshal both to handle formatting differences)
-		var expectedJSON, actualJSON interface{}
-		if err := json.Unmarshal(expectedContent, &expectedJSON); err != nil {
-			// Not JSON, do exact string comparison
-			if string(body) != string(expectedContent) {
-				return fmt.Errorf("body matches file assertion failed: response does not match file '%s'", assertion.Value)
+		diff, err := compareBodies(expectedContent, body, assertion.Ignore, jsonMatchOptions{IgnoreOrder: assertion.IgnoreOrder})
+		if err != nil {
+			return fmt.Errorf("body matches file assertion failed: %w", err)
+		}
+		if diff != nil {
+			return fmt.Errorf("body matches file assertion failed: response does not match file '%s'%s", assertion.Value, diffDetail(diff))
+		}
+
+	case "body_matches_snapshot":
+		if err := validateSnapshot(assertion, body); err != nil {
+			return err
+		}
+
+	case "graphql_errors_contain":
+		messages := graphQLErrors(jsonBody)
+		for _, message := range messages {
+			if strings.Contains(message, assertion.Value) {
+				return nil
 			}
-		} else {
-			if err := json.Unmarshal(body, &actualJSON); err != nil {
-				return fmt.Errorf("body matches file assertion failed: response is not valid JSON")
+		}
+		if len(messages) == 0 {
+			return fmt.Errorf("graphql errors assertion failed: expected an error containing '%s', got no errors", assertion.Value)
+		}
+		return fmt.Errorf("graphql errors assertion failed: expected an error containing '%s', got: %s", assertion.Value, strings.Join(messages, "; "))
+
+	case "body_size":
+		expected, err := strconv.Atoi(assertion.Value)
+		if err != nil {
+			return fmt.Errorf("invalid size in assertion: %s", assertion.Value)
+		}
+		if len(body) != expected {
+			return fmt.Errorf("body size assertion failed: expected %d bytes, got %d", expected, len(body))
+		}
+
+	case "body_sha256":
+		sum := sha256.Sum256(body)
+		actual := hex.EncodeToString(sum[:])
+		if !strings.EqualFold(actual, assertion.Value) {
+			return fmt.Errorf("body sha256 assertion failed: expected %s, got %s", strings.ToLower(assertion.Value), actual)
+		}
+
+	case "body_includes_text":
+		if !strings.Contains(string(body), assertion.Value) {
+			return fmt.Errorf("body includes text assertion failed: response does not include '%s'", assertion.Value)
+		}
+
+	case "body_matches_regex":
+		re, err := regexp.Compile(assertion.Value)
+		if err != nil {
+			return fmt.Errorf("invalid regex in assertion: %s", assertion.Value)
+		}
+		if !re.Match(body) {
+			return fmt.Errorf("body matches regex assertion failed: response does not match /%s/", assertion.Value)
+		}
+
+	case "xpath_exists", "xpath_count", "xpath_equals":
+		// XML is parsed strictly, falling back to lenient HTML parsing for web pages
+		root, err := parseMarkup(body, false)
+		if err != nil {
+			if root, err = parseMarkup(body, true); err != nil {
+				return fmt.Errorf("xpath assertion failed: response is not valid XML or HTML")
 			}
-			expectedNorm, _ := json.Marshal(expectedJSON)
-			actualNorm, _ := json.Marshal(actualJSON)
-			if string(expectedNorm) != string(actualNorm) {
-				return fmt.Errorf("body matches file assertion failed: response does not match file '%s'", assertion.Value)
+		}
+		nodes, err := evalXPath(root, assertion.Field)
+		if err != nil {
+			return fmt.Errorf("xpath assertion failed: %w", err)
+		}
+		if err := validateNodes("xpath", "XPath `"+assertion.Field+"`", assertion, nodes); err != nil {
+			return err
+		}
+
+	case "element_exists", "element_count", "element_text":
+		root, err := parseMarkup(body, true)
+		if err != nil {
+			return fmt.Errorf("element assertion failed: response is not valid HTML")
+		}
+		nodes, err := selectCSS(root, assertion.Field)
+		if err != nil {
+			return fmt.Errorf("element assertion failed: %w", err)
+		}
+		if err := validateNodes("element", "Element `"+assertion.Field+"`", assertion, nodes); err != nil {
+			return err
+		}
+
+	case "body_subset_match":
+		var expected, actual interface{}
+		if err := json.Unmarshal([]byte(assertion.Value), &expected); err != nil {
+			return fmt.Errorf("body partial match assertion failed: invalid JSON in expected block: %w", err)
+		}
+		if err := json.Unmarshal(body, &actual); err != nil {
+			return fmt.Errorf("body partial match assertion failed: response is not valid JSON")
+		}
+		if diff := jsonDiff("", expected, actual, jsonMatchOptions{Subset: true}); diff != nil {
+			if len(diff) == 1 {
+				return fmt.Errorf("body partial match assertion failed: %s", diff[0])
 			}
+			return fmt.Errorf("body partial match assertion failed: %d differences%s", len(diff), diffDetail(diff))
 		}
 
 	case "body_partial_match":
@@ -269,6 +529,8 @@ func validateAssertion(assertion Assertion, statusCode int, body []byte, jsonBod
 		}
 		// Each line in Value is a JSON key-value pair to check
 		// Format: "field": value  or  "field": "value"
+		// Every line is checked so the failure lists all fields that differ
+		var mismatches []string
 		for _, line := range strings.Split(assertion.Value, "\n") {
 			line = strings.TrimSpace(line)
 			if line == "" {
@@ -289,18 +551,203 @@ func validateAssertion(assertion Assertion, statusCode int, body []byte, jsonBod
 			for field, expected := range parsed {
 				actual, err := getJSONField(jsonBody, field)
 				if err != nil {
-					return fmt.Errorf("body partial match assertion failed: %w", err)
+					mismatches = append(mismatches, fmt.Sprintf("%s: missing, expected %s", field, formatDiffValue(expected)))
+					continue
+				}
+				if matcher, ok := expected.(string); ok && isMatcher(matcher) {
+					if err := matchValue(matcher, actual); err != nil {
+						mismatches = append(mismatches, fmt.Sprintf("%s: %v", field, err))
+					}
+					continue
 				}
 				if !valuesEqual(actual, expected) {
-					return fmt.Errorf("body partial match assertion failed: field '%s' expected %v, got %v", field, expected, actual)
+					mismatches = append(mismatches, fmt.Sprintf("%s: expected %s, got %s", field, formatDiffValue(expected), formatDiffValue(actual)))
 				}
 			}
 		}
+		if len(mismatches) == 1 {
+			return fmt.Errorf("body partial match assertion failed: %s", mismatches[0])
+		}
+		if len(mismatches) > 1 {
+			return fmt.Errorf("body partial match assertion failed: %d fields differ%s", len(mismatches), diffDetail(mismatches))
+		}
 	}
 
 	return nil
 }
 
+// validateNodes checks the nodes selected by an XPath or CSS selector assertion
+// (kind is "xpath" or "element", label names the expression in errors):
+// that there are any ("_exists"), how many there are ("_count"), or that one of them
+// has the expected text ("_equals" or "_text")
+func validateNodes(kind, label string, assertion Assertion, nodes []*markupNode) error {
+	switch strings.TrimPrefix(assertion.Type, kind+"_") {
+	case "exists":
+		if len(nodes) == 0 {
+			return fmt.Errorf("%s assertion failed: %s matched nothing", kind, label)
+		}
+	case "count":
+		expected, err := strconv.Atoi(assertion.Value)
+		if err != nil {
+			return fmt.Errorf("invalid count in assertion: %s", assertion.Value)
+		}
+		if len(nodes) != expected {
+			return fmt.Errorf("%s assertion failed: expected %s to match %d, got %d", kind, label, expected, len(nodes))
+		}
+	default:
+		if len(nodes) == 0 {
+			return fmt.Errorf("%s assertion failed: %s matched nothing", kind, label)
+		}
+		var found []string
+		for _, node := range nodes {
+			text := node.textContent()
+			if text == assertion.Value {
+				return nil
+			}
+			found = append(found, fmt.Sprintf("%q", text))
+		}
+		if len(found) > 5 {
+			found = append(found[:5], fmt.Sprintf("and %d more", len(found)-5))
+		}
+		return fmt.Errorf("%s assertion failed: expected %s to have text %q, got %s", kind, label, assertion.Value, strings.Join(found, ", "))
+	}
+	return nil
+}
+
+// validateDurationStat checks an aggregate duration assertion (e.g. "p95 duration less than 300ms")
+// against the durations of every repetition of a test
+func validateDurationStat(assertion Assertion, durations []time.Duration) error {
+	maxDuration, err := parseDuration(assertion.Value)
+	if err != nil {
+		return fmt.Errorf("invalid duration in assertion: %s", assertion.Value)
+	}
+
+	var actual time.Duration
+	var label string
+	switch assertion.Field {
+	case "avg":
+		actual = averageDuration(durations)
+		label = "average duration"
+	case "min":
+		actual = percentile(durations, 0)
+		label = "min duration"
+	case "max":
+		actual = percentile(durations, 100)
+		label = "max duration"
+	default:
+		p, err := strconv.ParseFloat(strings.TrimPrefix(assertion.Field, "p"), 64)
+		if err != nil {
+			return fmt.Errorf("invalid percentile in assertion: %s", assertion.Field)
+		}
+		actual = percentile(durations, p)
+		label = assertion.Field + " duration"
+	}
+
+	if actual >= maxDuration {
+		return fmt.Errorf("%s assertion failed: expected < %s, got %s over %d runs", label, formatDuration(maxDuration), formatDuration(actual), len(durations))
+	}
+	return nil
+}
+
+// compareBodies compares a response body against expected content and returns the
+// differences, or nil if they match. JSON is compared structurally so formatting and
+// key order don't matter, the fields at the ignore paths are removed from both sides
+// first, and matchers like "<uuid>" in the expected JSON accept any matching value.
+// Anything else must match exactly.
+func compareBodies(expected, actual []byte, ignore []string, opts jsonMatchOptions) ([]string, error) {
+	var expectedJSON, actualJSON interface{}
+	if err := json.Unmarshal(expected, &expectedJSON); err != nil {
+		// Not JSON, do exact string comparison
+		if string(actual) == string(expected) {
+			return nil, nil
+		}
+		if isBinary(expected) || isBinary(actual) {
+			return binaryDiff(expected, actual), nil
+		}
+		if diff := textDiff(string(expected), string(actual)); len(diff) > 0 {
+			return diff, nil
+		}
+		return []string{"bodies differ only in trailing newline"}, nil
+	}
+	if err := json.Unmarshal(actual, &actualJSON); err != nil {
+		return nil, fmt.Errorf("response is not valid JSON")
+	}
+
+	for _, path := range ignore {
+		removeJSONPath(expectedJSON, path)
+		removeJSONPath(actualJSON, path)
+	}
+	return jsonDiff("", expectedJSON, actualJSON, opts), nil
+}
+
+// removeJSONPath removes the field at a dot-notation path from parsed JSON
+// A name without dots (e.g. "id") is removed at any depth; "*" matches every key
+// or array element (e.g. "items.*.created_at"). Array elements are set to null
+// rather than removed so the positions of other elements don't change.
+func removeJSONPath(data interface{}, path string) {
+	if !strings.Contains(path, ".") && path != "*" {
+		removeKeyEverywhere(data, path)
+		return
+	}
+	removeJSONPathParts(data, strings.Split(path, "."))
+}
+
+// removeJSONPathParts removes the field at the path made up of parts
+func removeJSONPathParts(data interface{}, parts []string) {
+	part, rest := parts[0], parts[1:]
+
+	switch v := data.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if part != "*" && key != part {
+				continue
+			}
+			if len(rest) == 0 {
+				delete(v, key)
+			} else {
+				removeJSONPathParts(child, rest)
+			}
+		}
+	case []interface{}:
+		for i, child := range v {
+			if part != "*" && part != strconv.Itoa(i) {
+				continue
+			}
+			if len(rest) == 0 {
+				v[i] = nil
+			} else {
+				removeJSONPathParts(child, rest)
+			}
+		}
+	}
+}
+
+// removeKeyEverywhere removes a key from every object in parsed JSON
+func removeKeyEverywhere(data interface{}, key string) {
+	switch v := data.(type) {
+	case map[string]interface{}:
+		delete(v, key)
+		for _, child := range v {
+			removeKeyEverywhere(child, key)
+		}
+	case []interface{}:
+		for _, child := range v {
+			removeKeyEverywhere(child, key)
+		}
+	}
+}
+
+// isBinary reports whether content looks like binary data rather than text
+func isBinary(content []byte) bool {
+	return bytes.IndexByte(content, 0) != -1 || !utf8.Valid(content)
+}
+
+// describeBinary summarizes binary content for output instead of printing it
+func describeBinary(content []byte) string {
+	sum := sha256.Sum256(content)
+	return fmt.Sprintf("<binary, %d bytes, sha256 %s>", len(content), hex.EncodeToString(sum[:]))
+}
+
 // splitFieldTransforms separates a field path from pipe-separated transforms.
 // e.g. "data.token | base64" returns ("data.token", ["base64"])
 func splitFieldTransforms(field string) (string, []string) {
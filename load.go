@@ -0,0 +1,372 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// loadStats collects per-test latencies and errors from all virtual users
+type loadStats struct {
+	mu    sync.Mutex
+	order []string // Test names in the order they were first seen
+	tests map[string]*testStats
+}
+
+// testStats holds the samples recorded for a single test name
+type testStats struct {
+	Name      string
+	Requests  int             // Every request, including failed ones
+	Durations []time.Duration // Latencies of successful requests only
+	Errors    int
+	FirstErr  error
+	Hook      bool // A failed "before all" or "after all" hook, which only runs once
+}
+
+// loadThreshold is a pass/fail condition on load test results
+// e.g. "p99<800ms", "avg<200ms", "errors<1%", "rps>50"
+type loadThreshold struct {
+	Metric string // "p50", "p99.9", "avg", "min", "max", "errors" or "rps"
+	Op     string // "<" or ">"
+	Value  float64
+	Raw    string
+}
+
+func newLoadStats() *loadStats {
+	return &loadStats{tests: make(map[string]*testStats)}
+}
+
+// record adds a single test result to the stats
+func (s *loadStats) record(label string, result TestResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ts, ok := s.tests[label]
+	if !ok {
+		ts = &testStats{Name: label, Hook: result.Index < 0}
+		s.tests[label] = ts
+		s.order = append(s.order, label)
+	}
+	// A failed or skipped result has no latency, so it only counts as an error;
+	// recording it as 0ms would make the percentiles look better than they are.
+	// Samples are the repetitions that succeeded before a failure.
+	ts.Requests += len(result.Samples)
+	ts.Durations = append(ts.Durations, result.Samples...)
+	if result.Err != nil {
+		ts.Requests++
+		ts.Errors++
+		if ts.FirstErr == nil {
+			ts.FirstErr = result.Err
+		}
+	}
+}
+
+// rows returns the stats for each test in order, followed by a combined total
+func (s *loadStats) rows() []*testStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	total := &testStats{Name: "Total"}
+	var rows []*testStats
+	for _, name := range s.order {
+		ts := s.tests[name]
+		rows = append(rows, ts)
+		total.Requests += ts.Requests
+		total.Durations = append(total.Durations, ts.Durations...)
+		total.Errors += ts.Errors
+	}
+	return append(rows, total)
+}
+
+// parseLoadThreshold parses a threshold like "p95<300ms", "errors<1%" or "rps>20"
+func parseLoadThreshold(s string) (loadThreshold, error) {
+	pattern := regexp.MustCompile(`^(p\d+(?:\.\d+)?|avg|min|max|errors|rps)\s*(<|>)\s*(.+)$`)
+	matches := pattern.FindStringSubmatch(strings.TrimSpace(s))
+	if matches == nil {
+		return loadThreshold{}, fmt.Errorf("invalid threshold %q", s)
+	}
+
+	threshold := loadThreshold{Metric: matches[1], Op: matches[2], Raw: s}
+	value := strings.TrimSpace(matches[3])
+
+	switch threshold.Metric {
+	case "errors":
+		n, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil || !strings.HasSuffix(value, "%") {
+			return loadThreshold{}, fmt.Errorf("invalid error rate in threshold %q (e.g., errors<1%%)", s)
+		}
+		threshold.Value = n
+	case "rps":
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return loadThreshold{}, fmt.Errorf("invalid throughput in threshold %q (e.g., rps>20)", s)
+		}
+		threshold.Value = n
+	default:
+		d, err := parseDuration(value)
+		if err != nil {
+			return loadThreshold{}, fmt.Errorf("invalid duration in threshold %q (e.g., p95<300ms)", s)
+		}
+		threshold.Value = float64(d)
+	}
+
+	return threshold, nil
+}
+
+// check reports an error if a test's stats don't satisfy the threshold. Hooks only
+// run once, so they are left out of throughput checks.
+func (t loadThreshold) check(ts *testStats, elapsed time.Duration) error {
+	var actual float64
+	var formatted string
+
+	switch t.Metric {
+	case "errors":
+		actual = errorRate(ts)
+		formatted = fmt.Sprintf("%.1f%%", actual)
+	case "rps":
+		if ts.Hook {
+			return nil
+		}
+		actual = float64(ts.Requests) / elapsed.Seconds()
+		formatted = fmt.Sprintf("%.1f", actual)
+	default:
+		// With every request failed there is no latency, which mustn't pass as 0ms
+		if len(ts.Durations) == 0 && ts.Requests > 0 {
+			return fmt.Errorf("threshold %s failed for %s: no successful requests", t.Raw, ts.Name)
+		}
+		switch t.Metric {
+		case "avg":
+			actual = float64(averageDuration(ts.Durations))
+		case "min":
+			actual = float64(percentile(ts.Durations, 0))
+		case "max":
+			actual = float64(percentile(ts.Durations, 100))
+		default:
+			p, _ := strconv.ParseFloat(strings.TrimPrefix(t.Metric, "p"), 64)
+			actual = float64(percentile(ts.Durations, p))
+		}
+	}
+	if formatted == "" {
+		formatted = formatDuration(time.Duration(actual))
+	}
+
+	if (t.Op == "<" && actual >= t.Value) || (t.Op == ">" && actual <= t.Value) {
+		return fmt.Errorf("threshold %s failed for %s: got %s", t.Raw, ts.Name, formatted)
+	}
+	return nil
+}
+
+// checkThresholds checks every threshold against every row, including the total
+func checkThresholds(thresholds []loadThreshold, rows []*testStats, elapsed time.Duration) []error {
+	var failures []error
+	for _, threshold := range thresholds {
+		for _, ts := range rows {
+			if err := threshold.check(ts, elapsed); err != nil {
+				failures = append(failures, err)
+			}
+		}
+	}
+	return failures
+}
+
+// errorRate returns the percentage of failed requests
+func errorRate(ts *testStats) float64 {
+	if ts.Requests == 0 {
+		return 0
+	}
+	return float64(ts.Errors) / float64(ts.Requests) * 100
+}
+
+// runLoad runs every test file repeatedly with the given number of virtual users until
+// duration has elapsed. Each virtual user runs the files in order with its own variables,
+// so chained Save: values work as they do in a normal run. "Before all" and "after all"
+// hooks run once for the whole load test, and every iteration starts with the variables
+// they saved. The deadline is checked before each test, so a long file doesn't overrun it.
+func runLoad(testFiles []TestFile, users int, duration time.Duration) (*loadStats, time.Duration) {
+	stats := newLoadStats()
+	start := time.Now()
+	deadline := start.Add(duration)
+
+	label := func(tf TestFile, name string) string {
+		if len(testFiles) > 1 {
+			return tf.Path + ": " + name
+		}
+		return name
+	}
+
+	// Files whose setup fails are left out, rather than reporting a skip on every iteration
+	setupVars := make([]map[string]interface{}, len(testFiles))
+	var files []int
+	for fi, tf := range testFiles {
+		var err error
+		setupVars[fi], err = runHooks(tf.Hooks.BeforeAll, nil, true)
+		if err != nil {
+			stats.record(label(tf, "Before all"), TestResult{FilePath: tf.Path, FileIndex: fi, Test: Test{Name: "Before all"}, Index: -1, Err: err})
+			continue
+		}
+		files = append(files, fi)
+	}
+
+	var wg sync.WaitGroup
+	for u := 0; u < users && len(files) > 0; u++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				for _, fi := range files {
+					tf := testFiles[fi]
+					// Each iteration gets its own copy, since tests save into it
+					vars := make(map[string]interface{}, len(setupVars[fi]))
+					for k, v := range setupVars[fi] {
+						vars[k] = v
+					}
+					for i, test := range tf.Tests {
+						if !time.Now().Before(deadline) {
+							return
+						}
+						var samples []time.Duration
+						var err error
+						vars, samples, err = runTestWithHooks(test, tf.Hooks, vars)
+						stats.record(label(tf, test.Name), TestResult{FilePath: tf.Path, Test: test, Index: i, Err: err, Samples: samples})
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	// Teardown always runs, even when setup failed, like in a normal run
+	for fi, tf := range testFiles {
+		if _, err := runHooks(tf.Hooks.AfterAll, setupVars[fi], false); err != nil {
+			stats.record(label(tf, "After all"), TestResult{FilePath: tf.Path, FileIndex: fi, Test: Test{Name: "After all"}, Index: -1, Err: err})
+		}
+	}
+
+	return stats, elapsed
+}
+
+// printLoadReport prints throughput, error rate and latency percentiles per test
+func printLoadReport(rows []*testStats, elapsed time.Duration, quiet bool) {
+	nameWidth := len("Test")
+	for _, ts := range rows {
+		if len(ts.Name) > nameWidth {
+			nameWidth = len(ts.Name)
+		}
+	}
+
+	fmt.Printf("  %s%-*s  %8s  %7s  %8s  %8s  %8s  %8s  %8s%s\n", colorBold, nameWidth, "Test", "Reqs", "Errors", "RPS", "p50", "p90", "p99", "Max", colorReset)
+	for _, ts := range rows {
+		rate := errorRate(ts)
+		errColor := colorGreen
+		if ts.Errors > 0 {
+			errColor = colorRed
+		}
+		fmt.Printf("  %-*s  %8d  %s%6.1f%%%s  %8.1f  %8s  %8s  %8s  %8s\n",
+			nameWidth, ts.Name,
+			ts.Requests,
+			errColor, rate, colorReset,
+			float64(ts.Requests)/elapsed.Seconds(),
+			formatDuration(percentile(ts.Durations, 50)),
+			formatDuration(percentile(ts.Durations, 90)),
+			formatDuration(percentile(ts.Durations, 99)),
+			formatDuration(percentile(ts.Durations, 100)),
+		)
+		if ts.FirstErr != nil {
+			fmt.Printf("    %s→ %s%s\n", colorRed, formatError(ts.FirstErr, quiet), colorReset)
+		}
+	}
+	fmt.Println()
+}
+
+// runLoadCommand implements "marcus load [options] <file-or-directory>"
+func runLoadCommand(args []string) {
+	usage := "Usage: marcus load [--users=N] [--duration=D] [--rate=N/s] [--threshold=EXPR]... [--ca-cert=FILE] [--client-cert=FILE --client-key=FILE] [--insecure] [--quiet] <file-or-directory>"
+
+	users := 1
+	duration := 30 * time.Second
+	rate := 0.0
+	quiet := false
+	var thresholds []loadThreshold
+	target := ""
+
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--users=") {
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "--users="))
+			if err != nil || n < 1 {
+				fmt.Fprintln(os.Stderr, "Error: --users requires a positive integer (e.g., --users=50)")
+				os.Exit(1)
+			}
+			users = n
+		} else if strings.HasPrefix(arg, "--duration=") {
+			d, err := parseDuration(strings.TrimPrefix(arg, "--duration="))
+			if err != nil || d <= 0 {
+				fmt.Fprintln(os.Stderr, "Error: --duration requires a duration (e.g., --duration=2m)")
+				os.Exit(1)
+			}
+			duration = d
+		} else if strings.HasPrefix(arg, "--rate=") {
+			r, err := parseRate(strings.TrimPrefix(arg, "--rate="))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error: --rate requires a rate per second, minute or hour (e.g., --rate=20/s)")
+				os.Exit(1)
+			}
+			rate = r
+		} else if strings.HasPrefix(arg, "--threshold=") {
+			threshold, err := parseLoadThreshold(strings.TrimPrefix(arg, "--threshold="))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			thresholds = append(thresholds, threshold)
+		} else if arg == "--quiet" || arg == "-q" {
+			quiet = true
+		} else if parseTLSFlag(arg) {
+			// TLS options apply to every request, see cliTLS
+		} else if target == "" {
+			target = arg
+		}
+	}
+
+	if target == "" {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(1)
+	}
+
+	testFiles, err := collectTestFiles(target)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if len(testFiles) == 0 {
+		fmt.Println("No tests found.")
+		return
+	}
+
+	configureRateLimits(rate, testFiles)
+
+	fmt.Printf("%s (%d users for %s)\n\n", target, users, duration)
+
+	stats, elapsed := runLoad(testFiles, users, duration)
+	rows := stats.rows()
+	printLoadReport(rows, elapsed, quiet)
+
+	failures := checkThresholds(thresholds, rows, elapsed)
+
+	total := rows[len(rows)-1]
+	summary := fmt.Sprintf("%d requests, %.1f/s, %.1f%% errors", total.Requests, float64(total.Requests)/elapsed.Seconds(), errorRate(total))
+	if len(failures) == 0 {
+		fmt.Printf("%s%s%s %sin %s%s\n", colorGreen, summary, colorReset, colorDim, formatDuration(elapsed), colorReset)
+		return
+	}
+
+	for _, err := range failures {
+		fmt.Printf("  %s✗%s %s\n", colorRed, colorReset, err)
+	}
+	fmt.Printf("\n%s%s, %d threshold checks failed%s %sin %s%s\n", colorRed, summary, len(failures), colorReset, colorDim, formatDuration(elapsed), colorReset)
+	os.Exit(1)
+}
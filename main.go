@@ -3,44 +3,96 @@ package main
 import (
 	"fmt"
 	"os"
+	"regexp"
 	"time"
 )
 
 func main() {
 	if len(os.Args) < 2 {
-		fmt.Fprintln(os.Stderr, "Usage: marcus [--parallel] [--quiet] [--only=N] [--skip=N] [--start-from=N] <file-or-directory>")
+		fmt.Fprintln(os.Stderr, "Usage: marcus [--parallel] [--concurrency=N] [--rate=N/s] [--ca-cert=FILE] [--client-cert=FILE --client-key=FILE] [--insecure] [--quiet] [--grep=REGEX] [--tags=TAGS] [--only=N,M-K] [--skip=N,M-K] [--start-from=N] [--with-deps] [--failed] [--update-snapshots] <file-or-directory[:line]>")
 		os.Exit(1)
 	}
 
+	// Subcommands
+	switch os.Args[1] {
+	case "load":
+		runLoadCommand(os.Args[2:])
+		return
+	case "watch":
+		runWatchCommand(os.Args[2:])
+		return
+	}
+
 	// Parse arguments
 	parallel := false
+	concurrency := 0 // 0 means one worker per CPU core
+	rate := 0.0      // 0 means no global rate limit
 	quiet := false
-	only := 0      // 0 means run all tests
-	skip := 0      // 0 means skip none
-	startFrom := 0 // 0 means start from beginning
+	var only []testSelector // empty means run all tests
+	var skip []testSelector // empty means skip none
+	startFrom := 0          // 0 means start from beginning
+	withDeps := false       // pull in earlier tests that save variables the selected tests use
+	onlyFailed := false     // rerun only the tests that failed in the previous run
+	var grep *regexp.Regexp
+	var tags tagFilter
 	target := ""
 
 	for _, arg := range os.Args[1:] {
 		if arg == "--parallel" {
 			parallel = true
+		} else if len(arg) > 14 && arg[:14] == "--concurrency=" {
+			var n int
+			_, err := fmt.Sscanf(arg, "--concurrency=%d", &n)
+			if err != nil || n < 1 {
+				fmt.Fprintln(os.Stderr, "Error: --concurrency requires a positive integer (e.g., --concurrency=8)")
+				os.Exit(1)
+			}
+			concurrency = n
+		} else if len(arg) > 7 && arg[:7] == "--rate=" {
+			r, err := parseRate(arg[7:])
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error: --rate requires a rate per second, minute or hour (e.g., --rate=20/s)")
+				os.Exit(1)
+			}
+			rate = r
+		} else if parseTLSFlag(arg) {
+			// TLS options apply to every request, see cliTLS
+		} else if arg == "--failed" || arg == "--last-failed" {
+			onlyFailed = true
+		} else if arg == "--with-deps" {
+			withDeps = true
+		} else if arg == "--update-snapshots" {
+			updateSnapshots = true
 		} else if arg == "--quiet" || arg == "-q" {
 			quiet = true
+		} else if len(arg) > 7 && arg[:7] == "--grep=" {
+			re, err := regexp.Compile(arg[7:])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: --grep requires a valid regular expression: %v\n", err)
+				os.Exit(1)
+			}
+			grep = re
+		} else if len(arg) > 7 && arg[:7] == "--tags=" {
+			t, err := parseTagFilter(arg[7:])
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error: --tags requires a comma-separated list of tags (e.g., --tags=smoke,!slow)")
+				os.Exit(1)
+			}
+			tags = t
 		} else if len(arg) > 7 && arg[:7] == "--only=" {
-			var n int
-			_, err := fmt.Sscanf(arg, "--only=%d", &n)
-			if err != nil || n < 1 {
-				fmt.Fprintln(os.Stderr, "Error: --only requires a positive integer (e.g., --only=3)")
+			selectors, err := parseSelectors(arg[7:])
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error: --only requires test numbers or ranges (e.g., --only=3, --only=2,5-8, --only=users.md:2)")
 				os.Exit(1)
 			}
-			only = n
+			only = append(only, selectors...)
 		} else if len(arg) > 7 && arg[:7] == "--skip=" {
-			var n int
-			_, err := fmt.Sscanf(arg, "--skip=%d", &n)
-			if err != nil || n < 1 {
-				fmt.Fprintln(os.Stderr, "Error: --skip requires a positive integer (e.g., --skip=3)")
+			selectors, err := parseSelectors(arg[7:])
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error: --skip requires test numbers or ranges (e.g., --skip=3, --skip=3,4, --skip=users.md:2)")
 				os.Exit(1)
 			}
-			skip = n
+			skip = append(skip, selectors...)
 		} else if len(arg) > 13 && arg[:13] == "--start-from=" {
 			var n int
 			_, err := fmt.Sscanf(arg, "--start-from=%d", &n)
@@ -55,16 +107,19 @@ func main() {
 	}
 
 	if target == "" {
-		fmt.Fprintln(os.Stderr, "Usage: marcus [--parallel] [--quiet] [--only=N] [--skip=N] [--start-from=N] <file-or-directory>")
+		fmt.Fprintln(os.Stderr, "Usage: marcus [--parallel] [--concurrency=N] [--rate=N/s] [--ca-cert=FILE] [--client-cert=FILE --client-key=FILE] [--insecure] [--quiet] [--grep=REGEX] [--tags=TAGS] [--only=N,M-K] [--skip=N,M-K] [--start-from=N] [--with-deps] [--failed] [--update-snapshots] <file-or-directory[:line]>")
 		os.Exit(1)
 	}
 
-	if only > 0 && skip > 0 {
-		fmt.Fprintln(os.Stderr, "Error: --only and --skip cannot be used together")
+	// A target like "tests/api.md:42" runs the test at that line
+	target, line := splitTargetLine(target)
+
+	if line > 0 && (len(only) > 0 || len(skip) > 0 || startFrom > 0) {
+		fmt.Fprintln(os.Stderr, "Error: a line number cannot be combined with --only, --skip or --start-from")
 		os.Exit(1)
 	}
 
-	if only > 0 && startFrom > 0 {
+	if len(only) > 0 && startFrom > 0 {
 		fmt.Fprintln(os.Stderr, "Error: --only and --start-from cannot be used together")
 		os.Exit(1)
 	}
@@ -80,6 +135,41 @@ func main() {
 		return
 	}
 
+	// Keep every test so --with-deps can find prerequisites that weren't selected
+	// (copied because --start-from removes files in place)
+	allFiles := append([]TestFile(nil), testFiles...)
+
+	if line > 0 {
+		testFiles, err = selectLine(testFiles, line)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Filter by test name and tags before selecting tests by number
+	if grep != nil || len(tags.Include) > 0 || len(tags.Exclude) > 0 {
+		testFiles = filterTests(testFiles, grep, tags)
+		if len(testFiles) == 0 {
+			fmt.Println("No tests match the given filters.")
+			return
+		}
+	}
+
+	// Rerun only the tests that failed last time
+	if onlyFailed {
+		state, err := loadLastRun(lastRunPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		testFiles = filterFailed(testFiles, state)
+		if len(testFiles) == 0 {
+			fmt.Println("No previously failed tests.")
+			return
+		}
+	}
+
 	// Count total tests across all files
 	totalTests := 0
 	for _, tf := range testFiles {
@@ -91,54 +181,21 @@ func main() {
 		return
 	}
 
-	// Filter to single test if --only is specified
-	if only > 0 {
-		if only > totalTests {
-			fmt.Fprintf(os.Stderr, "Error: test %d does not exist (file has %d tests)\n", only, totalTests)
+	// Select tests by number if --only or --skip is specified
+	if len(only) > 0 || len(skip) > 0 {
+		testFiles, err = selectTests(testFiles, only, skip)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
-		// Find the test at position 'only' (1-indexed)
-		testNum := 0
-		for i, tf := range testFiles {
-			for j, test := range tf.Tests {
-				testNum++
-				if testNum == only {
-					testFiles = []TestFile{{Path: tf.Path, Tests: []Test{test}}}
-					testFiles[0].Tests[0].Name = fmt.Sprintf("%s (#%d)", test.Name, only)
-					_ = i // silence unused warning
-					_ = j
-					goto filtered
-				}
-			}
-		}
-	filtered:
-		totalTests = 1
-	}
-
-	// Skip a single test if --skip is specified
-	if skip > 0 {
-		if skip > totalTests {
-			fmt.Fprintf(os.Stderr, "Error: test %d does not exist (file has %d tests)\n", skip, totalTests)
-			os.Exit(1)
+		if len(testFiles) == 0 {
+			fmt.Println("No tests found.")
+			return
 		}
-		// Remove the test at position 'skip' (1-indexed)
-		testNum := 0
-		for i, tf := range testFiles {
-			for j := range tf.Tests {
-				testNum++
-				if testNum == skip {
-					// Remove test j from this file
-					testFiles[i].Tests = append(tf.Tests[:j], tf.Tests[j+1:]...)
-					// Remove the file if it has no tests left
-					if len(testFiles[i].Tests) == 0 {
-						testFiles = append(testFiles[:i], testFiles[i+1:]...)
-					}
-					goto skipped
-				}
-			}
+		totalTests = 0
+		for _, tf := range testFiles {
+			totalTests += len(tf.Tests)
 		}
-	skipped:
-		totalTests--
 	}
 
 	// Start from a specific test if --start-from is specified
@@ -168,6 +225,15 @@ func main() {
 		totalTests = totalTests - testsToSkip
 	}
 
+	// Add the earlier tests that save variables the selected tests use
+	if withDeps {
+		testFiles = addPrerequisites(testFiles, allFiles)
+		totalTests = 0
+		for _, tf := range testFiles {
+			totalTests += len(tf.Tests)
+		}
+	}
+
 	// Print summary header
 	if !quiet {
 		if len(testFiles) == 1 {
@@ -177,13 +243,22 @@ func main() {
 		}
 	}
 
+	// Rate limits are shared by every worker, so they apply across all files
+	configureRateLimits(rate, testFiles)
+
 	var passed, failed int
+	var failures []TestResult
 	var totalDuration time.Duration
 
 	if parallel {
-		passed, failed, totalDuration = runTestsParallel(testFiles, quiet)
+		passed, failed, failures, totalDuration = runTestsParallel(testFiles, quiet, concurrency)
 	} else {
-		passed, failed, totalDuration = runTestsSequential(testFiles, quiet)
+		passed, failed, failures, totalDuration = runTestsSequential(testFiles, quiet)
+	}
+
+	// Record failures so they can be rerun with --failed
+	if err := saveLastRun(lastRunPath, testFiles, failures); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not save %s: %v\n", lastRunPath, err)
 	}
 
 	if failed == 0 {
@@ -1,10 +1,32 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
 	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -1022,7 +1044,7 @@ func TestRunTestsParallelQuietMode(t *testing.T) {
 
 	t.Run("quiet mode hides passing tests in parallel", func(t *testing.T) {
 		output := captureOutput(func() {
-			runTestsParallel(passingTests, true)
+			runTestsParallel(passingTests, true, 0)
 		})
 
 		// In quiet mode with all passing, output should NOT contain test names
@@ -1036,7 +1058,7 @@ func TestRunTestsParallelQuietMode(t *testing.T) {
 
 	t.Run("normal mode shows passing tests in parallel", func(t *testing.T) {
 		output := captureOutput(func() {
-			runTestsParallel(passingTests, false)
+			runTestsParallel(passingTests, false, 0)
 		})
 
 		// In normal mode, output should contain test names
@@ -1560,3 +1582,2690 @@ func TestStatusFailureShowsResponseBody(t *testing.T) {
 		}
 	})
 }
+
+func TestParseTestFileHooks(t *testing.T) {
+	content := `---
+root: https://api.example.com
+---
+
+## Before all: Create user
+POST /users
+
+## Before each
+GET /health
+
+## List users
+GET /users
+
+## After each
+GET /audit
+
+## After all: Delete user
+DELETE /users/{{user_id}}
+
+## Get user
+GET /users/{{user_id}}`
+
+	tf := parseTestFile(content, "")
+
+	if len(tf.Tests) != 2 {
+		t.Fatalf("expected 2 tests, got %d", len(tf.Tests))
+	}
+	if tf.Tests[0].Name != "List users" || tf.Tests[1].Name != "Get user" {
+		t.Errorf("unexpected test names: %q, %q", tf.Tests[0].Name, tf.Tests[1].Name)
+	}
+	if len(tf.Hooks.BeforeAll) != 1 || tf.Hooks.BeforeAll[0].Name != "Before all: Create user" {
+		t.Errorf("expected one before all hook, got %+v", tf.Hooks.BeforeAll)
+	}
+	if len(tf.Hooks.BeforeEach) != 1 || tf.Hooks.BeforeEach[0].URL != "https://api.example.com/health" {
+		t.Errorf("expected one before each hook, got %+v", tf.Hooks.BeforeEach)
+	}
+	if len(tf.Hooks.AfterEach) != 1 {
+		t.Errorf("expected one after each hook, got %d", len(tf.Hooks.AfterEach))
+	}
+	if len(tf.Hooks.AfterAll) != 1 || tf.Hooks.AfterAll[0].Method != "DELETE" {
+		t.Errorf("expected one after all hook, got %+v", tf.Hooks.AfterAll)
+	}
+
+	// parseTests keeps returning only the regular tests
+	if len(parseTests(content, "")) != 2 {
+		t.Errorf("parseTests should exclude hooks")
+	}
+
+	// Tests whose names only start with a hook's name stay tests
+	tf = parseTestFile("## After each deletion the list is empty\nGET https://api.example.com/items\n\n## Before all users are loaded\nGET https://api.example.com/users\n", "")
+	if len(tf.Tests) != 2 || len(tf.Hooks.AfterEach) != 0 || len(tf.Hooks.BeforeAll) != 0 {
+		t.Errorf("expected 2 tests and no hooks, got %d tests and %+v", len(tf.Tests), tf.Hooks)
+	}
+}
+
+func TestRunTestsSequentialHooks(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/users":
+			w.WriteHeader(201)
+			w.Write([]byte(`{"id": 42}`))
+		case "/fail":
+			w.WriteHeader(500)
+		default:
+			w.WriteHeader(200)
+		}
+	}))
+	defer server.Close()
+
+	status := func(code string) []Assertion { return []Assertion{{Type: "status", Value: code}} }
+	testFiles := []TestFile{
+		{
+			Path: "test.md",
+			Tests: []Test{
+				{Name: "Passing", Method: "GET", URL: server.URL + "/ok/{{user_id}}", Assertions: status("200")},
+				{Name: "Failing", Method: "GET", URL: server.URL + "/fail", Assertions: status("200")},
+			},
+			Hooks: Hooks{
+				BeforeAll:  []Test{{Name: "Before all", Method: "POST", URL: server.URL + "/users", SaveFields: []SaveField{{Field: "id", Variable: "user_id"}}}},
+				BeforeEach: []Test{{Name: "Before each", Method: "GET", URL: server.URL + "/before"}},
+				AfterEach:  []Test{{Name: "After each", Method: "GET", URL: server.URL + "/after"}},
+				AfterAll:   []Test{{Name: "After all", Method: "DELETE", URL: server.URL + "/users/{{user_id}}"}},
+			},
+		},
+	}
+
+	var passed, failed int
+	captureOutput(func() {
+		passed, failed, _, _ = runTestsSequential(testFiles, true)
+	})
+
+	if passed != 1 || failed != 1 {
+		t.Errorf("expected 1 passed and 1 failed, got %d passed and %d failed", passed, failed)
+	}
+
+	expected := []string{
+		"POST /users",
+		"GET /before", "GET /ok/42", "GET /after",
+		"GET /before", "GET /fail", "GET /after",
+		"DELETE /users/42",
+	}
+	if strings.Join(calls, ", ") != strings.Join(expected, ", ") {
+		t.Errorf("unexpected request order:\nexpected: %v\ngot: %v", expected, calls)
+	}
+
+	t.Run("teardown runs when setup fails", func(t *testing.T) {
+		calls = nil
+		files := []TestFile{testFiles[0]}
+		files[0].Hooks.BeforeAll = []Test{{Name: "Before all", Method: "GET", URL: server.URL + "/fail", Assertions: status("200")}}
+
+		var output string
+		output = captureOutput(func() {
+			passed, failed, _, _ = runTestsSequential(files, true)
+		})
+
+		if passed != 0 || failed != 3 {
+			t.Errorf("expected the hook and both tests to fail, got %d passed and %d failed", passed, failed)
+		}
+		if !strings.Contains(output, "skipped: before all hook failed") {
+			t.Error("tests should be reported as skipped when setup fails")
+		}
+		if len(calls) != 2 || calls[1] != "DELETE /users/{{user_id}}" {
+			t.Errorf("expected setup then teardown only, got %v", calls)
+		}
+	})
+}
+
+func TestRunTestsParallelSharesVariablesWithinFile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/create" {
+			w.Write([]byte(`{"id": "` + r.URL.Query().Get("id") + `"}`))
+			return
+		}
+		w.Write([]byte(`{"path": "` + r.URL.Path + `"}`))
+	}))
+	defer server.Close()
+
+	// Each file saves a different id and reads it back in a later test
+	var testFiles []TestFile
+	for _, id := range []string{"a", "b", "c"} {
+		testFiles = append(testFiles, TestFile{
+			Path: id + ".md",
+			Tests: []Test{
+				{Name: "Create " + id, Method: "POST", URL: server.URL + "/create?id=" + id, SaveFields: []SaveField{{Field: "id", Variable: "id"}}},
+				{Name: "Read " + id, Method: "GET", URL: server.URL + "/items/{{id}}", Assertions: []Assertion{{Type: "field_equals", Field: "path", Value: "/items/" + id}}},
+			},
+		})
+	}
+
+	var passed, failed int
+	output := captureOutput(func() {
+		passed, failed, _, _ = runTestsParallel(testFiles, false, 0)
+	})
+
+	if passed != 6 || failed != 0 {
+		t.Errorf("expected 6 passed, got %d passed and %d failed\n%s", passed, failed, output)
+	}
+	// Results are grouped by file in their original order
+	if strings.Index(output, "a.md") > strings.Index(output, "b.md") || strings.Index(output, "b.md") > strings.Index(output, "c.md") {
+		t.Errorf("files should be printed in order\n%s", output)
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    float64
+		expectError bool
+	}{
+		{input: "20/s", expected: 20},
+		{input: "5", expected: 5},
+		{input: "120/m", expected: 2},
+		{input: "3600/h", expected: 1},
+		{input: "0.5/s", expected: 0.5},
+		{input: "0/s", expectError: true},
+		{input: "fast", expectError: true},
+		{input: "10/d", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, err := parseRate(tt.input)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error, got %v", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestParseFrontmatterRateLimits(t *testing.T) {
+	content := `---
+root: https://api.example.com
+rate_limits:
+  api.example.com: 5/s
+  slow.example.com: 60/m
+  bad.example.com: lots
+  api.example.com:8443: 2/s
+headers:
+  Accept: application/json
+---`
+	defaults, _ := parseFrontmatter(content)
+
+	if len(defaults.RateLimits) != 3 {
+		t.Fatalf("expected 3 rate limits, got %v", defaults.RateLimits)
+	}
+	if defaults.RateLimits["api.example.com"] != 5 || defaults.RateLimits["slow.example.com"] != 1 || defaults.RateLimits["api.example.com:8443"] != 2 {
+		t.Errorf("unexpected rate limits: %v", defaults.RateLimits)
+	}
+	if defaults.Headers["Accept"] != "application/json" {
+		t.Errorf("headers after rate_limits should still be parsed, got %v", defaults.Headers)
+	}
+
+	// Invalid rates are reported when the file is loaded
+	if !reflect.DeepEqual(defaults.Errors, []string{`invalid rate limit for bad.example.com: "lots" (expected a rate like 5/s or 60/m)`}) {
+		t.Errorf("expected an error for the invalid rate, got %v", defaults.Errors)
+	}
+	path := filepath.Join(t.TempDir(), "api.md")
+	os.WriteFile(path, []byte(content+"\n\n## List users\nGET https://api.example.com/users\n"), 0644)
+	if _, err := collectTestFiles(path); err == nil || !strings.Contains(err.Error(), "api.md: invalid rate limit for bad.example.com") {
+		t.Errorf("expected loading the file to fail, got %v", err)
+	}
+}
+
+func TestRateLimiterSpacesRequests(t *testing.T) {
+	limiter := newRateLimiter(50) // one request every 20ms
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			limiter.Wait()
+		}()
+	}
+	wg.Wait()
+
+	// The first request is immediate, the other four wait 20ms each
+	if elapsed := time.Since(start); elapsed < 75*time.Millisecond {
+		t.Errorf("expected requests to be spaced out, all 5 finished in %s", elapsed)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var durations []time.Duration
+	for i := 10; i >= 1; i-- {
+		durations = append(durations, time.Duration(i)*time.Millisecond)
+	}
+
+	tests := []struct {
+		p        float64
+		expected time.Duration
+	}{
+		{p: 0, expected: 1 * time.Millisecond},
+		{p: 50, expected: 5 * time.Millisecond},
+		{p: 90, expected: 9 * time.Millisecond},
+		{p: 99, expected: 10 * time.Millisecond},
+		{p: 100, expected: 10 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		if result := percentile(durations, tt.p); result != tt.expected {
+			t.Errorf("p%v: expected %s, got %s", tt.p, tt.expected, result)
+		}
+	}
+
+	if percentile(nil, 50) != 0 {
+		t.Error("percentile of no samples should be 0")
+	}
+}
+
+func TestParseLoadThreshold(t *testing.T) {
+	tests := []struct {
+		input       string
+		metric      string
+		op          string
+		value       float64
+		expectError bool
+	}{
+		{input: "p95<300ms", metric: "p95", op: "<", value: float64(300 * time.Millisecond)},
+		{input: "p99.9 < 1s", metric: "p99.9", op: "<", value: float64(time.Second)},
+		{input: "avg<200ms", metric: "avg", op: "<", value: float64(200 * time.Millisecond)},
+		{input: "errors<1%", metric: "errors", op: "<", value: 1},
+		{input: "rps>20", metric: "rps", op: ">", value: 20},
+		{input: "errors<1", expectError: true},
+		{input: "p95<fast", expectError: true},
+		{input: "latency<1s", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, err := parseLoadThreshold(tt.input)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error, got %+v", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Metric != tt.metric || result.Op != tt.op || result.Value != tt.value {
+				t.Errorf("expected %s %s %v, got %+v", tt.metric, tt.op, tt.value, result)
+			}
+		})
+	}
+}
+
+func TestRunLoad(t *testing.T) {
+	var requests int
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		n := requests
+		mu.Unlock()
+		if r.URL.Path == "/login" {
+			w.Write([]byte(`{"token": "abc"}`))
+			return
+		}
+		// Every fifth checkout fails
+		if n%5 == 0 {
+			w.WriteHeader(500)
+			return
+		}
+		w.Write([]byte(`{"auth": "` + r.Header.Get("Authorization") + `"}`))
+	}))
+	defer server.Close()
+
+	testFiles := []TestFile{{
+		Path: "checkout.md",
+		Tests: []Test{
+			{Name: "Login", Method: "POST", URL: server.URL + "/login", SaveFields: []SaveField{{Field: "token", Variable: "token"}}},
+			{Name: "Checkout", Method: "POST", URL: server.URL + "/checkout", Headers: map[string]string{"Authorization": "{{token}}"}, Assertions: []Assertion{
+				{Type: "status", Value: "200"},
+				{Type: "field_equals", Field: "auth", Value: "abc"},
+			}},
+		},
+	}}
+
+	stats, elapsed := runLoad(testFiles, 3, 50*time.Millisecond)
+	rows := stats.rows()
+
+	if len(rows) != 3 || rows[0].Name != "Login" || rows[1].Name != "Checkout" || rows[2].Name != "Total" {
+		t.Fatalf("unexpected rows: %+v", rows)
+	}
+	if len(rows[2].Durations) != len(rows[0].Durations)+len(rows[1].Durations) || rows[2].Requests != rows[0].Requests+rows[1].Requests {
+		t.Error("total should combine all samples")
+	}
+	// Failed checkouts count as requests, but have no latency
+	if rows[1].Requests != len(rows[1].Durations)+rows[1].Errors {
+		t.Errorf("expected %d requests with %d errors to have %d samples, got %d", rows[1].Requests, rows[1].Errors, rows[1].Requests-rows[1].Errors, len(rows[1].Durations))
+	}
+	if rows[0].Errors != 0 {
+		t.Errorf("login should never fail, got %d errors", rows[0].Errors)
+	}
+	if rows[1].Errors == 0 || rows[1].FirstErr == nil {
+		t.Error("expected checkout errors to be recorded")
+	}
+
+	threshold, _ := parseLoadThreshold("errors<1%")
+	if err := threshold.check(rows[0], elapsed); err != nil {
+		t.Errorf("login should pass the error threshold: %v", err)
+	}
+	if err := threshold.check(rows[1], elapsed); err == nil {
+		t.Error("checkout should fail the error threshold")
+	}
+}
+
+func TestLoadStatsIgnoreFailedLatencies(t *testing.T) {
+	stats := newLoadStats()
+	stats.record("Checkout", TestResult{Samples: []time.Duration{100 * time.Millisecond}})
+	stats.record("Checkout", TestResult{Err: fmt.Errorf("status assertion failed")})
+	stats.record("Checkout", TestResult{Err: fmt.Errorf("skipped: before all hook failed")})
+	stats.record("Checkout", TestResult{Samples: []time.Duration{200 * time.Millisecond}, Err: fmt.Errorf("repetition 2 of 2: timeout")})
+	ts := stats.rows()[0]
+
+	if ts.Requests != 5 || ts.Errors != 3 || len(ts.Durations) != 2 {
+		t.Errorf("expected 5 requests, 3 errors and 2 samples, got %d, %d and %v", ts.Requests, ts.Errors, ts.Durations)
+	}
+	threshold, _ := parseLoadThreshold("min<50ms")
+	if err := threshold.check(ts, time.Second); err == nil {
+		t.Error("failed requests shouldn't pull the minimum down to 0ms")
+	}
+	if rate := errorRate(ts); rate != 60 {
+		t.Errorf("expected a 60%% error rate, got %.1f", rate)
+	}
+}
+
+func TestLoadThresholdsFailedRequestsAndHooks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+	}))
+	defer server.Close()
+
+	// Every request fails, so there's no latency for p95 to pass on
+	testFiles := []TestFile{{
+		Path:  "broken.md",
+		Tests: []Test{{Name: "Checkout", Method: "POST", URL: server.URL + "/checkout", Assertions: []Assertion{{Type: "status", Value: "200"}}}},
+	}}
+	stats, elapsed := runLoad(testFiles, 2, 10*time.Millisecond)
+	threshold, _ := parseLoadThreshold("p95<300ms")
+	failures := checkThresholds([]loadThreshold{threshold}, stats.rows(), elapsed)
+	if len(failures) != 2 || !strings.Contains(failures[0].Error(), "no successful requests") {
+		t.Errorf("expected the test and the total to fail the latency threshold, got %v", failures)
+	}
+
+	// A hook runs once, so it isn't held to the throughput threshold
+	stats = newLoadStats()
+	stats.record("Before all", TestResult{Test: Test{Name: "Before all"}, Index: -1, Err: fmt.Errorf("status assertion failed")})
+	for i := 0; i < 5; i++ {
+		stats.record("Checkout", TestResult{Samples: []time.Duration{10 * time.Millisecond}})
+	}
+	threshold, _ = parseLoadThreshold("rps>2")
+	if failures := checkThresholds([]loadThreshold{threshold}, stats.rows(), time.Second); len(failures) != 0 {
+		t.Errorf("expected hooks to be left out of rps checks, got %v", failures)
+	}
+}
+
+func TestRunLoadHooksAndDeadline(t *testing.T) {
+	var mu sync.Mutex
+	calls := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls[r.URL.Path]++
+		mu.Unlock()
+		if r.URL.Path == "/setup" {
+			w.Write([]byte(`{"id": "42"}`))
+			return
+		}
+		if r.URL.Path == "/slow" {
+			time.Sleep(30 * time.Millisecond)
+		}
+		w.Write([]byte(r.URL.Query().Get("id")))
+	}))
+	defer server.Close()
+
+	testFiles := []TestFile{{
+		Path: "slow.md",
+		Hooks: Hooks{
+			BeforeAll: []Test{{Name: "Before all", Method: "POST", URL: server.URL + "/setup", SaveFields: []SaveField{{Field: "id", Variable: "id"}}}},
+			AfterAll:  []Test{{Name: "After all", Method: "DELETE", URL: server.URL + "/teardown?id={{id}}"}},
+		},
+		Tests: []Test{
+			{Name: "First", Method: "GET", URL: server.URL + "/slow?id={{id}}", Assertions: []Assertion{{Type: "body_includes_text", Value: "42"}}},
+			{Name: "Second", Method: "GET", URL: server.URL + "/slow", Assertions: []Assertion{{Type: "status", Value: "200"}}},
+			{Name: "Third", Method: "GET", URL: server.URL + "/slow", Assertions: []Assertion{{Type: "status", Value: "200"}}},
+		},
+	}}
+
+	// The deadline passes during the first test, so later tests don't run
+	stats, elapsed := runLoad(testFiles, 2, 10*time.Millisecond)
+	rows := stats.rows()
+	if calls["/setup"] != 1 || calls["/teardown"] != 1 {
+		t.Errorf("expected hooks to run once, got %v", calls)
+	}
+	if len(rows) != 2 || rows[0].Name != "First" || rows[0].Errors != 0 {
+		t.Errorf("expected only the first test to run, using the saved id, got %+v", rows)
+	}
+	if elapsed > 60*time.Millisecond {
+		t.Errorf("expected the load test to stop at the deadline, took %s", elapsed)
+	}
+
+	// A file whose setup fails is left out, but still torn down
+	testFiles[0].Hooks.BeforeAll[0].Assertions = []Assertion{{Type: "status", Value: "201"}}
+	stats, _ = runLoad(testFiles, 2, 10*time.Millisecond)
+	rows = stats.rows()
+	if len(rows) != 2 || rows[0].Name != "Before all" || rows[0].Errors != 1 {
+		t.Errorf("expected only the setup failure, got %+v", rows)
+	}
+	if calls["/slow"] != 2 || calls["/teardown"] != 2 {
+		t.Errorf("expected no tests and a second teardown, got %v", calls)
+	}
+}
+
+func TestParseRepeatAndDurationStats(t *testing.T) {
+	content := "GET https://example.com/health\n- Repeat 20 times\n\nAsserts:\n- Status is 200\n- p95 duration less than 300ms\n- Average duration less than 200ms\n- Max time less than 1s\n- Duration less than 2s"
+	test := parseTestBlock("Health", content, Defaults{Headers: map[string]string{}}, "")
+
+	if test.Repeat != 20 {
+		t.Errorf("expected Repeat 20, got %d", test.Repeat)
+	}
+	if len(test.Headers) != 0 {
+		t.Errorf("repeat option should not be parsed as a header, got %v", test.Headers)
+	}
+
+	expected := []Assertion{
+		{Type: "status", Value: "200"},
+		{Type: "duration_stat", Field: "p95", Value: "300ms"},
+		{Type: "duration_stat", Field: "avg", Value: "200ms"},
+		{Type: "duration_stat", Field: "max", Value: "1s"},
+		{Type: "duration", Value: "2s"},
+	}
+	if len(test.Assertions) != len(expected) {
+		t.Fatalf("expected %d assertions, got %+v", len(expected), test.Assertions)
+	}
+	for i, exp := range expected {
+		if !reflect.DeepEqual(test.Assertions[i], exp) {
+			t.Errorf("assertion %d: expected %+v, got %+v", i, exp, test.Assertions[i])
+		}
+	}
+}
+
+func TestRunTestTimedRepeats(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		time.Sleep(2 * time.Millisecond)
+		w.WriteHeader(200)
+	}))
+	defer server.Close()
+
+	test := Test{
+		Name:   "Repeated",
+		Method: "GET",
+		URL:    server.URL,
+		Repeat: 5,
+		Assertions: []Assertion{
+			{Type: "status", Value: "200"},
+			{Type: "duration_stat", Field: "p95", Value: "1s"},
+		},
+	}
+
+	_, durations, err := runTestTimed(test, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if requests != 5 || len(durations) != 5 {
+		t.Errorf("expected 5 requests and durations, got %d requests and %d durations", requests, len(durations))
+	}
+
+	t.Run("aggregate assertion failure", func(t *testing.T) {
+		test.Assertions = []Assertion{{Type: "duration_stat", Field: "avg", Value: "1ms"}}
+		_, _, err := runTestTimed(test, nil)
+		if err == nil || !strings.Contains(err.Error(), "average duration assertion failed") || !strings.Contains(err.Error(), "over 5 runs") {
+			t.Errorf("expected average duration failure, got %v", err)
+		}
+	})
+
+	t.Run("failing repetition stops the test", func(t *testing.T) {
+		requests = 0
+		test.Assertions = []Assertion{{Type: "status", Value: "201"}}
+		_, durations, err := runTestTimed(test, nil)
+		if err == nil || !strings.Contains(err.Error(), "repetition 1 of 5") {
+			t.Errorf("expected repetition failure, got %v", err)
+		}
+		if requests != 1 || len(durations) != 0 {
+			t.Errorf("expected to stop after the first request, got %d requests", requests)
+		}
+	})
+}
+
+func TestSplitNameTags(t *testing.T) {
+	tests := []struct {
+		header       string
+		expectedName string
+		expectedTags []string
+	}{
+		{header: "Create user @smoke @slow", expectedName: "Create user", expectedTags: []string{"smoke", "slow"}},
+		{header: "Create user", expectedName: "Create user", expectedTags: nil},
+		{header: "Email alice@example.com", expectedName: "Email alice@example.com", expectedTags: nil},
+		{header: "@smoke", expectedName: "@smoke", expectedTags: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			name, tags := splitNameTags(tt.header)
+			if name != tt.expectedName {
+				t.Errorf("name: expected %q, got %q", tt.expectedName, name)
+			}
+			if strings.Join(tags, ",") != strings.Join(tt.expectedTags, ",") {
+				t.Errorf("tags: expected %v, got %v", tt.expectedTags, tags)
+			}
+		})
+	}
+}
+
+func TestFilterTests(t *testing.T) {
+	createTestFiles := func() []TestFile {
+		return []TestFile{
+			{
+				Path: "users.md",
+				Tests: []Test{
+					{Name: "Create user", Tags: []string{"smoke"}},
+					{Name: "Bulk import users", Tags: []string{"smoke", "slow"}},
+					{Name: "Delete user"},
+				},
+			},
+			{
+				Path: "orders.md",
+				Tests: []Test{
+					{Name: "Create order", Tags: []string{"slow"}},
+				},
+			},
+		}
+	}
+
+	names := func(testFiles []TestFile) string {
+		var result []string
+		for _, tf := range testFiles {
+			for _, test := range tf.Tests {
+				result = append(result, test.Name)
+			}
+		}
+		return strings.Join(result, ", ")
+	}
+
+	tests := []struct {
+		name     string
+		grep     string
+		tags     string
+		expected string
+	}{
+		{name: "grep by name", grep: "^Create", expected: "Create user, Create order"},
+		{name: "include tag", tags: "smoke", expected: "Create user, Bulk import users"},
+		{name: "include and exclude", tags: "smoke,!slow", expected: "Create user"},
+		{name: "exclude only", tags: "!slow", expected: "Create user, Delete user"},
+		{name: "grep and tags", grep: "(?i)user", tags: "@slow", expected: "Bulk import users"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var grep *regexp.Regexp
+			if tt.grep != "" {
+				grep = regexp.MustCompile(tt.grep)
+			}
+			var tags tagFilter
+			if tt.tags != "" {
+				var err error
+				tags, err = parseTagFilter(tt.tags)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			result := filterTests(createTestFiles(), grep, tags)
+			if got := names(result); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+
+	t.Run("files without matching tests are dropped", func(t *testing.T) {
+		tags, _ := parseTagFilter("!slow")
+		result := filterTests(createTestFiles(), nil, tags)
+		if len(result) != 1 || result[0].Path != "users.md" {
+			t.Errorf("expected only users.md, got %+v", result)
+		}
+	})
+
+	if _, err := parseTagFilter("smoke,,slow"); err == nil {
+		t.Error("expected error for empty tag")
+	}
+}
+
+func TestParseSelectors(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    []testSelector
+		expectError bool
+	}{
+		{input: "3", expected: []testSelector{{From: 3, To: 3}}},
+		{input: "2,5-8", expected: []testSelector{{From: 2, To: 2}, {From: 5, To: 8}}},
+		{input: "users.md:2", expected: []testSelector{{File: "users.md", From: 2, To: 2}}},
+		{input: "tests/user-api.md:2-4,7", expected: []testSelector{{File: "tests/user-api.md", From: 2, To: 4}, {From: 7, To: 7}}},
+		{input: "0", expectError: true},
+		{input: "5-2", expectError: true},
+		{input: "two", expectError: true},
+		{input: "3,", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, err := parseSelectors(tt.input)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error, got %+v", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(result) != len(tt.expected) {
+				t.Fatalf("expected %+v, got %+v", tt.expected, result)
+			}
+			for i := range tt.expected {
+				if result[i] != tt.expected[i] {
+					t.Errorf("selector %d: expected %+v, got %+v", i, tt.expected[i], result[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSelectTests(t *testing.T) {
+	createTestFiles := func() []TestFile {
+		return []TestFile{
+			{Path: "tests/orders.md", Tests: []Test{{Name: "A"}, {Name: "B"}, {Name: "C"}}},
+			{Path: "tests/users.md", Tests: []Test{{Name: "D"}, {Name: "E"}}, Hooks: Hooks{AfterAll: []Test{{Name: "After all"}}}},
+		}
+	}
+
+	names := func(testFiles []TestFile) string {
+		var result []string
+		for _, tf := range testFiles {
+			for _, test := range tf.Tests {
+				name := test.Name
+				if test.Note != "" {
+					name += " (" + test.Note + ")"
+				}
+				result = append(result, name)
+			}
+		}
+		return strings.Join(result, ", ")
+	}
+
+	tests := []struct {
+		name     string
+		only     string
+		skip     string
+		expected string
+		err      string
+	}{
+		{name: "list and range", only: "1,3-4", expected: "A (#1), C (#3), D (#4)"},
+		{name: "skip list", skip: "2,4", expected: "A, C, E"},
+		{name: "only and skip together", only: "1-5", skip: "3", expected: "A (#1), B (#2), D (#4), E (#5)"},
+		{name: "file qualified", only: "users.md:2", expected: "E (#5)"},
+		{name: "file qualified by path", skip: "tests/orders.md:1-3", expected: "D, E"},
+		{name: "out of range", only: "6", err: "test 6 does not exist (file has 5 tests)"},
+		{name: "file out of range", only: "users.md:3", err: "test users.md:3 does not exist (tests/users.md has 2 tests)"},
+		{name: "unknown file", skip: "payments.md:1", err: `no test file matches "payments.md"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var only, skip []testSelector
+			if tt.only != "" {
+				only, _ = parseSelectors(tt.only)
+			}
+			if tt.skip != "" {
+				skip, _ = parseSelectors(tt.skip)
+			}
+
+			result, err := selectTests(createTestFiles(), only, skip)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Errorf("expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := names(result); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+
+	t.Run("selected files keep their hooks", func(t *testing.T) {
+		only, _ := parseSelectors("5")
+		result, _ := selectTests(createTestFiles(), only, nil)
+		if len(result) != 1 || len(result[0].Hooks.AfterAll) != 1 {
+			t.Errorf("expected users.md with its hooks, got %+v", result)
+		}
+	})
+}
+
+func TestSelectLine(t *testing.T) {
+	content := `---
+root: https://api.example.com
+---
+
+## First
+GET /first
+
+## Notes
+
+No request here.
+
+## Second @smoke
+GET /second
+
+Asserts:
+- Status is 200
+`
+	tf := parseTestFile(content, "")
+	tf.Path = "api.md"
+
+	if tf.Tests[0].Line != 5 || tf.Tests[1].Line != 12 {
+		t.Fatalf("unexpected header lines: %d, %d", tf.Tests[0].Line, tf.Tests[1].Line)
+	}
+
+	tests := []struct {
+		line     int
+		expected string
+	}{
+		{line: 5, expected: "First"},
+		{line: 7, expected: "First"},
+		{line: 10, expected: ""},
+		{line: 12, expected: "Second"},
+		{line: 16, expected: "Second"},
+		{line: 2, expected: ""},
+	}
+
+	for _, tt := range tests {
+		result, err := selectLine([]TestFile{tf}, tt.line)
+		if tt.expected == "" {
+			if err == nil {
+				t.Errorf("line %d: expected error, got %+v", tt.line, result)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("line %d: unexpected error: %v", tt.line, err)
+			continue
+		}
+		if result[0].Tests[0].Name != tt.expected {
+			t.Errorf("line %d: expected %q, got %q", tt.line, tt.expected, result[0].Tests[0].Name)
+		}
+	}
+
+	if path, line := splitTargetLine("tests/api.md:42"); path != "tests/api.md" || line != 42 {
+		t.Errorf("expected tests/api.md and 42, got %q and %d", path, line)
+	}
+	if path, line := splitTargetLine("tests/api.md"); path != "tests/api.md" || line != 0 {
+		t.Errorf("expected target without line to be unchanged, got %q and %d", path, line)
+	}
+}
+
+func TestAddPrerequisites(t *testing.T) {
+	all := []TestFile{{
+		Path: "orders.md",
+		Tests: []Test{
+			{Name: "Login", Line: 1, SaveFields: []SaveField{{Field: "token", Variable: "token"}}},
+			{Name: "Create user", Line: 5, Headers: map[string]string{"Authorization": "Bearer {{token}}"}, SaveFields: []SaveField{{Field: "id", Variable: "user_id"}}},
+			{Name: "Create other user", Line: 10, SaveFields: []SaveField{{Field: "id", Variable: "other_id"}}},
+			{Name: "Recreate user", Line: 15, SaveFields: []SaveField{{Field: "id", Variable: "user_id"}}},
+			{Name: "Create order", Line: 20, Body: `{"user": "{{user_id}}"}`, SaveFields: []SaveField{{Field: "id", Variable: "order_id"}}},
+			{Name: "Get order", Line: 25, URL: "/orders/{{order_id}}"},
+			{Name: "List orders", Line: 30, URL: "/orders"},
+		},
+	}}
+
+	names := func(testFiles []TestFile) string {
+		var result []string
+		for _, tf := range testFiles {
+			for _, test := range tf.Tests {
+				name := test.Name
+				if test.Note != "" {
+					name += " (" + test.Note + ")"
+				}
+				result = append(result, name)
+			}
+		}
+		return strings.Join(result, ", ")
+	}
+
+	t.Run("pulls in the closest saver of each variable", func(t *testing.T) {
+		selected := []TestFile{{Path: "orders.md", Tests: []Test{{Name: "Get order (#6)", Line: 25, URL: "/orders/{{order_id}}"}}}}
+		result := addPrerequisites(selected, all)
+		expected := "Recreate user (prerequisite), Create order (prerequisite), Get order (#6)"
+		if got := names(result); got != expected {
+			t.Errorf("expected %q, got %q", expected, got)
+		}
+	})
+
+	t.Run("follows variables used in headers", func(t *testing.T) {
+		selected := []TestFile{{Path: "orders.md", Tests: []Test{all[0].Tests[1]}}}
+		result := addPrerequisites(selected, all)
+		expected := "Login (prerequisite), Create user"
+		if got := names(result); got != expected {
+			t.Errorf("expected %q, got %q", expected, got)
+		}
+	})
+
+	t.Run("follows variables used in multipart fields", func(t *testing.T) {
+		upload := Test{Name: "Upload avatar", Line: 35, URL: "/avatars", Multipart: []MultipartField{{Name: "owner", Value: "{{other_id}}"}}}
+		withUpload := []TestFile{{Path: "orders.md", Tests: append(append([]Test{}, all[0].Tests...), upload)}}
+		selected := []TestFile{{Path: "orders.md", Tests: []Test{upload}}}
+		result := addPrerequisites(selected, withUpload)
+		expected := "Create other user (prerequisite), Upload avatar"
+		if got := names(result); got != expected {
+			t.Errorf("expected %q, got %q", expected, got)
+		}
+	})
+
+	t.Run("tests without variables are unchanged", func(t *testing.T) {
+		selected := []TestFile{{Path: "orders.md", Tests: []Test{all[0].Tests[6]}}}
+		if got := names(addPrerequisites(selected, all)); got != "List orders" {
+			t.Errorf("expected only the selected test, got %q", got)
+		}
+	})
+}
+
+func TestLastRunState(t *testing.T) {
+	path := t.TempDir() + "/.marcus/last-run.json"
+
+	if _, err := loadLastRun(path); err == nil {
+		t.Error("expected error when no run has been recorded")
+	}
+
+	testFiles := []TestFile{
+		{Path: "tests/users.md", Tests: []Test{{Name: "Create user"}, {Name: "Delete user"}}},
+		{Path: "tests/orders.md", Tests: []Test{{Name: "Create order"}}},
+	}
+	failures := []TestResult{
+		{FilePath: "tests/users.md", Test: Test{Name: "Delete user"}, Index: 1},
+		{FilePath: "./tests/orders.md", Test: Test{Name: "Create order"}, Index: 0},
+		{FilePath: "tests/orders.md", Test: Test{Name: "After all"}, Index: -1},
+	}
+	if err := saveLastRun(path, testFiles, failures); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	state, err := loadLastRun(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(state.Failures) != 2 {
+		t.Fatalf("expected 2 failures (the hook's test is only recorded once), got %+v", state.Failures)
+	}
+
+	filtered := filterFailed(testFiles, state)
+	if len(filtered) != 2 || len(filtered[0].Tests) != 1 || filtered[0].Tests[0].Name != "Delete user" || filtered[1].Tests[0].Name != "Create order" {
+		t.Errorf("unexpected filtered tests: %+v", filtered)
+	}
+
+	// Rerunning just the users file, where everything now passes, keeps the orders failure
+	if err := saveLastRun(path, testFiles[:1], nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	state, _ = loadLastRun(path)
+	if len(state.Failures) != 1 || state.Failures[0] != (FailedTestRef{File: "tests/orders.md", Test: "Create order"}) {
+		t.Errorf("expected only the orders failure to remain, got %+v", state.Failures)
+	}
+
+	// A failed teardown, when every test passed, is recorded against the file's tests,
+	// so --failed has something to rerun
+	failures = []TestResult{{FilePath: "tests/users.md", Test: Test{Name: "After all"}, Index: -1}}
+	if err := saveLastRun(path, testFiles[:1], failures); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	state, _ = loadLastRun(path)
+	filtered = filterFailed(testFiles, state)
+	if len(state.Failures) != 3 || len(filtered) != 2 || len(filtered[0].Tests) != 2 {
+		t.Errorf("expected both users tests to be recorded for the hook failure, got %+v", state.Failures)
+	}
+}
+
+func TestWatcherScan(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(dir+"/"+name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	touch := func(name string) {
+		later := time.Now().Add(time.Minute)
+		if err := os.Chtimes(dir+"/"+name, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("payload.json", `{"name": "Alice"}`)
+	write("expected.json", `{"ok": true}`)
+	write("users.md", "## Create user\nPOST https://example.com/users\n\n```json\nFILE: payload.json\n```\n")
+	write("health.md", "## Health\nGET https://example.com/health\n\nAssert:\n- Body matches file `expected.json`\n")
+	write("other.md", "## Other\nGET https://example.com/other\n")
+
+	w := newWatcher(dir)
+	changed, err := w.scan()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(changed) != 3 {
+		t.Errorf("first scan should return every test file, got %v", changed)
+	}
+
+	if changed, _ := w.scan(); len(changed) != 0 {
+		t.Errorf("expected no changes, got %v", changed)
+	}
+
+	touch("payload.json")
+	if changed, _ := w.scan(); len(changed) != 1 || !strings.HasSuffix(changed[0], "users.md") {
+		t.Errorf("changing a payload should rerun users.md, got %v", changed)
+	}
+
+	touch("expected.json")
+	if changed, _ := w.scan(); len(changed) != 1 || !strings.HasSuffix(changed[0], "health.md") {
+		t.Errorf("changing an expected file should rerun health.md, got %v", changed)
+	}
+
+	touch("other.md")
+	if changed, _ := w.scan(); len(changed) != 1 || !strings.HasSuffix(changed[0], "other.md") {
+		t.Errorf("changing a test file should rerun it, got %v", changed)
+	}
+
+	write("new.md", "## New\nGET https://example.com/new\n")
+	if changed, _ := w.scan(); len(changed) != 1 || !strings.HasSuffix(changed[0], "new.md") {
+		t.Errorf("new test files should run, got %v", changed)
+	}
+}
+
+func TestCompareBodiesIgnoring(t *testing.T) {
+	expected := []byte(`{"id": 1, "name": "Ada", "meta": {"id": 7, "created_at": "x"}, "items": [{"id": 1, "sku": "a"}]}`)
+	actual := []byte(`{"name":"Ada","id":2,"meta":{"id":8,"created_at":"y"},"items":[{"sku":"a","id":9}]}`)
+
+	diff, err := compareBodies(expected, actual, []string{"id", "meta.created_at"}, jsonMatchOptions{})
+	if err != nil || diff != nil {
+		t.Errorf("expected bodies to match ignoring id and meta.created_at, got %q, %v", diff, err)
+	}
+	diff, _ = compareBodies(expected, actual, []string{"items.*.id"}, jsonMatchOptions{})
+	if diff == nil {
+		t.Error("expected bodies not to match when only items.*.id is ignored")
+	}
+	diff, _ = compareBodies(expected, actual, []string{"*.id", "id", "meta.created_at"}, jsonMatchOptions{})
+	if diff != nil {
+		t.Errorf("expected wildcard path to ignore nested ids, got %q", diff)
+	}
+
+	diff, err = compareBodies([]byte("plain text"), []byte("plain text"), nil, jsonMatchOptions{})
+	if err != nil || diff != nil {
+		t.Errorf("expected identical text bodies to match, got %q, %v", diff, err)
+	}
+}
+
+func TestCompareBodiesMatchers(t *testing.T) {
+	expected := []byte(`{
+		"id": "<uuid>",
+		"order": "<regex:^ord_>",
+		"total": "<number>",
+		"created_at": "<iso8601>",
+		"meta": "<any>",
+		"status": "paid"
+	}`)
+
+	actual := []byte(`{"id": "3f2b8c1e-9d4a-4b6f-8e2a-1c5d7f9b0a3e", "order": "ord_123", "total": 12.5, "created_at": "2024-05-01T10:00:00Z", "meta": {"x": 1}, "status": "paid"}`)
+	if diff, err := compareBodies(expected, actual, nil, jsonMatchOptions{}); err != nil || diff != nil {
+		t.Errorf("expected matchers to accept response, got %q, %v", diff, err)
+	}
+
+	actual = []byte(`{"id": "abc", "order": "inv_1", "total": "12.5", "created_at": "yesterday", "status": "paid"}`)
+	diff, _ := compareBodies(expected, actual, nil, jsonMatchOptions{})
+	want := []string{
+		`created_at: expected <iso8601>, got "yesterday"`,
+		`id: expected <uuid>, got "abc"`,
+		`meta: missing, expected "<any>"`,
+		`order: expected <regex:^ord_>, got "inv_1"`,
+		`total: expected <number>, got "12.5"`,
+	}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("expected diff %q, got %q", want, diff)
+	}
+}
+
+func TestCompareBodiesIgnoreOrder(t *testing.T) {
+	expected := []byte(`{"tags": ["a", "b", {"id": "<number>"}]}`)
+	actual := []byte(`{"tags": [{"id": 3}, "b", "a"]}`)
+
+	if diff, _ := compareBodies(expected, actual, nil, jsonMatchOptions{}); diff == nil {
+		t.Error("expected reordered array not to match by default")
+	}
+	if diff, _ := compareBodies(expected, actual, nil, jsonMatchOptions{IgnoreOrder: true}); diff != nil {
+		t.Errorf("expected reordered array to match ignoring order, got %q", diff)
+	}
+
+	actual = []byte(`{"tags": ["b", "c", {"id": 3}]}`)
+	diff, _ := compareBodies(expected, actual, nil, jsonMatchOptions{IgnoreOrder: true})
+	want := []string{`tags.0: no item matches expected "a"`, `tags.1: unexpected item "c"`}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("expected diff %q, got %q", want, diff)
+	}
+}
+
+func TestParseIgnoreClause(t *testing.T) {
+	tests := []struct {
+		line        string
+		ignore      []string
+		ignoreOrder bool
+	}{
+		{"Body matches file `a.json`", nil, false},
+		{"Body matches file `a.json` ignoring `id`, `created_at`", []string{"id", "created_at"}, false},
+		{"Body matches file `a.json` ignoring array order", nil, true},
+		{"Body matches file `a.json` ignoring `id` and array order", []string{"id"}, true},
+		{"Body matches snapshot ignoring `array order`", []string{"array order"}, false},
+	}
+
+	for _, tt := range tests {
+		assertions := parseAssertions("Assert:\n- "+tt.line+"\n", "")
+		if len(assertions) != 1 {
+			t.Fatalf("%s: expected 1 assertion, got %d", tt.line, len(assertions))
+		}
+		a := assertions[0]
+		if !reflect.DeepEqual(a.Ignore, tt.ignore) || a.IgnoreOrder != tt.ignoreOrder {
+			t.Errorf("%s: expected ignore %v order %v, got %v %v", tt.line, tt.ignore, tt.ignoreOrder, a.Ignore, a.IgnoreOrder)
+		}
+	}
+}
+
+func TestSnapshotAssertion(t *testing.T) {
+	response := `{"id": 1, "name": "Ada"}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(response))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "users.md")
+	content := "## Get user\n\nGET " + server.URL + "\n\nAsserts:\n- Body matches snapshot ignoring `id`\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	testFiles, err := collectTestFiles(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	test := testFiles[0].Tests[0]
+	snapshot := filepath.Join(dir, "__snapshots__", "users", "get-user.json")
+	if test.Assertions[0].Value != snapshot {
+		t.Fatalf("expected snapshot path %s, got %s", snapshot, test.Assertions[0].Value)
+	}
+
+	// First run writes the snapshot
+	if _, err := runTest(test, nil); err != nil {
+		t.Fatalf("first run failed: %v", err)
+	}
+	if _, err := os.Stat(snapshot); err != nil {
+		t.Fatalf("expected snapshot to be written: %v", err)
+	}
+
+	// Ignored fields may change
+	response = `{"id": 2, "name": "Ada"}`
+	if _, err := runTest(test, nil); err != nil {
+		t.Errorf("expected ignored id change to pass, got %v", err)
+	}
+
+	// Other changes fail until the snapshot is updated
+	response = `{"id": 2, "name": "Grace"}`
+	if _, err := runTest(test, nil); err == nil {
+		t.Error("expected changed name to fail the snapshot")
+	}
+	updateSnapshots = true
+	defer func() { updateSnapshots = false }()
+	if _, err := runTest(test, nil); err != nil {
+		t.Errorf("expected --update-snapshots run to pass, got %v", err)
+	}
+	updateSnapshots = false
+	if _, err := runTest(test, nil); err != nil {
+		t.Errorf("expected updated snapshot to match, got %v", err)
+	}
+}
+
+func TestJSONDiff(t *testing.T) {
+	lines, _ := compareBodies(
+		[]byte(`{"name": "Ada", "role": "admin", "tags": ["a", "b"], "meta": {"id": 1}}`),
+		[]byte(`{"name": "Grace", "tags": ["a"], "meta": {"id": 1}, "extra": true}`),
+		nil, jsonMatchOptions{},
+	)
+	expected := []string{
+		`extra: unexpected field with true`,
+		`name: expected "Ada", got "Grace"`,
+		`role: missing, expected "admin"`,
+		`tags: expected 2 items, got 1`,
+		`tags.1: missing, expected "b"`,
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected diff %q, got %q", expected, lines)
+	}
+}
+
+func TestTextDiff(t *testing.T) {
+	lines := textDiff("one\ntwo\nthree\n", "one\n2\nthree\nfour\n")
+	expected := []string{
+		"@@ -1,3 +1,4 @@",
+		" one",
+		"-two",
+		"+2",
+		" three",
+		"+four",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected diff %q, got %q", expected, lines)
+	}
+}
+
+func TestBodyMatchesFileShowsDiff(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "expected.json")
+	os.WriteFile(path, []byte(`{"name": "Ada", "age": 36}`), 0o644)
+
+	assertion := Assertion{Type: "body_matches_file", Value: path}
+	err := validateAssertion(assertion, 200, []byte(`{"name": "Ada", "age": 37}`), nil, 0)
+	if err == nil {
+		t.Fatal("expected assertion to fail")
+	}
+	if !strings.Contains(err.Error(), "Diff:") || !strings.Contains(err.Error(), "age: expected 36, got 37") {
+		t.Errorf("expected a field diff in error, got %q", err.Error())
+	}
+	if quiet := formatError(err, true); strings.Contains(quiet, "Diff:") {
+		t.Errorf("quiet mode should not show the diff, got %q", quiet)
+	}
+
+	// Long diffs are truncated
+	var lines []string
+	for i := 0; i < maxDiffLines+5; i++ {
+		lines = append(lines, "line")
+	}
+	if detail := diffDetail(lines); !strings.Contains(detail, "... 5 more lines") {
+		t.Errorf("expected truncated diff, got %q", detail)
+	}
+}
+
+func TestPartialMatchReportsEveryField(t *testing.T) {
+	assertion := Assertion{Type: "body_partial_match", Value: "\"name\": \"Ada\",\n\"age\": 36\n\"city\": \"London\""}
+	jsonBody := map[string]interface{}{"name": "Grace", "age": float64(36)}
+	err := validateAssertion(assertion, 200, nil, jsonBody, 0)
+	if err == nil {
+		t.Fatal("expected assertion to fail")
+	}
+	msg := err.Error()
+	for _, want := range []string{"2 fields differ", `name: expected "Ada", got "Grace"`, `city: missing, expected "London"`} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("expected %q in error, got %q", want, msg)
+		}
+	}
+}
+
+func TestBodySubsetMatch(t *testing.T) {
+	content := "GET https://example.com\n\nAssert:\n- Body partially matches:\n```json\n{\"user\": {\"name\": \"Ada\", \"roles\": [\"admin\"]}}\n```\n"
+	test := parseTestBlock("Subset", content, Defaults{Headers: map[string]string{}}, "")
+	if len(test.Assertions) != 1 || test.Assertions[0].Type != "body_subset_match" {
+		t.Fatalf("expected a body_subset_match assertion, got %+v", test.Assertions)
+	}
+	if test.Body != "" {
+		t.Errorf("expected the assertion's code block not to be sent as the body, got %q", test.Body)
+	}
+	assertion := test.Assertions[0]
+
+	body := []byte(`{"id": 1, "user": {"name": "Ada", "email": "ada@example.com", "roles": ["user", "admin"]}}`)
+	if err := validateAssertion(assertion, 200, body, nil, 0); err != nil {
+		t.Errorf("expected subset to match, got %v", err)
+	}
+
+	body = []byte(`{"user": {"name": "Grace", "roles": ["user"]}}`)
+	err := validateAssertion(assertion, 200, body, nil, 0)
+	if err == nil {
+		t.Fatal("expected subset not to match")
+	}
+	for _, want := range []string{"2 differences", `user.name: expected "Ada", got "Grace"`, `user.roles.0: no item matches expected "admin"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in error, got %q", want, err.Error())
+		}
+	}
+}
+
+func TestSubsetArrayContainment(t *testing.T) {
+	// A loose expected item must not take the only match of a stricter one
+	expected := []interface{}{
+		map[string]interface{}{"a": float64(1)},
+		map[string]interface{}{"a": float64(1), "b": float64(2)},
+	}
+	actual := []interface{}{
+		map[string]interface{}{"a": float64(1), "b": float64(2)},
+		map[string]interface{}{"a": float64(1)},
+		"extra",
+	}
+	if diff := jsonDiff("", expected, actual, jsonMatchOptions{Subset: true}); diff != nil {
+		t.Errorf("expected array to contain items, got %q", diff)
+	}
+
+	expected = append(expected, map[string]interface{}{"a": float64(1)})
+	if diff := jsonDiff("", expected, actual, jsonMatchOptions{Subset: true}); len(diff) != 1 {
+		t.Errorf("expected one unmatched item, got %q", diff)
+	}
+}
+
+func TestTextAndRegexAssertions(t *testing.T) {
+	assertions := parseAssertions("Assert:\n- Body includes text `Welcome back`\n- Body matches regex `(?i)^<!doctype html>`\n", "")
+	if len(assertions) != 2 || assertions[0].Type != "body_includes_text" || assertions[1].Type != "body_matches_regex" {
+		t.Fatalf("unexpected assertions %+v", assertions)
+	}
+
+	body := []byte("<!DOCTYPE html><p>Welcome back, Ada</p>")
+	for _, a := range assertions {
+		if err := validateAssertion(a, 200, body, nil, 0); err != nil {
+			t.Errorf("%s: expected pass, got %v", a.Type, err)
+		}
+	}
+	for _, a := range assertions {
+		if err := validateAssertion(a, 200, []byte("Goodbye"), nil, 0); err == nil {
+			t.Errorf("%s: expected failure", a.Type)
+		}
+	}
+}
+
+func TestXPathAssertions(t *testing.T) {
+	body := []byte(`<?xml version="1.0"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/">
+  <soap:Body>
+    <users>
+      <user id="1"><name>Ada</name><role>admin</role></user>
+      <user id="2"><name>Grace</name><role>user</role></user>
+    </users>
+  </soap:Body>
+</soap:Envelope>`)
+
+	tests := []struct {
+		line string
+		pass bool
+	}{
+		{"XPath `/soap:Envelope/soap:Body/users/user[1]/name` equals `Ada`", true},
+		{"XPath `//user[@id='2']/name` equals `Grace`", true},
+		{"XPath `//user[role='admin']/@id` equals `1`", true},
+		{"XPath `//user[last()]/name/text()` equals `Grace`", true},
+		{"XPath `//user[contains(name, 'Gr')]/role` equals `user`", true},
+		{"XPath `//user` count is 2", true},
+		{"XPath `//users/*` count is 2", true},
+		{"XPath `//fault` exists", false},
+		{"XPath `//user/name` equals `Linus`", false},
+		{"XPath `//user` count is 3", false},
+	}
+
+	for _, tt := range tests {
+		assertions := parseAssertions("Assert:\n- "+tt.line+"\n", "")
+		if len(assertions) != 1 {
+			t.Fatalf("%s: expected 1 assertion, got %+v", tt.line, assertions)
+		}
+		err := validateAssertion(assertions[0], 200, body, nil, 0)
+		if tt.pass && err != nil {
+			t.Errorf("%s: expected pass, got %v", tt.line, err)
+		}
+		if !tt.pass && err == nil {
+			t.Errorf("%s: expected failure", tt.line)
+		}
+	}
+}
+
+func TestElementAssertions(t *testing.T) {
+	body := []byte(`<!DOCTYPE html>
+<html>
+<head><title>Home</title><meta charset="utf-8"><script>if (a < b && c) {}</script></head>
+<body>
+  <h1 class="title main">  Welcome
+    home </h1>
+  <ul id="nav">
+    <li><a href="/">Home</a>
+    <li class="active"><a href="/about">About &amp; us</a>
+    <li><a href="/login" data-role=login>Log in</a>
+  </ul>
+  <p>Line<br>break
+</body>
+</html>`)
+
+	tests := []struct {
+		line string
+		pass bool
+	}{
+		{"Element `h1.title` has text `Welcome home`", true},
+		{"Element `#nav > li` count is 3", true},
+		{"Element `ul li.active a` has text `About & us`", true},
+		{"Element `a[href^=\"/log\"]` has text `Log in`", true},
+		{"Element `a[data-role=login]` exists", true},
+		{"Element `li:first-child a` has text `Home`", true},
+		{"Element `title, h1` count is 2", true},
+		{"Element `p` has text `Line break`", true},
+		{"Element `h1.subtitle` exists", false},
+		{"Element `li a` has text `Contact`", false},
+		{"Element `body > a` count is 3", false},
+	}
+
+	for _, tt := range tests {
+		assertions := parseAssertions("Assert:\n- "+tt.line+"\n", "")
+		if len(assertions) != 1 {
+			t.Fatalf("%s: expected 1 assertion, got %+v", tt.line, assertions)
+		}
+		err := validateAssertion(assertions[0], 200, body, nil, 0)
+		if tt.pass && err != nil {
+			t.Errorf("%s: expected pass, got %v", tt.line, err)
+		}
+		if !tt.pass && err == nil {
+			t.Errorf("%s: expected failure", tt.line)
+		}
+	}
+}
+
+func TestParseBodyBlockLanguages(t *testing.T) {
+	defaults := Defaults{Headers: map[string]string{}}
+
+	tests := []struct {
+		language    string
+		contentType string
+		body        string
+	}{
+		{"json", "application/json", `{"a": 1}`},
+		{"xml", "application/xml", "<user><name>Ada</name></user>"},
+		{"text", "text/plain", "hello"},
+		{"yaml", "application/yaml", "name: Ada"},
+		{"csv", "text/csv", "id,name\n1,Ada"},
+		{"ndjson", "application/x-ndjson", "{\"a\":1}\n{\"a\":2}\n"},
+		{"protobuf-text", "", "name: 'Ada'"},
+		{"", "", "raw body"},
+	}
+
+	for _, tt := range tests {
+		content := "POST https://example.com\n\n```" + tt.language + "\n" + strings.TrimSuffix(tt.body, "\n") + "\n```\n"
+		test := parseTestBlock("Body", content, defaults, "")
+		if test.Body != tt.body {
+			t.Errorf("%s: expected body %q, got %q", tt.language, tt.body, test.Body)
+		}
+		if test.ContentType != tt.contentType {
+			t.Errorf("%s: expected content type %q, got %q", tt.language, tt.contentType, test.ContentType)
+		}
+	}
+
+	// An explicit Content-Type header wins over the block language
+	test := parseTestBlock("Body", "POST https://example.com\n- Content-Type: text/xml\n\n```xml\n<a/>\n```\n", defaults, "")
+	if test.ContentType != "text/xml" {
+		t.Errorf("expected header Content-Type to be kept, got %q", test.ContentType)
+	}
+
+	// Code blocks in the description aren't the body
+	test = parseTestBlock("Body", "Example:\n\n```bash\ncurl example.com\n```\n\nGET https://example.com\n", defaults, "")
+	if test.Body != "" {
+		t.Errorf("expected no body, got %q", test.Body)
+	}
+}
+
+func TestMultipartUpload(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "payloads"), 0o755)
+	avatar := []byte{0x89, 'P', 'N', 'G', 0x00, 0xff}
+	os.WriteFile(filepath.Join(dir, "payloads", "avatar.png"), avatar, 0o644)
+	os.WriteFile(filepath.Join(dir, "payloads", "notes.txt"), []byte("hello"), 0o644)
+
+	content := "POST {{base}}/upload\n\n```multipart\nname=Ada {{last}}\navatar=@payloads/avatar.png;type=image/x-custom\nnotes=@payloads/notes.txt;filename=readme.txt\n```\n\nAssert:\n- Status is 200\n"
+	test := parseTestBlock("Upload", content, Defaults{Headers: map[string]string{}}, dir)
+	if len(test.Multipart) != 3 {
+		t.Fatalf("expected 3 multipart fields, got %+v", test.Multipart)
+	}
+	if test.Multipart[1].File != filepath.Join(dir, "payloads", "avatar.png") {
+		t.Errorf("expected file path resolved from the test file, got %s", test.Multipart[1].File)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if got := r.FormValue("name"); got != "Ada Lovelace" {
+			http.Error(w, "name: "+got, http.StatusBadRequest)
+			return
+		}
+		file, header, err := r.FormFile("avatar")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(file)
+		if !bytes.Equal(data, avatar) || header.Filename != "avatar.png" || header.Header.Get("Content-Type") != "image/x-custom" {
+			http.Error(w, "avatar mismatch", http.StatusBadRequest)
+			return
+		}
+		_, header, err = r.FormFile("notes")
+		if err != nil || header.Filename != "readme.txt" || !strings.HasPrefix(header.Header.Get("Content-Type"), "text/plain") {
+			http.Error(w, "notes mismatch", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	vars := map[string]interface{}{"base": server.URL, "last": "Lovelace"}
+	if _, err := runTest(test, vars); err != nil {
+		t.Errorf("expected upload to succeed, got %v", err)
+	}
+
+	test.Multipart[1].File = filepath.Join(dir, "missing.png")
+	if _, err := runTest(test, vars); err == nil || !strings.Contains(err.Error(), "could not read multipart file") {
+		t.Errorf("expected missing file error, got %v", err)
+	}
+}
+
+func TestBinaryPayloadAndAssertions(t *testing.T) {
+	dir := t.TempDir()
+	payload := []byte{0x00, 0xff, '{', '{', 'i', 'd', '}', '}', 0xfe, 0x01}
+	os.WriteFile(filepath.Join(dir, "blob.bin"), payload, 0o644)
+	image := []byte{0x89, 'P', 'N', 'G', 0x0d, 0x0a, 0x1a, 0x0a, 0x00, 0x01}
+	os.WriteFile(filepath.Join(dir, "expected.png"), image, 0o644)
+
+	var received []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(image)
+	}))
+	defer server.Close()
+
+	wrongSum := "9c3b4a8e1d5e5c0f3e0c8f3e8f0b3f0d5e6a7b8c9d0e1f2a3b4c5d6e7f8a9b0c"
+	content := "POST " + server.URL + "\n\n```binary\nFILE: blob.bin\n```\n\nAssert:\n- Status is 200\n- Body size is 10 bytes\n- Body matches file `expected.png`\n"
+	test := parseTestBlock("Upload", content, Defaults{Headers: map[string]string{}}, dir)
+	if !test.RawBody || test.ContentType != "application/octet-stream" {
+		t.Fatalf("expected raw binary body, got RawBody=%v ContentType=%q", test.RawBody, test.ContentType)
+	}
+
+	if _, err := runTest(test, map[string]interface{}{"id": "42"}); err != nil {
+		t.Fatalf("expected binary test to pass, got %v", err)
+	}
+	if !bytes.Equal(received, payload) {
+		t.Errorf("expected payload sent untouched, got %v", received)
+	}
+
+	assertions := parseAssertions("Assert:\n- Body sha256 equals `"+wrongSum+"`\n", "")
+	if len(assertions) != 1 || assertions[0].Type != "body_sha256" {
+		t.Fatalf("unexpected assertions %+v", assertions)
+	}
+	err := validateAssertion(assertions[0], 200, image, nil, 0)
+	if err == nil {
+		t.Fatal("expected sha256 mismatch")
+	}
+	actual := regexp.MustCompile(`got ([0-9a-f]{64})`).FindStringSubmatch(err.Error())[1]
+	if err := validateAssertion(Assertion{Type: "body_sha256", Value: strings.ToUpper(actual)}, 200, image, nil, 0); err != nil {
+		t.Errorf("expected sha256 to match case-insensitively, got %v", err)
+	}
+
+	// Binary mismatches and status failures describe the body instead of printing it
+	other := append([]byte{}, image...)
+	other[5] = 0x00
+	err = validateAssertion(Assertion{Type: "body_matches_file", Value: filepath.Join(dir, "expected.png")}, 200, other, nil, 0)
+	if err == nil || !strings.Contains(err.Error(), "first difference at byte 5") || strings.Contains(err.Error(), "PNG") {
+		t.Errorf("expected a byte offset without binary content, got %v", err)
+	}
+	err = validateAssertion(Assertion{Type: "status", Value: "404"}, 200, image, nil, 0)
+	if err == nil || !strings.Contains(err.Error(), "<binary, 10 bytes") {
+		t.Errorf("expected binary summary in status failure, got %v", err)
+	}
+}
+
+func TestGraphQLRequests(t *testing.T) {
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = nil
+		json.NewDecoder(r.Body).Decode(&received)
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(received["query"].(string), "secret") {
+			w.Write([]byte(`{"data": null, "errors": [{"message": "Not authorized to access secret"}]}`))
+			return
+		}
+		w.Write([]byte(`{"data": {"user": {"name": "Ada"}}}`))
+	}))
+	defer server.Close()
+
+	content := "POST " + server.URL + "\n\n```variables\n{\"id\": {{user_id}}}\n```\n\n```graphql\nquery GetUser($id: ID!) {\n  user(id: $id) { name }\n}\n```\n\nAssert:\n- Field `data.user.name` equals `\"Ada\"`\n"
+	test := parseTestBlock("User", content, Defaults{Headers: map[string]string{}}, "")
+	if !test.GraphQL || test.ContentType != "application/json" || test.Variables != `{"id": {{user_id}}}` {
+		t.Fatalf("unexpected GraphQL test %+v", test)
+	}
+	if vars := testVariables(test); len(vars) != 1 || vars[0] != "user_id" {
+		t.Errorf("expected variables block to count as a dependency, got %v", vars)
+	}
+
+	if _, err := runTest(test, map[string]interface{}{"user_id": 7}); err != nil {
+		t.Fatalf("expected GraphQL test to pass, got %v", err)
+	}
+	if !strings.HasPrefix(received["query"].(string), "query GetUser") {
+		t.Errorf("expected query in body, got %v", received)
+	}
+	if variables, ok := received["variables"].(map[string]interface{}); !ok || variables["id"] != float64(7) {
+		t.Errorf("expected interpolated variables in body, got %v", received["variables"])
+	}
+
+	// Errors fail the test by default
+	secret := parseTestBlock("Secret", "POST "+server.URL+"\n\n```graphql\n{ secret }\n```\n", Defaults{Headers: map[string]string{}}, "")
+	if _, err := runTest(secret, nil); err == nil || !strings.Contains(err.Error(), "Not authorized") {
+		t.Errorf("expected GraphQL errors to fail the test, got %v", err)
+	}
+	if _, ok := received["variables"]; ok {
+		t.Errorf("expected no variables without a variables block, got %v", received)
+	}
+
+	// ...unless the test expects them
+	secret.Assertions = parseAssertions("Assert:\n- GraphQL errors contain `Not authorized`\n", "")
+	if _, err := runTest(secret, nil); err != nil {
+		t.Errorf("expected GraphQL errors assertion to pass, got %v", err)
+	}
+	secret.Assertions = parseAssertions("Assert:\n- GraphQL errors contain `Rate limited`\n", "")
+	if _, err := runTest(secret, nil); err == nil {
+		t.Error("expected GraphQL errors assertion to fail for a different message")
+	}
+}
+
+const testShopProto = `syntax = "proto3";
+
+package shop.v1;
+
+import "google/protobuf/timestamp.proto";
+import "google/api/annotations.proto"; // Not available; only used for options
+
+// Orders looks up orders
+service Orders {
+  rpc GetOrder(GetOrderRequest) returns (Order) {
+    option (google.api.http) = { get: "/v1/orders/{id}" };
+  }
+  rpc WatchOrder(GetOrderRequest) returns (stream Order);
+}
+
+message GetOrderRequest {
+  int64 id = 1;
+}
+
+/* An order, with a nested type and enum */
+message Order {
+  enum Status {
+    STATUS_UNSPECIFIED = 0;
+    PAID = 1;
+    SHIPPED = 2;
+  }
+  message Item {
+    string sku = 1;
+    double price = 2;
+  }
+
+  int64 id = 1;
+  string name = 2 [json_name = "title"];
+  repeated int32 quantities = 3;
+  Status status = 4;
+  map<string, string> labels = 5;
+  Item item = 6;
+  google.protobuf.Timestamp created_at = 7;
+  optional string note = 8;
+  reserved 9, 10;
+}
+`
+
+// testOrderMessage is the wire encoding of an Order, written out by hand
+func testOrderMessage() []byte {
+	// id = 7, name = "Widget"
+	order := []byte{0x08, 0x07, 0x12, 0x06, 'W', 'i', 'd', 'g', 'e', 't'}
+	// quantities = [1, 2], packed
+	order = append(order, 0x1a, 0x02, 0x01, 0x02)
+	// status = SHIPPED
+	order = append(order, 0x20, 0x02)
+	// labels = {"color": "red"}
+	order = append(order, 0x2a, 0x0c, 0x0a, 0x05, 'c', 'o', 'l', 'o', 'r', 0x12, 0x03, 'r', 'e', 'd')
+	// item = {sku: "W-1", price: 9.5}
+	order = append(order, 0x32, 0x0e, 0x0a, 0x03, 'W', '-', '1', 0x11, 0, 0, 0, 0, 0, 0, 0x23, 0x40)
+	// created_at = {seconds: 1700000000}
+	order = append(order, 0x3a, 0x06, 0x08, 0x80, 0xe2, 0xcf, 0xaa, 0x06)
+	return order
+}
+
+func grpcFrame(flags byte, message []byte) []byte {
+	return append([]byte{flags, 0, 0, byte(len(message) >> 8), byte(len(message))}, message...)
+}
+
+func writeTestProto(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "shop.proto")
+	if err := os.WriteFile(path, []byte(testShopProto), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestProtoCodec(t *testing.T) {
+	schema, err := loadProtoSchema(writeTestProto(t))
+	if err != nil {
+		t.Fatalf("failed to load proto: %v", err)
+	}
+	method := schema.Services["shop.v1.Orders"].Methods["GetOrder"]
+	if method == nil || method.Input != "shop.v1.GetOrderRequest" || method.Output != "shop.v1.Order" {
+		t.Fatalf("unexpected GetOrder method %+v", method)
+	}
+	if !schema.Services["shop.v1.Orders"].Methods["WatchOrder"].ServerStreaming {
+		t.Error("expected WatchOrder to be server streaming")
+	}
+	order := schema.Messages["shop.v1.Order"]
+
+	// Decoding follows the JSON mapping, and includes defaults but not unset optionals
+	decoded, err := decodeProto(order, testOrderMessage())
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	got, _ := json.Marshal(decoded)
+	want := `{"createdAt":"2023-11-14T22:13:20Z","id":"7","item":{"price":9.5,"sku":"W-1"},"labels":{"color":"red"},"quantities":[1,2],"status":"SHIPPED","title":"Widget"}`
+	if string(got) != want {
+		t.Errorf("decoded order\n got: %s\nwant: %s", got, want)
+	}
+
+	empty, _ := decodeProto(order, nil)
+	got, _ = json.Marshal(empty)
+	if want := `{"id":"0","labels":{},"quantities":[],"status":"STATUS_UNSPECIFIED","title":""}`; string(got) != want {
+		t.Errorf("decoded empty order\n got: %s\nwant: %s", got, want)
+	}
+
+	// A wire type that doesn't match the field is an error, not a panic
+	for _, data := range [][]byte{{0x0a, 0x00}, {0x08, 0x00, 0x0a, 0x01, 0x07}, {0x12, 0x00, 0x10, 0x01}} {
+		if _, err := decodeProto(order, data); err == nil || !strings.Contains(err.Error(), "wrong wire type") {
+			t.Errorf("decoding % x: expected a wire type error, got %v", data, err)
+		}
+	}
+	decoded, err = decodeProto(order, []byte{0x1a, 0x00})
+	if quantities, _ := decoded.(map[string]interface{})["quantities"].([]interface{}); err != nil || quantities == nil || len(quantities) != 0 {
+		t.Errorf("expected an empty packed field to decode to an empty list, got %v (%v)", decoded, err)
+	}
+
+	// Encoding accepts JSON or .proto names, enum names, and 64-bit ints as strings
+	var value interface{}
+	decoder := json.NewDecoder(strings.NewReader(`{"id": "7", "name": "Widget", "quantities": [1, 2], "status": "SHIPPED",
+		"labels": {"color": "red"}, "item": {"sku": "W-1", "price": 9.5}, "created_at": "2023-11-14T22:13:20Z"}`))
+	decoder.UseNumber()
+	decoder.Decode(&value)
+	encoded, err := encodeProto(order, value)
+	if err != nil {
+		t.Fatalf("failed to encode: %v", err)
+	}
+	if !bytes.Equal(encoded, testOrderMessage()) {
+		t.Errorf("encoded order\n got: % x\nwant: % x", encoded, testOrderMessage())
+	}
+
+	// Errors name the field
+	for body, expected := range map[string]string{
+		`{"id": "seven"}`:              "id: expected a 64-bit integer",
+		`{"colour": "red"}`:            `unknown field "colour" in shop.v1.Order`,
+		`{"status": "LOST"}`:           `unknown value "LOST" for enum shop.v1.Order.Status`,
+		`{"item": {"price": "cheap"}}`: "item: price: expected a number",
+		`{"quantities": 3}`:            "quantities: expected a JSON array",
+	} {
+		var value interface{}
+		json.Unmarshal([]byte(body), &value)
+		if _, err := encodeProto(order, value); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("encoding %s: expected error containing %q, got %v", body, expected, err)
+		}
+	}
+}
+
+func TestProtoScalarsAndWellKnownTypes(t *testing.T) {
+	schema := newProtoSchema()
+	source := `syntax = "proto3";
+import "google/protobuf/duration.proto";
+import "google/protobuf/struct.proto";
+import "google/protobuf/wrappers.proto";
+message Scalars {
+  int32 small = 1;
+  sint64 zigzag = 2;
+  fixed32 fixed = 3;
+  float ratio = 4;
+  bool flag = 5;
+  bytes data = 6;
+  google.protobuf.Duration timeout = 7;
+  google.protobuf.Struct extra = 8;
+  google.protobuf.StringValue nickname = 9;
+  repeated uint64 ids = 10 [packed = false];
+}`
+	if _, err := parseProto(schema, "scalars.proto", source); err != nil {
+		t.Fatal(err)
+	}
+	for name, source := range builtinProtos {
+		if strings.HasPrefix(name, "google/protobuf/") && name != "google/protobuf/descriptor.proto" {
+			parseProto(schema, name, source)
+		}
+	}
+	if err := schema.resolve(); err != nil {
+		t.Fatal(err)
+	}
+	msg := schema.Messages["Scalars"]
+
+	input := `{"small":-1,"zigzag":"-2","fixed":7,"ratio":0.1,"flag":true,"data":"aGk=","timeout":"1.5s","extra":{"a":[1,"b",null,{"c":false}]},"nickname":"Ada","ids":["18446744073709551615",1]}`
+	var value interface{}
+	decoder := json.NewDecoder(strings.NewReader(input))
+	decoder.UseNumber()
+	decoder.Decode(&value)
+	encoded, err := encodeProto(msg, value)
+	if err != nil {
+		t.Fatalf("failed to encode: %v", err)
+	}
+	// A negative int32 takes ten bytes, and a sint64 is zigzag encoded
+	if prefix := []byte{0x08, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01, 0x10, 0x03}; !bytes.HasPrefix(encoded, prefix) {
+		t.Errorf("expected encoding to start with % x, got % x", prefix, encoded)
+	}
+
+	decoded, err := decodeProto(msg, encoded)
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	got, _ := json.Marshal(decoded)
+	want := `{"data":"aGk=","extra":{"a":[1,"b",null,{"c":false}]},"fixed":7,"flag":true,"ids":["18446744073709551615","1"],"nickname":"Ada","ratio":0.1,"small":-1,"timeout":"1.5s","zigzag":"-2"}`
+	if string(got) != want {
+		t.Errorf("round trip\n got: %s\nwant: %s", got, want)
+	}
+}
+
+func TestParseGRPCTests(t *testing.T) {
+	dir := t.TempDir()
+	content := "---\nroot: https://localhost:50051\nproto: protos/shop.proto\n---\n\n## Get order\n\nGRPC shop.v1.Orders/GetOrder\n- Authorization: Bearer abc\n\n```json\n{\"id\": 7}\n```\n\nAssert:\n- gRPC status is OK\n\n## Reflected\n\nGRPC https://other:443/shop.v1.Orders/GetOrder\n- Proto: /abs/other.proto\n\n## Web\n\nGRPC-WEB /shop.v1.Orders/GetOrder\n"
+	tests := parseTests(content, dir)
+	if len(tests) != 3 {
+		t.Fatalf("expected 3 tests, got %d", len(tests))
+	}
+
+	get := tests[0]
+	if get.Method != "GRPC" || get.URL != "https://localhost:50051/shop.v1.Orders/GetOrder" {
+		t.Errorf("unexpected request line %s %s", get.Method, get.URL)
+	}
+	if get.ProtoFile != filepath.Join(dir, "protos/shop.proto") {
+		t.Errorf("expected proto file from frontmatter, got %q", get.ProtoFile)
+	}
+	if get.Headers["Authorization"] != "Bearer abc" || get.Body != `{"id": 7}` {
+		t.Errorf("expected metadata and body, got %v %q", get.Headers, get.Body)
+	}
+	if len(get.Assertions) != 1 || get.Assertions[0].Type != "grpc_status" || get.Assertions[0].Value != "OK" {
+		t.Errorf("expected gRPC status assertion, got %+v", get.Assertions)
+	}
+
+	if tests[1].ProtoFile != "/abs/other.proto" || tests[1].Headers["Proto"] != "" {
+		t.Errorf("expected Proto option to override frontmatter, got %q %v", tests[1].ProtoFile, tests[1].Headers)
+	}
+	if tests[2].Method != "GRPC-WEB" || tests[2].URL != "https://localhost:50051/shop.v1.Orders/GetOrder" {
+		t.Errorf("unexpected gRPC-Web request line %s %s", tests[2].Method, tests[2].URL)
+	}
+}
+
+func TestGRPCWebRequests(t *testing.T) {
+	var received []byte
+	var metadata http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+		metadata = r.Header
+		if r.URL.Path != "/shop.v1.Orders/GetOrder" || r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc-web+proto")
+		if bytes.Equal(received, grpcFrame(0, []byte{0x08, 0x63})) {
+			// Trailers-only response for order 99
+			w.Header().Set("Grpc-Status", "5")
+			w.Header().Set("Grpc-Message", "order%2099%20not%20found")
+			return
+		}
+		w.Write(grpcFrame(0, testOrderMessage()))
+		w.Write(grpcFrame(0x80, []byte("grpc-status: 0\r\ngrpc-message: \r\n")))
+	}))
+	defer server.Close()
+
+	proto := writeTestProto(t)
+	content := "GRPC-WEB " + server.URL + "/shop.v1.Orders/GetOrder\n- Proto: " + filepath.Base(proto) + "\n- Authorization: Bearer {{token}}\n\n```json\n{\"id\": \"{{order_id}}\"}\n```\n\nAssert:\n- Field `id` equals `7`\n- Field `title` equals `\"Widget\"`\n- Field `status` equals `\"SHIPPED\"`\n- Field `item.price` equals `9.5`\n- Field `createdAt` equals `\"2023-11-14T22:13:20Z\"`\n\nSave:\n- Field `labels.color` as `color`\n"
+	test := parseTestBlock("Get order", content, Defaults{Headers: map[string]string{}}, filepath.Dir(proto))
+
+	vars, err := runTest(test, map[string]interface{}{"token": "abc", "order_id": 7})
+	if err != nil {
+		t.Fatalf("expected gRPC-Web test to pass, got %v", err)
+	}
+	if vars["color"] != "red" {
+		t.Errorf("expected saved map value, got %v", vars["color"])
+	}
+	if !bytes.Equal(received, grpcFrame(0, []byte{0x08, 0x07})) {
+		t.Errorf("unexpected request body % x", received)
+	}
+	if metadata.Get("Content-Type") != "application/grpc-web+proto" || metadata.Get("Authorization") != "Bearer abc" {
+		t.Errorf("unexpected request headers %v", metadata)
+	}
+
+	// A status other than OK fails the test...
+	missing := parseTestBlock("Missing", "GRPC-WEB "+server.URL+"/shop.v1.Orders/GetOrder\n- Proto: "+proto+"\n\n```json\n{\"id\": 99}\n```\n", Defaults{Headers: map[string]string{}}, "")
+	if _, err := runTest(missing, nil); err == nil || !strings.Contains(err.Error(), "NOT_FOUND: order 99 not found") {
+		t.Errorf("expected NOT_FOUND to fail the test, got %v", err)
+	}
+
+	// ...unless the test expects it, and the status is readable as JSON
+	missing.Assertions = parseAssertions("Assert:\n- gRPC status is NOT_FOUND\n- Field `message` equals `\"order 99 not found\"`\n", "")
+	if _, err := runTest(missing, nil); err != nil {
+		t.Errorf("expected gRPC status assertion to pass, got %v", err)
+	}
+	missing.Assertions = parseAssertions("Assert:\n- gRPC status is `PERMISSION_DENIED`\n", "")
+	if _, err := runTest(missing, nil); err == nil || !strings.Contains(err.Error(), "expected PERMISSION_DENIED, got NOT_FOUND") {
+		t.Errorf("expected gRPC status assertion to fail, got %v", err)
+	}
+
+	// gRPC-Web can't use server reflection, and streaming methods aren't supported
+	noProto := parseTestBlock("No proto", "GRPC-WEB "+server.URL+"/shop.v1.Orders/GetOrder\n", Defaults{Headers: map[string]string{}}, "")
+	if _, err := runTest(noProto, nil); err == nil || !strings.Contains(err.Error(), "need a .proto file") {
+		t.Errorf("expected error without a .proto file, got %v", err)
+	}
+	streaming := parseTestBlock("Watch", "GRPC-WEB "+server.URL+"/shop.v1.Orders/WatchOrder\n- Proto: "+proto+"\n", Defaults{Headers: map[string]string{}}, "")
+	if _, err := runTest(streaming, nil); err == nil || !strings.Contains(err.Error(), "only unary calls") {
+		t.Errorf("expected streaming method to be rejected, got %v", err)
+	}
+}
+
+// encodeTestProto encodes JSON as a message, for building test responses
+func encodeTestProto(t *testing.T, msg *protoMessage, body string) []byte {
+	t.Helper()
+	var value interface{}
+	decoder := json.NewDecoder(strings.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := encodeProto(msg, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded
+}
+
+func TestGRPCWithServerReflection(t *testing.T) {
+	builtins, err := loadProtoSchema("grpc/reflection/v1/reflection.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileType := builtins.Messages["google.protobuf.FileDescriptorProto"]
+	shop := encodeTestProto(t, fileType, `{"name": "shop.proto", "package": "shop.v1", "syntax": "proto3",
+		"dependency": ["google/protobuf/timestamp.proto"],
+		"messageType": [
+			{"name": "GetOrderRequest", "field": [{"name": "id", "number": 1, "label": "LABEL_OPTIONAL", "type": "TYPE_INT64", "jsonName": "id"}]},
+			{"name": "Order", "field": [
+				{"name": "id", "number": 1, "label": "LABEL_OPTIONAL", "type": "TYPE_INT64", "jsonName": "id"},
+				{"name": "name", "number": 2, "label": "LABEL_OPTIONAL", "type": "TYPE_STRING", "jsonName": "title"},
+				{"name": "quantities", "number": 3, "label": "LABEL_REPEATED", "type": "TYPE_INT32", "jsonName": "quantities"},
+				{"name": "status", "number": 4, "label": "LABEL_OPTIONAL", "type": "TYPE_ENUM", "typeName": ".shop.v1.Order.Status", "jsonName": "status"},
+				{"name": "labels", "number": 5, "label": "LABEL_REPEATED", "type": "TYPE_MESSAGE", "typeName": ".shop.v1.Order.LabelsEntry", "jsonName": "labels"},
+				{"name": "item", "number": 6, "label": "LABEL_OPTIONAL", "type": "TYPE_MESSAGE", "typeName": ".shop.v1.Order.Item", "jsonName": "item"},
+				{"name": "created_at", "number": 7, "label": "LABEL_OPTIONAL", "type": "TYPE_MESSAGE", "typeName": ".google.protobuf.Timestamp", "jsonName": "createdAt"}
+			],
+			"nestedType": [
+				{"name": "LabelsEntry", "options": {"mapEntry": true}, "field": [
+					{"name": "key", "number": 1, "label": "LABEL_OPTIONAL", "type": "TYPE_STRING"},
+					{"name": "value", "number": 2, "label": "LABEL_OPTIONAL", "type": "TYPE_STRING"}
+				]},
+				{"name": "Item", "field": [
+					{"name": "sku", "number": 1, "label": "LABEL_OPTIONAL", "type": "TYPE_STRING"},
+					{"name": "price", "number": 2, "label": "LABEL_OPTIONAL", "type": "TYPE_DOUBLE"}
+				]}
+			],
+			"enumType": [{"name": "Status", "value": [{"name": "STATUS_UNSPECIFIED", "number": 0}, {"name": "PAID", "number": 1}, {"name": "SHIPPED", "number": 2}]}]}
+		],
+		"service": [{"name": "Orders", "method": [{"name": "GetOrder", "inputType": ".shop.v1.GetOrderRequest", "outputType": ".shop.v1.Order"}]}]}`)
+	timestamp := encodeTestProto(t, fileType, `{"name": "google/protobuf/timestamp.proto", "package": "google.protobuf", "syntax": "proto3",
+		"messageType": [{"name": "Timestamp", "field": [
+			{"name": "seconds", "number": 1, "label": "LABEL_OPTIONAL", "type": "TYPE_INT64"},
+			{"name": "nanos", "number": 2, "label": "LABEL_OPTIONAL", "type": "TYPE_INT32"}
+		]}]}`)
+
+	var reflectionRequests []string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.ProtoMajor != 2 || r.Header.Get("Content-Type") != "application/grpc" || r.Header.Get("TE") != "trailers" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+
+		switch r.URL.Path {
+		case "/grpc.reflection.v1.ServerReflection/ServerReflectionInfo":
+			// Only the older v1alpha service is available
+			w.Header().Set("Grpc-Status", "12")
+		case "/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo":
+			request, _ := decodeProto(builtins.Messages["grpc.reflection.v1.ServerReflectionRequest"], body[5:])
+			encoded, _ := json.Marshal(request)
+			reflectionRequests = append(reflectionRequests, string(encoded))
+
+			file := shop
+			if request.(map[string]interface{})["fileByFilename"] == "google/protobuf/timestamp.proto" {
+				file = timestamp
+			}
+			response := encodeTestProto(t, builtins.Messages["grpc.reflection.v1.ServerReflectionResponse"],
+				`{"fileDescriptorResponse": {"fileDescriptorProto": ["`+base64.StdEncoding.EncodeToString(file)+`"]}}`)
+			w.Write(grpcFrame(0, response))
+			w.Header().Set("Grpc-Status", "0")
+		case "/shop.v1.Orders/GetOrder":
+			if !bytes.Equal(body, grpcFrame(0, []byte{0x08, 0x07})) {
+				w.Header().Set("Grpc-Status", "3")
+				w.Header().Set("Grpc-Message", "bad request")
+				return
+			}
+			w.Write(grpcFrame(0, testOrderMessage()))
+			w.Header().Set("Grpc-Status", "0")
+		default:
+			w.Header().Set("Grpc-Status", "12")
+		}
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	oldTransport := grpcTLSTransport
+	grpcTLSTransport = server.Client().Transport
+	defer func() { grpcTLSTransport = oldTransport }()
+
+	content := "GRPC " + server.URL + "/shop.v1.Orders/GetOrder\n\n```json\n{\"id\": 7}\n```\n\nAssert:\n- gRPC status is OK\n- Field `title` equals `\"Widget\"`\n- Field `labels.color` equals `\"red\"`\n- Field `createdAt` equals `\"2023-11-14T22:13:20Z\"`\n"
+	test := parseTestBlock("Reflected", content, Defaults{Headers: map[string]string{}}, "")
+	if _, err := runTest(test, nil); err != nil {
+		t.Fatalf("expected gRPC test with reflection to pass, got %v", err)
+	}
+	expected := []string{`{"fileContainingSymbol":"shop.v1.Orders","host":""}`, `{"fileByFilename":"google/protobuf/timestamp.proto","host":""}`}
+	if !reflect.DeepEqual(reflectionRequests, expected) {
+		t.Errorf("unexpected reflection requests %v", reflectionRequests)
+	}
+
+	// The reflected schema is reused
+	if _, err := runTest(test, nil); err != nil || len(reflectionRequests) != 2 {
+		t.Errorf("expected cached schema to be reused, got %v after %d reflection requests", err, len(reflectionRequests))
+	}
+}
+
+func TestParseWebSocketSteps(t *testing.T) {
+	content := "WS /notifications\n- Authorization: Bearer {{token}}\n\nExpect:\n- Field `type` equals `\"welcome\"`\n\nSave:\n- Field `session.id` as `session_id`\n\nSend:\n\n```json\n{\"type\": \"subscribe\", \"session\": \"{{session_id}}\"}\n```\n\nExpect within 250ms:\n- Field `type` equals `\"subscribed\"`\n- Body includes text `orders`\n\nSend: ping\n\nExpect:\n"
+	test := parseTestBlock("Notifications", content, Defaults{Root: "wss://api.example.com", Headers: map[string]string{}}, "")
+
+	if test.Method != "WS" || test.URL != "wss://api.example.com/notifications" || test.Headers["Authorization"] != "Bearer {{token}}" {
+		t.Fatalf("unexpected WS request %s %s %v", test.Method, test.URL, test.Headers)
+	}
+	expected := []WebSocketStep{
+		{Action: "expect", Assertions: []Assertion{{Type: "field_equals", Field: "type", Value: `"welcome"`}}, SaveFields: []SaveField{{Field: "session.id", Variable: "session_id"}}},
+		{Action: "send", Message: `{"type": "subscribe", "session": "{{session_id}}"}`},
+		{Action: "expect", Timeout: 250 * time.Millisecond, Assertions: []Assertion{{Type: "field_equals", Field: "type", Value: `"subscribed"`}, {Type: "body_includes_text", Value: "orders"}}},
+		{Action: "send", Message: "ping"},
+		{Action: "expect"},
+	}
+	if !reflect.DeepEqual(test.Steps, expected) {
+		t.Errorf("unexpected steps\n got: %+v\nwant: %+v", test.Steps, expected)
+	}
+	if len(test.Assertions) != 0 || test.Body != "" {
+		t.Errorf("expected no body or assertions for a WS test, got %q %+v", test.Body, test.Assertions)
+	}
+
+	// Values saved from earlier messages aren't needed from other tests
+	if vars := testVariables(test); !reflect.DeepEqual(vars, []string{"token"}) {
+		t.Errorf("expected only token to be needed, got %v", vars)
+	}
+	if len(test.SaveFields) != 1 || test.SaveFields[0].Variable != "session_id" {
+		t.Errorf("expected step saves on the test, got %+v", test.SaveFields)
+	}
+
+	// Absolute ws:// URLs aren't joined to the root
+	test = parseTestBlock("Absolute", "WS ws://localhost:8080/live\n", Defaults{Root: "https://api.example.com", Headers: map[string]string{}}, "")
+	if test.URL != "ws://localhost:8080/live" {
+		t.Errorf("expected absolute WebSocket URL, got %s", test.URL)
+	}
+}
+
+// readTestFrame reads a masked client frame on the server side of a test connection
+func readTestFrame(r *bufio.Reader) (byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	length := int(header[1] & 0x7F)
+	if length == 126 {
+		ext := make([]byte, 2)
+		io.ReadFull(r, ext)
+		length = int(ext[0])<<8 | int(ext[1])
+	}
+	mask := make([]byte, 4)
+	io.ReadFull(r, mask)
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return header[0] & 0x0F, payload, nil
+}
+
+// webSocketTestServer accepts WebSocket connections, greets each one, answers
+// subscribe and ping messages, and records what it receives
+func webSocketTestServer(t *testing.T, received *[]string, mu *sync.Mutex) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" || r.Header.Get("Authorization") != "Bearer abc" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		accept := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(accept[:]) + "\r\n\r\n")
+		send := func(opcode byte, message string) {
+			rw.Write(append([]byte{0x80 | opcode, byte(len(message))}, message...))
+			rw.Flush()
+		}
+		record := func(message string) {
+			mu.Lock()
+			*received = append(*received, message)
+			mu.Unlock()
+		}
+
+		send(0x1, `{"type": "welcome", "session": {"id": "s-1"}}`)
+		for {
+			opcode, payload, err := readTestFrame(rw.Reader)
+			if err != nil {
+				return
+			}
+			switch opcode {
+			case 0x8:
+				record("close")
+				send(0x8, string(payload))
+				return
+			case 0xA:
+				record("pong " + string(payload))
+				send(0x1, "pong")
+			case 0x1:
+				record(string(payload))
+				var msg map[string]string
+				json.Unmarshal(payload, &msg)
+				switch {
+				case msg["type"] == "subscribe":
+					// A heartbeat first, which the expectation should skip
+					send(0x1, `{"type": "heartbeat"}`)
+					send(0x1, `{"type": "subscribed", "channel": "`+msg["channel"]+`"}`)
+				case string(payload) == "ping":
+					// Check the client answers pings before replying
+					send(0x9, "hb")
+				case string(payload) == "bye":
+					send(0x8, "\x03\xe8")
+				}
+			}
+		}
+	}))
+}
+
+func TestWebSocketSteps(t *testing.T) {
+	var received []string
+	var mu sync.Mutex
+	server := webSocketTestServer(t, &received, &mu)
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	content := "WS " + wsURL + "/notifications\n- Authorization: Bearer {{token}}\n\nExpect:\n- Field `type` equals `\"welcome\"`\n\nSave:\n- Field `session.id` as `session_id`\n\nSend:\n```json\n{\"type\": \"subscribe\", \"channel\": \"orders-{{session_id}}\"}\n```\n\nExpect within 2s:\n- Field `type` equals `\"subscribed\"`\n- Field `channel` equals `\"orders-s-1\"`\n\nSend: ping\n\nExpect:\n- Body includes text `pong`\n"
+	test := parseTestBlock("Notifications", content, Defaults{Headers: map[string]string{}}, "")
+
+	vars, err := runTest(test, map[string]interface{}{"token": "abc"})
+	if err != nil {
+		t.Fatalf("expected WebSocket test to pass, got %v", err)
+	}
+	if vars["session_id"] != "s-1" {
+		t.Errorf("expected session_id to be saved, got %v", vars["session_id"])
+	}
+	// Wait for the close frame to arrive
+	for i := 0; i < 100; i++ {
+		mu.Lock()
+		n := len(received)
+		mu.Unlock()
+		if n >= 4 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mu.Lock()
+	expected := []string{`{"type": "subscribe", "channel": "orders-s-1"}`, "ping", "pong hb", "close"}
+	if !reflect.DeepEqual(received, expected) {
+		t.Errorf("unexpected messages received by server %q", received)
+	}
+	mu.Unlock()
+
+	// An expectation that nothing matches times out, showing why the last message failed
+	test = parseTestBlock("Timeout", "WS "+wsURL+"\n- Authorization: Bearer abc\n\nExpect within 100ms:\n- Field `type` equals `\"goodbye\"`\n", Defaults{Headers: map[string]string{}}, "")
+	start := time.Now()
+	if _, err := runTest(test, nil); err == nil || !strings.Contains(err.Error(), "expect #1 failed: no matching message within 100ms") || !strings.Contains(err.Error(), "welcome") {
+		t.Errorf("expected timeout with last message, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected timeout after 100ms, took %s", elapsed)
+	}
+
+	// The server closing the connection ends the wait early
+	test = parseTestBlock("Closed", "WS "+wsURL+"\n- Authorization: Bearer abc\n\nSend: bye\n\nExpect:\n- Field `type` equals `\"never\"`\n", Defaults{Headers: map[string]string{}}, "")
+	if _, err := runTest(test, nil); err == nil || !strings.Contains(err.Error(), "connection closed by server (code 1000)") {
+		t.Errorf("expected close to fail the expectation, got %v", err)
+	}
+
+	// A failed handshake shows the response
+	test = parseTestBlock("Unauthorized", "WS "+wsURL+"\n\nExpect:\n", Defaults{Headers: map[string]string{}}, "")
+	if _, err := runTest(test, nil); err == nil || !strings.Contains(err.Error(), "expected status 101, got 401") || !strings.Contains(err.Error(), "unauthorized") {
+		t.Errorf("expected handshake failure, got %v", err)
+	}
+}
+
+func TestParseEventStreamTests(t *testing.T) {
+	content := "POST /v1/completions\n- Read until event `done`\n- Read 10 events\n- Read events for 5s\n\nAssert:\n- Event count is 3\n- Event 1 type is `start`\n- Event `delta` data includes text `Hel`\n- Event last id is `3`\n- Event 2 data is `{}`\n- Event `done` data field `status` equals `\"complete\"`\n"
+	test := parseTestBlock("Stream", content, Defaults{Root: "https://api.example.com", Headers: map[string]string{}}, "")
+
+	if test.EventUntil != "done" || test.EventCount != 10 || test.EventTimeout != 5*time.Second {
+		t.Errorf("unexpected read options %q %d %s", test.EventUntil, test.EventCount, test.EventTimeout)
+	}
+	expected := []Assertion{
+		{Type: "event_count", Value: "3"},
+		{Type: "event_type", Event: "1", Value: "start"},
+		{Type: "event_data_includes", Event: "delta", Value: "Hel"},
+		{Type: "event_id", Event: "last", Value: "3"},
+		{Type: "event_data", Event: "2", Value: "{}"},
+		{Type: "event_field", Event: "done", Field: "status", Value: `"complete"`},
+	}
+	if !reflect.DeepEqual(test.Assertions, expected) {
+		t.Errorf("unexpected assertions\n got: %+v\nwant: %+v", test.Assertions, expected)
+	}
+}
+
+func TestEventStreamResponses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+		flusher := w.(http.Flusher)
+		fmt.Fprint(w, ": keep-alive\n\nevent: start\nid: 1\ndata: {}\n\n")
+		fmt.Fprint(w, "data: Hello\r\ndata: world\r\n\r\n")
+		fmt.Fprint(w, "event: done\nid: 3\ndata: {\"status\": \"complete\", \"usage\": {\"tokens\": 7}}\n\n")
+		flusher.Flush()
+		if r.URL.Path == "/ends" {
+			fmt.Fprint(w, "data: [DONE]")
+			return
+		}
+		// Keep the connection open, like a stream that never ends
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	// Reading stops at the event the test waits for, though the stream stays open
+	content := "GET " + server.URL + "/open\n- Read until event `done`\n\nAssert:\n- Status is 200\n- Event count is 3\n- Event 1 type is `start`\n- Event 2 type is `message`\n- Event 2 data includes text `Hello`\n- Event `done` data field `status` equals `\"complete\"`\n- Event last data field `usage.tokens` equals `7`\n- Event last id is `3`\n- Body includes text `keep-alive`\n"
+	test := parseTestBlock("Until", content, Defaults{Headers: map[string]string{}}, "")
+	start := time.Now()
+	if _, err := runTest(test, nil); err != nil {
+		t.Errorf("expected event stream test to pass, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected reading to stop at the done event, took %s", elapsed)
+	}
+
+	// A count works the same way
+	test = parseTestBlock("Count", "GET "+server.URL+"/open\n- Read 2 events\n\nAssert:\n- Event count is 2\n- Event 2 data includes text `world`\n", Defaults{Headers: map[string]string{}}, "")
+	if _, err := runTest(test, nil); err != nil {
+		t.Errorf("expected count test to pass, got %v", err)
+	}
+
+	// Without a stop condition, the whole stream is read, including a final event without a blank line
+	test = parseTestBlock("Ends", "GET "+server.URL+"/ends\n\nAssert:\n- Event count is 4\n- Event last data is `[DONE]`\n", Defaults{Headers: map[string]string{}}, "")
+	if _, err := runTest(test, nil); err != nil {
+		t.Errorf("expected full stream test to pass, got %v", err)
+	}
+
+	// An event that never arrives times out
+	test = parseTestBlock("Timeout", "GET "+server.URL+"/open\n- Read until event `error`\n- Read events for 100ms\n", Defaults{Headers: map[string]string{}}, "")
+	start = time.Now()
+	if _, err := runTest(test, nil); err == nil || !strings.Contains(err.Error(), "timed out after 100ms waiting for event `error` (got 3 events)") {
+		t.Errorf("expected timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected timeout after 100ms, took %s", elapsed)
+	}
+
+	// A stream that ends first fails too
+	test = parseTestBlock("Short", "GET "+server.URL+"/ends\n- Read 10 events\n", Defaults{Headers: map[string]string{}}, "")
+	if _, err := runTest(test, nil); err == nil || !strings.Contains(err.Error(), "event stream ended after 4 events without 10 events") {
+		t.Errorf("expected short stream to fail, got %v", err)
+	}
+
+	// Failing event assertions
+	failures := map[string]string{
+		"Event 1 type is `update`":                         "event 1 type assertion failed: expected `update`, got `start`",
+		"Event 9 data is `x`":                              "no event 9 (got 3 events)",
+		"Event `update` id is `1`":                         "no `update` event (got 3 events)",
+		"Event `done` data field `status` equals `\"ok\"`": "event done: field equals assertion failed",
+		"Event 2 data field `status` equals `\"ok\"`":      "event 2 data is not a JSON object",
+		"Event count is 5":                                 "event count assertion failed: expected 5, got 3",
+	}
+	for assertion, message := range failures {
+		test = parseTestBlock("Failure", "GET "+server.URL+"/open\n- Read until event `done`\n\nAssert:\n- "+assertion+"\n", Defaults{Headers: map[string]string{}}, "")
+		if _, err := runTest(test, nil); err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("expected %q to fail with %q, got %v", assertion, message, err)
+		}
+	}
+}
+
+func TestParseFrontmatterAuth(t *testing.T) {
+	content := "---\nroot: https://api.example.com\nauth:\n  type: OAuth2\n  grant: password\n  token_url: https://auth.example.com/token\n  client_id: ${CLIENT_ID}\n  client_secret: ${CLIENT_SECRET}\n  username: alice\n  password: ${PASSWORD}\n  scope: read write\nheaders:\n  Accept: application/json\n---\n\n## Get orders\n\nGET /orders\n"
+	tf := parseTestFile(content, "")
+
+	expected := &AuthConfig{Type: "oauth2", Grant: "password", TokenURL: "https://auth.example.com/token", ClientID: "${CLIENT_ID}", ClientSecret: "${CLIENT_SECRET}", Username: "alice", Password: "${PASSWORD}", Scope: "read write"}
+	if len(tf.Tests) != 1 || !reflect.DeepEqual(tf.Tests[0].Auth, expected) {
+		t.Fatalf("unexpected auth %+v", tf.Tests)
+	}
+	if tf.Tests[0].Headers["Accept"] != "application/json" {
+		t.Errorf("headers after auth should still be parsed, got %v", tf.Tests[0].Headers)
+	}
+}
+
+func TestBasicAndBearerAuth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer server.Close()
+
+	t.Setenv("MARCUS_TEST_TOKEN", "secret-token")
+	t.Setenv("MARCUS_TEST_PASSWORD", "hunter2")
+	tests := []struct {
+		name     string
+		auth     *AuthConfig
+		headers  map[string]string
+		expected string
+	}{
+		{"bearer", &AuthConfig{Type: "bearer", Token: "${MARCUS_TEST_TOKEN}"}, nil, "Bearer secret-token"},
+		{"basic", &AuthConfig{Type: "basic", Username: "admin", Password: "${MARCUS_TEST_PASSWORD}"}, nil, "Basic YWRtaW46aHVudGVyMg=="},
+		{"own header", &AuthConfig{Type: "bearer", Token: "${MARCUS_TEST_TOKEN}"}, map[string]string{"Authorization": "Bearer bad"}, "Bearer bad"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			test := Test{Method: "GET", URL: server.URL, Headers: tt.headers, Auth: tt.auth, Assertions: []Assertion{{Type: "body_includes_text", Value: tt.expected}}}
+			if _, err := runTest(test, nil); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+
+	// A missing environment variable fails instead of sending empty credentials
+	test := Test{Method: "GET", URL: server.URL, Auth: &AuthConfig{Type: "bearer", Token: "${MARCUS_TEST_MISSING}"}}
+	if _, err := runTest(test, nil); err == nil || !strings.Contains(err.Error(), "environment variable MARCUS_TEST_MISSING is not set") {
+		t.Errorf("expected missing variable error, got %v", err)
+	}
+}
+
+func TestOAuth2Auth(t *testing.T) {
+	var mu sync.Mutex
+	var fetches int
+	var forms []string
+	valid := ""
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		id, secret, _ := r.BasicAuth()
+		mu.Lock()
+		defer mu.Unlock()
+		if id != "client" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": "invalid_client"}`))
+			return
+		}
+		fetches++
+		forms = append(forms, r.PostForm.Encode())
+		valid = fmt.Sprintf("token-%d", fetches)
+		expiresIn := "3600"
+		if r.PostForm.Get("scope") == "short" {
+			expiresIn = "5" // Inside the expiry margin, so it's never reused
+		}
+		w.Write([]byte(`{"access_token": "` + valid + `", "token_type": "Bearer", "expires_in": ` + expiresIn + `}`))
+	}))
+	defer tokenServer.Close()
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer "+valid {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer api.Close()
+
+	t.Setenv("MARCUS_TEST_CLIENT_SECRET", "s3cret")
+	auth := &AuthConfig{Type: "oauth2", TokenURL: tokenServer.URL, ClientID: "client", ClientSecret: "${MARCUS_TEST_CLIENT_SECRET}", Scope: "orders"}
+	run := func(auth *AuthConfig, expected string) {
+		t.Helper()
+		test := Test{Method: "GET", URL: api.URL, Auth: auth, Assertions: []Assertion{{Type: "status", Value: "200"}, {Type: "body_includes_text", Value: expected}}}
+		if _, err := runTest(test, nil); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// The token is fetched once and shared
+	run(auth, "Bearer token-1")
+	run(auth, "Bearer token-1")
+	if fetches != 1 || forms[0] != "grant_type=client_credentials&scope=orders" {
+		t.Errorf("expected one client credentials fetch, got %d %v", fetches, forms)
+	}
+
+	// A revoked token is replaced after a 401
+	mu.Lock()
+	valid = "revoked"
+	mu.Unlock()
+	run(auth, "Bearer token-2")
+	if fetches != 2 {
+		t.Errorf("expected a new token after a 401, got %d fetches", fetches)
+	}
+
+	// An expiring token is fetched again
+	short := &AuthConfig{Type: "oauth2", TokenURL: tokenServer.URL, ClientID: "client", ClientSecret: "s3cret", Scope: "short"}
+	run(short, "Bearer token-3")
+	run(short, "Bearer token-4")
+
+	// The password grant sends the user's credentials
+	password := &AuthConfig{Type: "oauth2", Grant: "password", TokenURL: tokenServer.URL, ClientID: "client", ClientSecret: "s3cret", Username: "alice", Password: "pw"}
+	run(password, "Bearer token-5")
+	if forms[4] != "grant_type=password&password=pw&username=alice" {
+		t.Errorf("unexpected password grant form %s", forms[4])
+	}
+
+	// A failed token request shows the response
+	bad := &AuthConfig{Type: "oauth2", TokenURL: tokenServer.URL, ClientID: "client", ClientSecret: "wrong"}
+	test := Test{Method: "GET", URL: api.URL, Auth: bad}
+	if _, err := runTest(test, nil); err == nil || !strings.Contains(err.Error(), "oauth2 token request failed: status 401") || !strings.Contains(err.Error(), "invalid_client") {
+		t.Errorf("expected token request failure, got %v", err)
+	}
+}
+
+func TestParseFrontmatterSigning(t *testing.T) {
+	content := "---\nsigning:\n  type: HMAC\n  secret: ${PARTNER_SECRET}\n  header: X-Partner-Signature\n  canonical: {method}\\n{path}\\n{body_sha256}\n  prefix: sha256=\n  encoding: base64\n---\n\n## Get orders\n\nGET https://api.example.com/orders\n"
+	tf := parseTestFile(content, "")
+
+	expected := &SigningConfig{Type: "hmac", Secret: "${PARTNER_SECRET}", Header: "X-Partner-Signature", Canonical: "{method}\n{path}\n{body_sha256}", Prefix: "sha256=", Encoding: "base64"}
+	if len(tf.Tests) != 1 || !reflect.DeepEqual(tf.Tests[0].Signing, expected) {
+		t.Fatalf("unexpected signing %+v", tf.Tests)
+	}
+}
+
+func TestHMACSigning(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	sign := func(config SigningConfig, req *http.Request, body string) {
+		t.Helper()
+		signer, err := newHMACSigner(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := signer.Sign(req, []byte(body), now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectedMAC := func(canonical string) []byte {
+		mac := hmac.New(sha256.New, []byte("key"))
+		mac.Write([]byte(canonical))
+		return mac.Sum(nil)
+	}
+
+	// The default canonical string is the method, path, timestamp and body
+	req := httptest.NewRequest("POST", "https://api.example.com/orders?page=2", nil)
+	sign(SigningConfig{Secret: "key"}, req, `{"id": 1}`)
+	if req.Header.Get("X-Timestamp") != "1700000000" {
+		t.Errorf("expected timestamp header, got %q", req.Header.Get("X-Timestamp"))
+	}
+	if expected := hex.EncodeToString(expectedMAC("POST\n/orders\n1700000000\n{\"id\": 1}")); req.Header.Get("X-Signature") != expected {
+		t.Errorf("expected signature %s, got %s", expected, req.Header.Get("X-Signature"))
+	}
+
+	// Custom canonical strings, headers, prefixes and encodings
+	req = httptest.NewRequest("GET", "https://api.example.com/orders?page=2", nil)
+	req.Header.Set("X-Nonce", "abc")
+	sign(SigningConfig{Secret: "key", Header: "X-Hub-Signature", Canonical: "{query}|{header:X-Nonce}|{body_sha256}", Prefix: "sha256=", Encoding: "base64"}, req, "")
+	expected := "sha256=" + base64.StdEncoding.EncodeToString(expectedMAC("page=2|abc|e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"))
+	if req.Header.Get("X-Hub-Signature") != expected || req.Header.Get("X-Timestamp") != "" {
+		t.Errorf("expected signature %s and no timestamp, got %v", expected, req.Header)
+	}
+
+	signer, _ := newHMACSigner(SigningConfig{Secret: "key", Canonical: "{method}{nonce}"})
+	if err := signer.Sign(req, nil, now); err == nil || !strings.Contains(err.Error(), "unknown placeholder {nonce}") {
+		t.Errorf("expected unknown placeholder error, got %v", err)
+	}
+}
+
+func TestSigV4Signing(t *testing.T) {
+	// Requests from the AWS Signature Version 4 test suite
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	config := SigningConfig{AccessKey: "AKIDEXAMPLE", SecretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", Region: "us-east-1", Service: "service"}
+	tests := []struct {
+		url       string
+		signature string
+	}{
+		{"https://example.amazonaws.com/", "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"https://example.amazonaws.com/?Param2=value2&Param1=value1", "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500"},
+	}
+	for _, tt := range tests {
+		signer, err := newSigV4Signer(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, _ := http.NewRequest("GET", tt.url, nil)
+		if err := signer.Sign(req, nil, now); err != nil {
+			t.Fatal(err)
+		}
+		expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=" + tt.signature
+		if req.Header.Get("Authorization") != expected || req.Header.Get("X-Amz-Date") != "20150830T123600Z" {
+			t.Errorf("%s: unexpected signature\n got: %s\nwant: %s", tt.url, req.Header.Get("Authorization"), expected)
+		}
+	}
+
+	// Credentials and region fall back to the AWS environment variables
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	if _, err := newSigV4Signer(SigningConfig{Region: "us-east-1", Service: "execute-api"}); err == nil || !strings.Contains(err.Error(), "needs an access_key") {
+		t.Errorf("expected missing credentials error, got %v", err)
+	}
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "session")
+	t.Setenv("AWS_REGION", "eu-west-1")
+	signer, err := newSigV4Signer(SigningConfig{Service: "execute-api"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "https://api.example.com/orders", nil)
+	signer.Sign(req, nil, now)
+	if auth := req.Header.Get("Authorization"); !strings.Contains(auth, "Credential=AKID/20150830/eu-west-1/execute-api/aws4_request") || !strings.Contains(auth, "SignedHeaders=host;x-amz-date;x-amz-security-token") {
+		t.Errorf("expected environment credentials, got %s", auth)
+	}
+}
+
+func TestSignedRequests(t *testing.T) {
+	var signatures []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte("s3cret"))
+		mac.Write([]byte(r.Method + "\n" + r.URL.Path + "\n" + r.Header.Get("X-Timestamp") + "\n" + string(body)))
+		signatures = append(signatures, r.Header.Get("X-Signature"))
+		if r.Header.Get("X-Signature") != hex.EncodeToString(mac.Sum(nil)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	t.Setenv("MARCUS_TEST_SIGNING_SECRET", "s3cret")
+	content := "---\nroot: " + server.URL + "\nsigning:\n  type: hmac\n  secret: ${MARCUS_TEST_SIGNING_SECRET}\n---\n\n## Create order\n\nPOST /orders\n\n```json\n{\"id\": \"{{id}}\"}\n```\n\nAssert:\n- Status is 200\n"
+	tf := parseTestFile(content, "")
+	if _, err := runTest(tf.Tests[0], map[string]interface{}{"id": 7}); err != nil {
+		t.Errorf("expected signed request to pass, got %v", err)
+	}
+	if len(signatures) != 1 || signatures[0] == "" {
+		t.Errorf("expected one signed request, got %v", signatures)
+	}
+
+	// An unknown signer fails before sending
+	test := tf.Tests[0]
+	test.Signing = &SigningConfig{Type: "rsa"}
+	if _, err := runTest(test, nil); err == nil || !strings.Contains(err.Error(), `signing failed: unknown type "rsa"`) {
+		t.Errorf("expected unknown type error, got %v", err)
+	}
+}
+
+func TestParseFrontmatterTLS(t *testing.T) {
+	content := "---\ntls:\n  ca_cert: certs/ca.pem\n  client_cert: /etc/certs/client.pem\n  client_key: certs/client-key.pem\n  insecure_skip_verify: true\n---\n\n## Get orders\n\nGET https://api.example.com/orders\n\nAssert:\n- TLS version is 1.3\n- Certificate expires in more than 30 days\n"
+	tf := parseTestFile(content, "/tests")
+
+	expected := &TLSConfig{CACert: "/tests/certs/ca.pem", ClientCert: "/etc/certs/client.pem", ClientKey: "/tests/certs/client-key.pem", InsecureSkipVerify: true, InsecureSet: true}
+	if len(tf.Tests) != 1 || !reflect.DeepEqual(tf.Tests[0].TLS, expected) {
+		t.Fatalf("unexpected TLS settings %+v", tf.Tests)
+	}
+	assertions := []Assertion{{Type: "tls_version", Value: "1.3"}, {Type: "cert_expiry", Value: "30"}}
+	if !reflect.DeepEqual(tf.Tests[0].Assertions, assertions) {
+		t.Errorf("unexpected assertions %+v", tf.Tests[0].Assertions)
+	}
+}
+
+// writeClientCert writes a self-signed client certificate and its key to dir
+func writeClientCert(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "marcus"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	certPath, keyPath := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return cert, certPath, keyPath
+}
+
+func TestTLSOptions(t *testing.T) {
+	dir := t.TempDir()
+	clientCert, certPath, keyPath := writeClientCert(t, dir)
+
+	// The server only accepts the client certificate written above
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	caPath := filepath.Join(dir, "ca.pem")
+	os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644)
+
+	run := func(frontmatter, assertions string) error {
+		content := "---\n" + frontmatter + "---\n\n## Get\n\nGET " + server.URL + "\n\nAssert:\n- Status is 200\n- Body includes text `marcus`\n" + assertions
+		tf := parseTestFile(content, dir)
+		_, err := runTest(tf.Tests[0], nil)
+		return err
+	}
+
+	// The server's certificate isn't trusted without the CA
+	if err := run("tls:\n  client_cert: client.pem\n  client_key: client-key.pem\n", ""); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Errorf("expected an untrusted certificate error, got %v", err)
+	}
+
+	// With the CA and a client certificate, paths relative to the test file
+	if err := run("tls:\n  ca_cert: ca.pem\n  client_cert: client.pem\n  client_key: client-key.pem\n", "- TLS version is 1.3\n- Certificate expires in more than 30 days\n"); err != nil {
+		t.Errorf("expected mutual TLS to work, got %v", err)
+	}
+
+	// Skipping verification works instead of the CA
+	if err := run("tls:\n  insecure_skip_verify: true\n  client_cert: "+certPath+"\n  client_key: "+keyPath+"\n", ""); err != nil {
+		t.Errorf("expected insecure mode to work, got %v", err)
+	}
+
+	// Failing TLS assertions
+	failures := map[string]string{
+		"- TLS version is 1.2\n":                           "TLS version assertion failed: expected 1.2, got 1.3",
+		"- Certificate expires in more than 100000 days\n": "certificate expiry assertion failed: expected to expire in more than 100000 days",
+	}
+	for assertion, message := range failures {
+		if err := run("tls:\n  ca_cert: ca.pem\n  client_cert: client.pem\n  client_key: client-key.pem\n", assertion); err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("expected %q to fail with %q, got %v", assertion, message, err)
+		}
+	}
+
+	// Command line options apply to files without a tls: block
+	oldTLS := cliTLS
+	defer func() { cliTLS = oldTLS }()
+	for _, arg := range []string{"--ca-cert=" + caPath, "--client-cert=" + certPath, "--client-key=" + keyPath} {
+		if !parseTLSFlag(arg) {
+			t.Fatalf("expected %s to be a TLS option", arg)
+		}
+	}
+	if err := run("root: "+server.URL+"\n", ""); err != nil {
+		t.Errorf("expected command line TLS options to work, got %v", err)
+	}
+	// A file's insecure_skip_verify overrides --insecure, either way
+	cliTLS = TLSConfig{InsecureSkipVerify: true}
+	if err := run("root: "+server.URL+"\ntls:\n  client_cert: "+certPath+"\n  client_key: "+keyPath+"\n  insecure_skip_verify: false\n", ""); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Errorf("expected insecure_skip_verify: false to verify the server certificate, got %v", err)
+	}
+	if err := run("root: "+server.URL+"\ntls:\n  client_cert: "+certPath+"\n  client_key: "+keyPath+"\n", ""); err != nil {
+		t.Errorf("expected --insecure to apply when the file doesn't set insecure_skip_verify, got %v", err)
+	}
+	cliTLS = TLSConfig{ClientCert: certPath}
+	if err := run("root: "+server.URL+"\n", ""); err == nil || !strings.Contains(err.Error(), "needs both client_cert and client_key") {
+		t.Errorf("expected missing key error, got %v", err)
+	}
+	cliTLS = oldTLS
+
+	// TLS assertions need a TLS connection
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer plain.Close()
+	test := Test{Method: "GET", URL: plain.URL, Assertions: []Assertion{{Type: "tls_version", Value: "1.3"}}}
+	if _, err := runTest(test, nil); err == nil || !strings.Contains(err.Error(), "didn't come over TLS") {
+		t.Errorf("expected TLS assertion to fail over plain HTTP, got %v", err)
+	}
+}
@@ -0,0 +1,666 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// markupNode is a node in a parsed XML or HTML document
+type markupNode struct {
+	Kind     int    // documentNode, elementNode, textNode or attrNode
+	Name     string // element or attribute name, without namespace prefix
+	Attrs    []xml.Attr
+	Text     string // for text and attribute nodes
+	Parent   *markupNode
+	Children []*markupNode
+}
+
+const (
+	documentNode = iota
+	elementNode
+	textNode
+	attrNode
+)
+
+var (
+	htmlScriptPattern = regexp.MustCompile(`(?is)<script\b[^>]*>.*?</script\s*>`)
+	htmlStylePattern  = regexp.MustCompile(`(?is)<style\b[^>]*>.*?</style\s*>`)
+)
+
+// parseMarkup parses an XML document, or an HTML document if html is set. HTML is
+// parsed leniently: void elements and elements with optional end tags (li, p, td, ...)
+// are closed automatically, HTML entities are understood, and parsing stops quietly
+// at anything it can't read.
+func parseMarkup(body []byte, html bool) (*markupNode, error) {
+	if html {
+		// Scripts and styles aren't markup and often contain "<" or "&", so drop their contents
+		body = htmlScriptPattern.ReplaceAll(body, []byte("<script></script>"))
+		body = htmlStylePattern.ReplaceAll(body, []byte("<style></style>"))
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	// Declared encodings are read as-is; responses are almost always UTF-8
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	if html {
+		decoder.Strict = false
+		decoder.AutoClose = xml.HTMLAutoClose
+		decoder.Entity = xml.HTMLEntity
+	}
+
+	root := &markupNode{Kind: documentNode}
+	current := root
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if html {
+				break
+			}
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if html {
+				current = closeImpliedElements(current, t.Name.Local)
+			}
+			node := &markupNode{Kind: elementNode, Name: t.Name.Local, Parent: current}
+			for _, attr := range t.Attr {
+				// Namespace declarations aren't attributes of the element
+				if attr.Name.Space == "xmlns" || (attr.Name.Space == "" && attr.Name.Local == "xmlns") {
+					continue
+				}
+				node.Attrs = append(node.Attrs, xml.Attr{Name: xml.Name{Local: attr.Name.Local}, Value: attr.Value})
+			}
+			current.Children = append(current.Children, node)
+			current = node
+		case xml.EndElement:
+			// Close the nearest open element with this name, along with anything left open inside it
+			for node := current; node.Parent != nil; node = node.Parent {
+				if strings.EqualFold(node.Name, t.Name.Local) {
+					current = node.Parent
+					break
+				}
+			}
+		case xml.CharData:
+			current.Children = append(current.Children, &markupNode{Kind: textNode, Text: string(t), Parent: current})
+		}
+	}
+
+	for _, child := range root.Children {
+		if child.Kind == elementNode {
+			return root, nil
+		}
+	}
+	return nil, fmt.Errorf("no elements found")
+}
+
+// htmlImpliedEnd lists HTML elements whose end tag may be left out, and the start
+// tags that close them when one is still open, e.g. a new <li> closes the last <li>
+var htmlImpliedEnd = map[string][]string{
+	"li":     {"li"},
+	"dt":     {"dt", "dd"},
+	"dd":     {"dt", "dd"},
+	"tr":     {"tr"},
+	"td":     {"td", "th", "tr"},
+	"th":     {"td", "th", "tr"},
+	"option": {"option", "optgroup"},
+	"p":      {"p", "div", "ul", "ol", "dl", "table", "form", "h1", "h2", "h3", "h4", "h5", "h6", "pre", "blockquote", "section", "article", "header", "footer", "nav"},
+}
+
+// closeImpliedElements returns the element a new HTML start tag should be added to,
+// closing the current element first if the start tag implies its end
+func closeImpliedElements(current *markupNode, tag string) *markupNode {
+	for current.Kind == elementNode {
+		closedBy := htmlImpliedEnd[strings.ToLower(current.Name)]
+		implied := false
+		for _, name := range closedBy {
+			if strings.EqualFold(name, tag) {
+				implied = true
+				break
+			}
+		}
+		if !implied {
+			break
+		}
+		current = current.Parent
+	}
+	return current
+}
+
+// htmlBreaks are the elements that separate the text around them, like a line break
+var htmlBreaks = map[string]bool{
+	"br": true, "p": true, "div": true, "li": true, "tr": true, "td": true, "th": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+}
+
+// attr returns the value of an element's attribute
+func (n *markupNode) attr(name string) (string, bool) {
+	for _, attr := range n.Attrs {
+		if strings.EqualFold(attr.Name.Local, name) {
+			return attr.Value, true
+		}
+	}
+	return "", false
+}
+
+// textContent returns the text of a node with whitespace collapsed. For elements it
+// is the text of every descendant, with line breaks and block elements read as spaces.
+func (n *markupNode) textContent() string {
+	if n.Kind == textNode || n.Kind == attrNode {
+		return strings.Join(strings.Fields(n.Text), " ")
+	}
+
+	var b strings.Builder
+	var walk func(*markupNode)
+	walk = func(node *markupNode) {
+		for _, child := range node.Children {
+			if child.Kind == textNode {
+				b.WriteString(child.Text)
+				continue
+			}
+			breaks := htmlBreaks[strings.ToLower(child.Name)]
+			if breaks {
+				b.WriteString(" ")
+			}
+			walk(child)
+			if breaks {
+				b.WriteString(" ")
+			}
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// descendantsOrSelf returns a node and every node below it, in document order
+func (n *markupNode) descendantsOrSelf() []*markupNode {
+	nodes := []*markupNode{n}
+	for _, child := range n.Children {
+		nodes = append(nodes, child.descendantsOrSelf()...)
+	}
+	return nodes
+}
+
+// xpathStep is one location step of an XPath expression, e.g. "item[@id='1']"
+type xpathStep struct {
+	Descendant bool   // Preceded by "//" rather than "/"
+	Test       string // Element name, "*", "@name", "@*", "text()", "node()", "." or ".."
+	Predicates []string
+}
+
+// parseXPath splits an XPath expression into its location steps
+// Returns whether the path is absolute (starts with "/")
+func parseXPath(expr string) ([]xpathStep, bool, error) {
+	expr = strings.TrimSpace(expr)
+	absolute := strings.HasPrefix(expr, "/")
+
+	var steps []xpathStep
+	descendant := false
+	i := 0
+	for i < len(expr) {
+		if expr[i] == '/' {
+			if strings.HasPrefix(expr[i:], "//") {
+				descendant = true
+				i += 2
+			} else {
+				i++
+			}
+			continue
+		}
+
+		// Read up to the next "/" outside of predicates and quotes
+		start := i
+		depth := 0
+		var quote byte
+		for ; i < len(expr); i++ {
+			c := expr[i]
+			if quote != 0 {
+				if c == quote {
+					quote = 0
+				}
+				continue
+			}
+			if c == '\'' || c == '"' {
+				quote = c
+			} else if c == '[' {
+				depth++
+			} else if c == ']' {
+				depth--
+			} else if c == '/' && depth == 0 {
+				break
+			}
+		}
+		if depth != 0 || quote != 0 {
+			return nil, false, fmt.Errorf("invalid XPath %q: unbalanced brackets or quotes", expr)
+		}
+
+		step, err := parseXPathStep(expr[start:i])
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid XPath %q: %w", expr, err)
+		}
+		step.Descendant = descendant
+		descendant = false
+		steps = append(steps, step)
+	}
+
+	if len(steps) == 0 {
+		return nil, false, fmt.Errorf("invalid XPath %q", expr)
+	}
+	return steps, absolute, nil
+}
+
+// parseXPathStep parses a node test and its predicates, e.g. "item[2][@id]"
+func parseXPathStep(s string) (xpathStep, error) {
+	var step xpathStep
+	bracket := strings.Index(s, "[")
+	if bracket == -1 {
+		step.Test = strings.TrimSpace(s)
+	} else {
+		step.Test = strings.TrimSpace(s[:bracket])
+		rest := s[bracket:]
+		for rest != "" {
+			if rest[0] != '[' {
+				return step, fmt.Errorf("unexpected %q", rest)
+			}
+			depth := 0
+			var quote byte
+			end := -1
+			for i := 0; i < len(rest) && end == -1; i++ {
+				c := rest[i]
+				switch {
+				case quote != 0:
+					if c == quote {
+						quote = 0
+					}
+				case c == '\'' || c == '"':
+					quote = c
+				case c == '[':
+					depth++
+				case c == ']':
+					depth--
+					if depth == 0 {
+						end = i
+					}
+				}
+			}
+			if end == -1 {
+				return step, fmt.Errorf("unclosed predicate")
+			}
+			step.Predicates = append(step.Predicates, strings.TrimSpace(rest[1:end]))
+			rest = strings.TrimSpace(rest[end+1:])
+		}
+	}
+
+	// Namespace prefixes are ignored, so "soap:Body" matches any Body element
+	if i := strings.Index(step.Test, ":"); i != -1 && !strings.HasSuffix(step.Test, "()") {
+		step.Test = step.Test[i+1:]
+	}
+	if step.Test == "" {
+		return step, fmt.Errorf("missing node test")
+	}
+	return step, nil
+}
+
+// evalXPath evaluates an XPath expression against a node
+// Supported: "/" and "//" paths, element names, "*", "@attr", "text()", "node()",
+// ".", "..", and predicates by position ("[2]", "[last()]"), existence ("[@id]",
+// "[name]") and comparison ("[@id='1']", "[name='Ada']", "[contains(text(), 'x')]").
+func evalXPath(context *markupNode, expr string) ([]*markupNode, error) {
+	steps, absolute, err := parseXPath(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	start := context
+	if absolute {
+		for start.Parent != nil {
+			start = start.Parent
+		}
+	}
+
+	nodes := []*markupNode{start}
+	for _, step := range steps {
+		var next []*markupNode
+		seen := make(map[*markupNode]bool)
+		for _, node := range nodes {
+			sources := []*markupNode{node}
+			if step.Descendant {
+				sources = node.descendantsOrSelf()
+			}
+			for _, source := range sources {
+				candidates := xpathCandidates(source, step.Test)
+				for _, predicate := range step.Predicates {
+					candidates, err = applyXPathPredicate(candidates, predicate)
+					if err != nil {
+						return nil, err
+					}
+				}
+				for _, candidate := range candidates {
+					if !seen[candidate] {
+						seen[candidate] = true
+						next = append(next, candidate)
+					}
+				}
+			}
+		}
+		nodes = next
+	}
+	return nodes, nil
+}
+
+// xpathCandidates returns the nodes a node test selects from a context node
+func xpathCandidates(node *markupNode, test string) []*markupNode {
+	switch test {
+	case ".":
+		return []*markupNode{node}
+	case "..":
+		if node.Parent == nil {
+			return nil
+		}
+		return []*markupNode{node.Parent}
+	}
+
+	if strings.HasPrefix(test, "@") {
+		name := strings.TrimPrefix(test, "@")
+		var attrs []*markupNode
+		for _, attr := range node.Attrs {
+			if name == "*" || strings.EqualFold(attr.Name.Local, name) {
+				attrs = append(attrs, &markupNode{Kind: attrNode, Name: attr.Name.Local, Text: attr.Value, Parent: node})
+			}
+		}
+		return attrs
+	}
+
+	var children []*markupNode
+	for _, child := range node.Children {
+		switch {
+		case test == "node()":
+			children = append(children, child)
+		case test == "text()":
+			if child.Kind == textNode {
+				children = append(children, child)
+			}
+		case child.Kind == elementNode && (test == "*" || strings.EqualFold(child.Name, test)):
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+var (
+	xpathComparisonPattern = regexp.MustCompile(`^(.+?)\s*=\s*(?:'([^']*)'|"([^"]*)"|(-?\d+(?:\.\d+)?))$`)
+	xpathContainsPattern   = regexp.MustCompile(`^contains\(\s*(.+?)\s*,\s*(?:'([^']*)'|"([^"]*)")\s*\)$`)
+)
+
+// applyXPathPredicate filters nodes selected by a step with one predicate
+func applyXPathPredicate(nodes []*markupNode, predicate string) ([]*markupNode, error) {
+	if n, err := strconv.Atoi(predicate); err == nil {
+		if n < 1 || n > len(nodes) {
+			return nil, nil
+		}
+		return []*markupNode{nodes[n-1]}, nil
+	}
+	if predicate == "last()" {
+		if len(nodes) == 0 {
+			return nil, nil
+		}
+		return nodes[len(nodes)-1:], nil
+	}
+
+	// keep reports whether any node selected by path from node has a string value accepted by test
+	keep := func(node *markupNode, path string, test func(string) bool) (bool, error) {
+		values, err := evalXPath(node, path)
+		if err != nil {
+			return false, err
+		}
+		for _, value := range values {
+			if test(value.textContent()) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	var test func(string) bool
+	path := predicate
+	if matches := xpathContainsPattern.FindStringSubmatch(predicate); matches != nil {
+		path = matches[1]
+		substr := matches[2] + matches[3]
+		test = func(s string) bool { return strings.Contains(s, substr) }
+	} else if matches := xpathComparisonPattern.FindStringSubmatch(predicate); matches != nil {
+		path = matches[1]
+		expected := matches[2] + matches[3] + matches[4]
+		test = func(s string) bool { return s == expected }
+	} else {
+		test = func(string) bool { return true }
+	}
+
+	var filtered []*markupNode
+	for _, node := range nodes {
+		ok, err := keep(node, path, test)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			filtered = append(filtered, node)
+		}
+	}
+	return filtered, nil
+}
+
+// cssCompound is a compound CSS selector such as "a.button[href]:first-child"
+type cssCompound struct {
+	Combinator byte // How it relates to the previous compound: ' ' (descendant) or '>' (child)
+	Tag        string
+	ID         string
+	Classes    []string
+	Attrs      []cssAttr
+	Pseudo     []string // "first-child" or "last-child"
+}
+
+// cssAttr is an attribute selector such as [type="submit"]
+type cssAttr struct {
+	Name  string
+	Op    string // "" (present), "=", "~=", "^=", "$=" or "*="
+	Value string
+}
+
+// cssIdentPattern matches a CSS identifier (tag, class or id name)
+var cssIdentPattern = regexp.MustCompile(`^-?[A-Za-z_][\w-]*`)
+
+// cssAttrPattern matches the inside of an attribute selector
+var cssAttrPattern = regexp.MustCompile(`^\s*([\w:-]+)\s*(?:([~^$*]?=)\s*(?:"([^"]*)"|'([^']*)'|([^\s\]]+)))?\s*$`)
+
+// parseCSSSelector parses a selector list into groups of compounds
+// Supported: tag, *, #id, .class, [attr], [attr=value] (and ~=, ^=, $=, *=),
+// :first-child, :last-child, descendant and child (>) combinators, and "a, b" lists.
+func parseCSSSelector(selector string) ([][]cssCompound, error) {
+	var groups [][]cssCompound
+	for _, group := range strings.Split(selector, ",") {
+		var compounds []cssCompound
+		s := strings.TrimSpace(group)
+		combinator := byte(' ')
+		for s != "" {
+			if s[0] == ' ' || s[0] == '\t' || s[0] == '\n' {
+				s = strings.TrimLeft(s, " \t\n")
+				continue
+			}
+			if s[0] == '>' {
+				if len(compounds) == 0 {
+					return nil, fmt.Errorf("invalid selector %q", selector)
+				}
+				combinator = '>'
+				s = s[1:]
+				continue
+			}
+			if s[0] == '+' || s[0] == '~' {
+				return nil, fmt.Errorf("invalid selector %q: sibling combinators are not supported", selector)
+			}
+
+			compound := cssCompound{Combinator: combinator}
+			combinator = ' '
+			if s[0] == '*' {
+				s = s[1:]
+			} else if tag := cssIdentPattern.FindString(s); tag != "" {
+				compound.Tag = tag
+				s = s[len(tag):]
+			}
+
+			for s != "" && strings.IndexByte(" \t\n>+~", s[0]) == -1 {
+				switch s[0] {
+				case '#', '.':
+					name := cssIdentPattern.FindString(s[1:])
+					if name == "" {
+						return nil, fmt.Errorf("invalid selector %q", selector)
+					}
+					if s[0] == '#' {
+						compound.ID = name
+					} else {
+						compound.Classes = append(compound.Classes, name)
+					}
+					s = s[1+len(name):]
+				case '[':
+					end := strings.Index(s, "]")
+					if end == -1 {
+						return nil, fmt.Errorf("invalid selector %q: unclosed attribute selector", selector)
+					}
+					matches := cssAttrPattern.FindStringSubmatch(s[1:end])
+					if matches == nil {
+						return nil, fmt.Errorf("invalid selector %q: bad attribute selector %s", selector, s[:end+1])
+					}
+					compound.Attrs = append(compound.Attrs, cssAttr{Name: matches[1], Op: matches[2], Value: matches[3] + matches[4] + matches[5]})
+					s = s[end+1:]
+				case ':':
+					name := cssIdentPattern.FindString(s[1:])
+					if name != "first-child" && name != "last-child" {
+						return nil, fmt.Errorf("invalid selector %q: unsupported pseudo-class :%s", selector, name)
+					}
+					compound.Pseudo = append(compound.Pseudo, name)
+					s = s[1+len(name):]
+				default:
+					return nil, fmt.Errorf("invalid selector %q", selector)
+				}
+			}
+			compounds = append(compounds, compound)
+		}
+		if len(compounds) == 0 || combinator == '>' {
+			return nil, fmt.Errorf("invalid selector %q", selector)
+		}
+		groups = append(groups, compounds)
+	}
+	return groups, nil
+}
+
+// matches reports whether an element satisfies a compound selector on its own
+func (c cssCompound) matches(n *markupNode) bool {
+	if n.Kind != elementNode {
+		return false
+	}
+	if c.Tag != "" && !strings.EqualFold(n.Name, c.Tag) {
+		return false
+	}
+	if c.ID != "" {
+		if id, _ := n.attr("id"); id != c.ID {
+			return false
+		}
+	}
+	classes, _ := n.attr("class")
+	for _, class := range c.Classes {
+		if !containsWord(classes, class) {
+			return false
+		}
+	}
+	for _, attr := range c.Attrs {
+		value, ok := n.attr(attr.Name)
+		if !ok {
+			return false
+		}
+		switch attr.Op {
+		case "=":
+			ok = value == attr.Value
+		case "~=":
+			ok = containsWord(value, attr.Value)
+		case "^=":
+			ok = strings.HasPrefix(value, attr.Value)
+		case "$=":
+			ok = strings.HasSuffix(value, attr.Value)
+		case "*=":
+			ok = strings.Contains(value, attr.Value)
+		}
+		if !ok {
+			return false
+		}
+	}
+	for _, pseudo := range c.Pseudo {
+		siblings := xpathCandidates(n.Parent, "*")
+		if pseudo == "first-child" && siblings[0] != n {
+			return false
+		}
+		if pseudo == "last-child" && siblings[len(siblings)-1] != n {
+			return false
+		}
+	}
+	return true
+}
+
+// containsWord reports whether a space-separated list contains word
+func containsWord(list, word string) bool {
+	for _, w := range strings.Fields(list) {
+		if w == word {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesCompounds reports whether an element matches a selector, checking the last
+// compound against the element and earlier ones against its ancestors
+func matchesCompounds(n *markupNode, compounds []cssCompound) bool {
+	last := compounds[len(compounds)-1]
+	if !last.matches(n) {
+		return false
+	}
+	if len(compounds) == 1 {
+		return true
+	}
+
+	rest := compounds[:len(compounds)-1]
+	if last.Combinator == '>' {
+		return n.Parent != nil && matchesCompounds(n.Parent, rest)
+	}
+	for p := n.Parent; p != nil; p = p.Parent {
+		if matchesCompounds(p, rest) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectCSS returns the elements matching a CSS selector, in document order
+func selectCSS(root *markupNode, selector string) ([]*markupNode, error) {
+	groups, err := parseCSSSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	var selected []*markupNode
+	for _, node := range root.descendantsOrSelf() {
+		for _, compounds := range groups {
+			if matchesCompounds(node, compounds) {
+				selected = append(selected, node)
+				break
+			}
+		}
+	}
+	return selected, nil
+}
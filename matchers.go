@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// uuidPattern matches a UUID in its canonical hyphenated form
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// iso8601Layouts are the date and time formats accepted by the <iso8601> matcher
+var iso8601Layouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// isMatcher reports whether an expected value is a matcher placeholder like "<uuid>"
+// rather than a literal value
+func isMatcher(s string) bool {
+	switch s {
+	case "<any>", "<uuid>", "<iso8601>", "<number>", "<string>", "<boolean>":
+		return true
+	}
+	return strings.HasPrefix(s, "<regex:") && strings.HasSuffix(s, ">")
+}
+
+// matchValue checks an actual value against a matcher placeholder:
+//   - "<any>" accepts any value, including null
+//   - "<uuid>" accepts a UUID string
+//   - "<iso8601>" accepts an ISO 8601 date or timestamp string
+//   - "<number>", "<string>" and "<boolean>" accept any value of that type
+//   - "<regex:PATTERN>" accepts a string (or number) matching PATTERN
+func matchValue(matcher string, actual interface{}) error {
+	str, isString := actual.(string)
+
+	switch matcher {
+	case "<any>":
+		return nil
+	case "<uuid>":
+		if isString && uuidPattern.MatchString(str) {
+			return nil
+		}
+	case "<iso8601>":
+		if isString {
+			for _, layout := range iso8601Layouts {
+				if _, err := time.Parse(layout, str); err == nil {
+					return nil
+				}
+			}
+		}
+	case "<number>":
+		if _, ok := actual.(float64); ok {
+			return nil
+		}
+	case "<string>":
+		if isString {
+			return nil
+		}
+	case "<boolean>":
+		if _, ok := actual.(bool); ok {
+			return nil
+		}
+	default:
+		pattern := strings.TrimSuffix(strings.TrimPrefix(matcher, "<regex:"), ">")
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid regex in matcher %s: %v", matcher, err)
+		}
+		switch actual.(type) {
+		case string, float64:
+			if re.MatchString(fmt.Sprintf("%v", actual)) {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("expected %s, got %s", matcher, formatDiffValue(actual))
+}
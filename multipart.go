@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// encodeMultipart builds a multipart/form-data body from the parts of a test,
+// interpolating variables in field values, and returns it with its Content-Type
+func encodeMultipart(fields []MultipartField, vars map[string]interface{}) (string, string, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	for _, field := range fields {
+		if field.File == "" {
+			if err := writer.WriteField(field.Name, interpolateVariables(field.Value, vars)); err != nil {
+				return "", "", err
+			}
+			continue
+		}
+
+		content, err := os.ReadFile(field.File)
+		if err != nil {
+			return "", "", fmt.Errorf("could not read multipart file '%s': %w", field.File, err)
+		}
+
+		filename := field.Filename
+		if filename == "" {
+			filename = filepath.Base(field.File)
+		}
+		contentType := field.ContentType
+		if contentType == "" {
+			contentType = mime.TypeByExtension(filepath.Ext(field.File))
+		}
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, escapeQuotes(field.Name), escapeQuotes(filename)))
+		header.Set("Content-Type", contentType)
+		part, err := writer.CreatePart(header)
+		if err != nil {
+			return "", "", err
+		}
+		if _, err := part.Write(content); err != nil {
+			return "", "", err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return "", "", err
+	}
+	return buf.String(), writer.FormDataContentType(), nil
+}
+
+// escapeQuotes escapes a value for a quoted Content-Disposition parameter
+func escapeQuotes(s string) string {
+	return strings.NewReplacer("\\", "\\\\", `"`, "\\\"").Replace(s)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -12,13 +13,29 @@ import (
 // parseTests extracts all tests from markdown content
 // baseDir is the directory containing the test file, used for resolving relative file paths
 func parseTests(content string, baseDir string) []Test {
-	var tests []Test
+	return parseTestFile(content, baseDir).Tests
+}
 
+// parseTestFile extracts all tests and setup/teardown hooks from markdown content
+// Hooks are ## headers named "Before all", "After all", "Before each" or "After each",
+// optionally followed by ":" and a description (e.g., "## After all: Delete test user")
+func parseTestFile(content string, baseDir string) TestFile {
 	// Parse frontmatter for defaults
+	original := content
 	defaults, content := parseFrontmatter(content)
+	tf := TestFile{RateLimits: defaults.RateLimits, Errors: defaults.Errors}
+
+	// The remaining content is the end of the original, so line numbers are offset by
+	// the lines before it
+	lineOffset := strings.Count(original[:strings.LastIndex(original, content)], "\n")
+	lineAt := func(pos int) int {
+		return lineOffset + strings.Count(content[:pos], "\n") + 1
+	}
 
 	// Split by ## headers to get individual test blocks
 	testPattern := regexp.MustCompile(`(?m)^## (.+)$`)
+	// A description must follow a ":", so "## After each deletion the list is empty" is still a test
+	hookPattern := regexp.MustCompile(`(?i)^(before|after) (all|each)(:|$)`)
 	matches := testPattern.FindAllStringSubmatchIndex(content, -1)
 
 	for i, match := range matches {
@@ -33,19 +50,47 @@ func parseTests(content string, baseDir string) []Test {
 		}
 		blockContent := content[blockStart:blockEnd]
 
+		// Trailing @tags in the header are used for filtering (e.g., "## Create user @smoke")
+		testName, tags := splitNameTags(testName)
+
 		test := parseTestBlock(testName, blockContent, defaults, baseDir)
-		if test.URL != "" {
-			tests = append(tests, test)
+		if test.URL == "" {
+			continue
+		}
+		test.Tags = tags
+		test.Line = lineAt(match[0])
+		test.EndLine = lineAt(blockEnd)
+		if blockEnd < len(content) {
+			// The block ends on the line before the next header
+			test.EndLine--
+		}
+
+		hook := hookPattern.FindStringSubmatch(testName)
+		if hook == nil {
+			tf.Tests = append(tf.Tests, test)
+			continue
+		}
+
+		switch strings.ToLower(hook[1] + " " + hook[2]) {
+		case "before all":
+			tf.Hooks.BeforeAll = append(tf.Hooks.BeforeAll, test)
+		case "after all":
+			tf.Hooks.AfterAll = append(tf.Hooks.AfterAll, test)
+		case "before each":
+			tf.Hooks.BeforeEach = append(tf.Hooks.BeforeEach, test)
+		case "after each":
+			tf.Hooks.AfterEach = append(tf.Hooks.AfterEach, test)
 		}
 	}
 
-	return tests
+	return tf
 }
 
 // parseFrontmatter extracts YAML frontmatter from content
 func parseFrontmatter(content string) (Defaults, string) {
 	defaults := Defaults{
-		Headers: make(map[string]string),
+		Headers:    make(map[string]string),
+		RateLimits: make(map[string]float64),
 	}
 
 	// Check if content starts with frontmatter delimiter
@@ -75,7 +120,8 @@ func parseFrontmatter(content string) (Defaults, string) {
 	}
 
 	// Parse the frontmatter content
-	inHeaders := false
+	// section tracks which indented block ("headers:", "rate_limits:", "auth:", "signing:", "tls:") we're in
+	section := ""
 	for i := 1; i < endIdx; i++ {
 		line := lines[i]
 		trimmed := strings.TrimSpace(line)
@@ -89,27 +135,73 @@ func parseFrontmatter(content string) (Defaults, string) {
 			defaults.Root = strings.TrimSpace(strings.TrimPrefix(trimmed, "root:"))
 			// Remove trailing slash for consistent joining
 			defaults.Root = strings.TrimSuffix(defaults.Root, "/")
-			inHeaders = false
+			section = ""
 			continue
 		}
 
-		// Check for "headers:" section
-		if trimmed == "headers:" {
-			inHeaders = true
+		// Check for "proto:" setting
+		if strings.HasPrefix(trimmed, "proto:") {
+			defaults.Proto = strings.TrimSpace(strings.TrimPrefix(trimmed, "proto:"))
+			section = ""
 			continue
 		}
 
-		// Parse header entries (indented lines under headers:)
-		if inHeaders && (strings.HasPrefix(line, "  ") || strings.HasPrefix(line, "\t")) {
+		// Check for "headers:", "rate_limits:", "auth:", "signing:" or "tls:" sections
+		if trimmed == "headers:" || trimmed == "rate_limits:" || trimmed == "auth:" || trimmed == "signing:" || trimmed == "tls:" {
+			section = strings.TrimSuffix(trimmed, ":")
+			continue
+		}
+
+		// Parse entries (indented lines under a section)
+		if section != "" && (strings.HasPrefix(line, "  ") || strings.HasPrefix(line, "\t")) {
 			parts := strings.SplitN(trimmed, ":", 2)
+			// Hosts may include a port (e.g., "api.example.com:8443: 5/s"), so the rate
+			// follows the last ": "
+			if i := strings.LastIndex(trimmed, ": "); section == "rate_limits" && i != -1 {
+				parts = []string{trimmed[:i], trimmed[i+2:]}
+			}
 			if len(parts) == 2 {
 				key := strings.TrimSpace(parts[0])
 				value := strings.TrimSpace(parts[1])
-				defaults.Headers[key] = value
+				switch section {
+				case "headers":
+					defaults.Headers[key] = value
+				case "rate_limits":
+					if rate, err := parseRate(value); err == nil {
+						defaults.RateLimits[key] = rate
+					} else {
+						defaults.Errors = append(defaults.Errors, fmt.Sprintf("invalid rate limit for %s: %q (expected a rate like 5/s or 60/m)", key, value))
+					}
+				case "auth":
+					if defaults.Auth == nil {
+						defaults.Auth = &AuthConfig{}
+					}
+					setAuthOption(defaults.Auth, key, value)
+				case "signing":
+					if defaults.Signing == nil {
+						defaults.Signing = &SigningConfig{}
+					}
+					setSigningOption(defaults.Signing, key, value)
+				case "tls":
+					if defaults.TLS == nil {
+						defaults.TLS = &TLSConfig{}
+					}
+					switch strings.ToLower(key) {
+					case "ca_cert":
+						defaults.TLS.CACert = value
+					case "client_cert":
+						defaults.TLS.ClientCert = value
+					case "client_key":
+						defaults.TLS.ClientKey = value
+					case "insecure_skip_verify":
+						defaults.TLS.InsecureSkipVerify = value == "true"
+						defaults.TLS.InsecureSet = true
+					}
+				}
 			}
 		} else {
-			// No longer in headers section
-			inHeaders = false
+			// No longer in a section
+			section = ""
 		}
 	}
 
@@ -118,6 +210,84 @@ func parseFrontmatter(content string) (Defaults, string) {
 	return defaults, remaining
 }
 
+// setAuthOption sets one entry of an auth: block. Unknown keys are ignored, like
+// other frontmatter settings.
+func setAuthOption(auth *AuthConfig, key, value string) {
+	switch strings.ToLower(key) {
+	case "type":
+		auth.Type = strings.ToLower(value)
+	case "username":
+		auth.Username = value
+	case "password":
+		auth.Password = value
+	case "token":
+		auth.Token = value
+	case "grant":
+		auth.Grant = strings.ToLower(value)
+	case "token_url":
+		auth.TokenURL = value
+	case "client_id":
+		auth.ClientID = value
+	case "client_secret":
+		auth.ClientSecret = value
+	case "scope":
+		auth.Scope = value
+	}
+}
+
+// setSigningOption sets one entry of a signing: block. "\n" in the canonical string
+// stands for a newline.
+func setSigningOption(signing *SigningConfig, key, value string) {
+	switch strings.ToLower(key) {
+	case "type":
+		signing.Type = strings.ToLower(value)
+	case "secret":
+		signing.Secret = value
+	case "header":
+		signing.Header = value
+	case "canonical":
+		signing.Canonical = strings.ReplaceAll(value, `\n`, "\n")
+	case "timestamp_header":
+		signing.TimestampHeader = value
+	case "prefix":
+		signing.Prefix = value
+	case "encoding":
+		signing.Encoding = strings.ToLower(value)
+	case "access_key":
+		signing.AccessKey = value
+	case "secret_key":
+		signing.SecretKey = value
+	case "session_token":
+		signing.SessionToken = value
+	case "region":
+		signing.Region = value
+	case "service":
+		signing.Service = value
+	}
+}
+
+// blockContentTypes maps code block languages to the Content-Type sent with their body
+var blockContentTypes = map[string]string{
+	"json":      "application/json",
+	"form":      "application/x-www-form-urlencoded",
+	"xml":       "application/xml",
+	"soap":      "application/soap+xml",
+	"html":      "text/html",
+	"text":      "text/plain",
+	"txt":       "text/plain",
+	"plain":     "text/plain",
+	"yaml":      "application/yaml",
+	"yml":       "application/yaml",
+	"ndjson":    "application/x-ndjson",
+	"jsonl":     "application/x-ndjson",
+	"csv":       "text/csv",
+	"graphql":   "application/json",
+	"js":        "application/javascript",
+	"toml":      "application/toml",
+	"multipart": "multipart/form-data",
+	"binary":    "application/octet-stream",
+}
+
 // parseTestBlock parses a single test block
 // baseDir is used for resolving relative file paths in FILE: references
 func parseTestBlock(name, content string, defaults Defaults, baseDir string) Test {
@@ -127,6 +297,20 @@ func parseTestBlock(name, content string, defaults Defaults, baseDir string) Tes
 		Headers: make(map[string]string),
 	}
 
+	test.Auth = defaults.Auth
+	test.Signing = defaults.Signing
+
+	// Certificate paths are relative to the test file, like FILE: payloads
+	if defaults.TLS != nil {
+		tlsConfig := *defaults.TLS
+		for _, path := range []*string{&tlsConfig.CACert, &tlsConfig.ClientCert, &tlsConfig.ClientKey} {
+			if *path != "" && !filepath.IsAbs(*path) {
+				*path = filepath.Join(baseDir, *path)
+			}
+		}
+		test.TLS = &tlsConfig
+	}
+
 	// Apply default headers first
 	for key, value := range defaults.Headers {
 		test.Headers[key] = value
@@ -139,7 +323,8 @@ func parseTestBlock(name, content string, defaults Defaults, baseDir string) Tes
 
 	// Find the HTTP method and URL line
 	// Supports both absolute URLs (https://...) and relative paths (/path)
-	httpPattern := regexp.MustCompile(`^(GET|POST|PUT|PATCH|DELETE)\s+(\S+)`)
+	// GRPC and GRPC-WEB lines name a method instead, e.g. "GRPC shop.v1.Orders/GetOrder"
+	httpPattern := regexp.MustCompile(`^(GET|POST|PUT|PATCH|DELETE|GRPC-WEB|GRPC|WS)\s+(\S+)`)
 	var methodLineIdx int
 
 	for i, line := range lines {
@@ -150,7 +335,7 @@ func parseTestBlock(name, content string, defaults Defaults, baseDir string) Tes
 			// If it's a relative path and we have a root, prepend the root
 			if strings.HasPrefix(urlOrPath, "/") && defaults.Root != "" {
 				test.URL = defaults.Root + urlOrPath
-			} else if strings.HasPrefix(urlOrPath, "http://") || strings.HasPrefix(urlOrPath, "https://") {
+			} else if regexp.MustCompile(`^(https?|wss?)://`).MatchString(urlOrPath) {
 				test.URL = urlOrPath
 			} else if defaults.Root != "" {
 				// Handle paths without leading slash
@@ -175,6 +360,10 @@ func parseTestBlock(name, content string, defaults Defaults, baseDir string) Tes
 	waitUntilPattern := regexp.MustCompile(`(?i)^-\s+Wait until status is (\d+)$`)
 	waitUntilFieldPattern := regexp.MustCompile("(?i)^-\\s+Wait until field `([^`]+)` equals `([^`]+)`$")
 	retryPattern := regexp.MustCompile(`(?i)^-\s+Retry (\d+) times every (.+)$`)
+	repeatPattern := regexp.MustCompile(`(?i)^-\s+Repeat (\d+) times$`)
+	readCountPattern := regexp.MustCompile(`(?i)^-\s+Read (\d+) events?$`)
+	readUntilPattern := regexp.MustCompile("(?i)^-\\s+Read until event `([^`]+)`$")
+	readForPattern := regexp.MustCompile(`(?i)^-\s+Read events for (.+)$`)
 
 	for i := methodLineIdx + 1; i < len(lines); i++ {
 		line := lines[i]
@@ -206,11 +395,44 @@ func parseTestBlock(name, content string, defaults Defaults, baseDir string) Tes
 			continue
 		}
 
+		if matches := repeatPattern.FindStringSubmatch(line); matches != nil {
+			if n, err := strconv.Atoi(matches[1]); err == nil {
+				test.Repeat = n
+			}
+			continue
+		}
+
+		// Event stream options: "Read 5 events", "Read until event `done`", "Read events for 10s"
+		if matches := readCountPattern.FindStringSubmatch(line); matches != nil {
+			if n, err := strconv.Atoi(matches[1]); err == nil {
+				test.EventCount = n
+			}
+			continue
+		}
+
+		if matches := readUntilPattern.FindStringSubmatch(line); matches != nil {
+			test.EventUntil = matches[1]
+			continue
+		}
+
+		if matches := readForPattern.FindStringSubmatch(line); matches != nil {
+			if d, err := parseDuration(matches[1]); err == nil {
+				test.EventTimeout = d
+			}
+			continue
+		}
+
 		// Parse as header
 		if matches := headerPattern.FindStringSubmatch(line); matches != nil {
 			optionName := strings.TrimSpace(matches[1])
 			optionValue := strings.TrimSpace(matches[2])
 
+			// "- Proto: path" gives a gRPC call's .proto file rather than a header
+			if isGRPC(test) && strings.EqualFold(optionName, "Proto") {
+				test.ProtoFile = optionValue
+				continue
+			}
+
 			test.Headers[optionName] = optionValue
 			if strings.EqualFold(optionName, "Content-Type") {
 				test.ContentType = optionValue
@@ -220,10 +442,45 @@ func parseTestBlock(name, content string, defaults Defaults, baseDir string) Tes
 		}
 	}
 
+	// A WS test is a conversation rather than a request, so it has steps instead of a
+	// body and assertions
+	if test.Method == "WS" {
+		test.Steps = parseWebSocketSteps(lines[methodLineIdx+1:], baseDir)
+		for _, step := range test.Steps {
+			test.SaveFields = append(test.SaveFields, step.SaveFields...)
+		}
+		return test
+	}
+
+	// The .proto file of a gRPC call is relative to the test file
+	if isGRPC(test) {
+		if test.ProtoFile == "" {
+			test.ProtoFile = defaults.Proto
+		}
+		if test.ProtoFile != "" && !filepath.IsAbs(test.ProtoFile) {
+			test.ProtoFile = filepath.Join(baseDir, test.ProtoFile)
+		}
+	}
+
 	// Parse code blocks for body content
-	codeBlockPattern := regexp.MustCompile("(?s)```(json|form)\\s*\n(.+?)```")
-	if matches := codeBlockPattern.FindStringSubmatch(content); matches != nil {
-		blockType := matches[1]
+	// Only blocks between the request line and the assertions count, since descriptions
+	// and assertions can have code blocks of their own
+	requestContent := strings.Join(lines[methodLineIdx:], "\n")
+	if loc := regexp.MustCompile(`(?m)^Asserts?:\s*$`).FindStringIndex(requestContent); loc != nil {
+		requestContent = requestContent[:loc[0]]
+	}
+	codeBlockPattern := regexp.MustCompile("(?s)```([\\w.+-]*)[ \\t]*\n(.+?)```")
+	var bodyBlock []string
+	for _, matches := range codeBlockPattern.FindAllStringSubmatch(requestContent, -1) {
+		// A ```variables block goes with a ```graphql body rather than being the body
+		if strings.EqualFold(matches[1], "variables") {
+			test.Variables = strings.TrimSpace(matches[2])
+		} else if bodyBlock == nil {
+			bodyBlock = matches
+		}
+	}
+	if matches := bodyBlock; matches != nil {
+		blockType := strings.ToLower(matches[1])
 		blockContent := strings.TrimSpace(matches[2])
 
 		// Check if content is a file reference
@@ -233,23 +490,29 @@ func parseTestBlock(name, content string, defaults Defaults, baseDir string) Tes
 			if !filepath.IsAbs(filePath) {
 				filePath = filepath.Join(baseDir, filePath)
 			}
+			test.PayloadFile = filePath
 			fileContent, err := os.ReadFile(filePath)
 			if err == nil {
 				blockContent = string(fileContent)
+				test.RawBody = isBinary(fileContent)
 			}
 			// If file can't be read, keep the FILE: reference as-is (will fail at runtime)
 		}
 
-		if blockType == "json" {
-			test.Body = blockContent
-			if test.ContentType == "" {
-				test.ContentType = "application/json"
-			}
-		} else if blockType == "form" {
-			test.Body = blockContent
-			if test.ContentType == "" {
-				test.ContentType = "application/x-www-form-urlencoded"
-			}
+		// Any language is sent as-is; known ones also set a default Content-Type
+		test.Body = blockContent
+		if blockType == "multipart" {
+			test.Multipart = parseMultipart(blockContent, baseDir)
+		}
+		if blockType == "graphql" {
+			test.GraphQL = true
+		}
+		if (blockType == "ndjson" || blockType == "jsonl") && !test.RawBody {
+			// Every NDJSON record ends with a newline, including the last one
+			test.Body += "\n"
+		}
+		if test.ContentType == "" {
+			test.ContentType = blockContentTypes[blockType]
 		}
 	}
 
@@ -260,6 +523,94 @@ func parseTestBlock(name, content string, defaults Defaults, baseDir string) Tes
 	return test
 }
 
+// parseWebSocketSteps parses the Send:, Expect: and Save: sections of a WS test
+// A Send: message is inline ("Send: ping") or in the code block that follows.
+// "Expect:" or "Expect within 2s:" is followed by assertions, and a Save: after an
+// Expect: saves fields from the message it matched.
+func parseWebSocketSteps(lines []string, baseDir string) []WebSocketStep {
+	sendPattern := regexp.MustCompile(`^Send:\s*(.*)$`)
+	expectPattern := regexp.MustCompile(`(?i)^Expect(?:\s+within\s+(\S+))?:\s*$`)
+	savePattern := regexp.MustCompile(`^Saves?:\s*$`)
+
+	var steps []WebSocketStep
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		rest := strings.Join(lines[i+1:], "\n")
+
+		if matches := sendPattern.FindStringSubmatch(line); matches != nil {
+			step := WebSocketStep{Action: "send", Message: strings.TrimSpace(matches[1])}
+			if step.Message == "" {
+				// Take the code block that follows
+				for j := i + 1; j < len(lines); j++ {
+					next := strings.TrimSpace(lines[j])
+					if next == "" {
+						continue
+					}
+					if strings.HasPrefix(next, "```") {
+						var block []string
+						for j++; j < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[j]), "```"); j++ {
+							block = append(block, lines[j])
+						}
+						step.Message = strings.TrimSpace(strings.Join(block, "\n"))
+						i = j
+					}
+					break
+				}
+			}
+			steps = append(steps, step)
+		} else if matches := expectPattern.FindStringSubmatch(line); matches != nil {
+			step := WebSocketStep{Action: "expect", Assertions: parseAssertionList(rest, baseDir)}
+			if matches[1] != "" {
+				if d, err := parseDuration(matches[1]); err == nil {
+					step.Timeout = d
+				}
+			}
+			steps = append(steps, step)
+		} else if savePattern.MatchString(line) && len(steps) > 0 && steps[len(steps)-1].Action == "expect" {
+			steps[len(steps)-1].SaveFields = parseSaveList(rest)
+		}
+	}
+	return steps
+}
+
+// parseMultipart parses the lines of a ```multipart block
+// Each line is "field=value" or "field=@path/to/file" with optional ";type=..." and
+// ";filename=..." parameters. File paths are resolved from the test file's directory.
+func parseMultipart(content, baseDir string) []MultipartField {
+	var fields []MultipartField
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		name, value, ok := strings.Cut(line, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			continue
+		}
+		field := MultipartField{Name: strings.TrimSpace(name)}
+
+		if !strings.HasPrefix(value, "@") {
+			field.Value = value
+			fields = append(fields, field)
+			continue
+		}
+
+		params := strings.Split(strings.TrimPrefix(value, "@"), ";")
+		field.File = strings.TrimSpace(params[0])
+		if !filepath.IsAbs(field.File) {
+			field.File = filepath.Join(baseDir, field.File)
+		}
+		for _, param := range params[1:] {
+			key, val, _ := strings.Cut(param, "=")
+			switch strings.ToLower(strings.TrimSpace(key)) {
+			case "type":
+				field.ContentType = strings.TrimSpace(val)
+			case "filename":
+				field.Filename = strings.Trim(strings.TrimSpace(val), `"`)
+			}
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
 // parseAssertions extracts assertions from a test block
 // baseDir is used for resolving relative file paths in FILE: references
 func parseAssertions(content string, baseDir string) []Assertion {
@@ -272,11 +623,16 @@ func parseAssertions(content string, baseDir string) []Assertion {
 		return assertions
 	}
 
-	// Get content after "Assert(s):"
-	assertContent := content[loc[1]:]
+	return parseAssertionList(content[loc[1]:], baseDir)
+}
+
+// parseAssertionList parses the assertion bullet points at the start of content,
+// such as the lines after "Assert:"
+func parseAssertionList(content string, baseDir string) []Assertion {
+	var assertions []Assertion
 
 	// Parse each assertion line
-	lines := strings.Split(assertContent, "\n")
+	lines := strings.Split(content, "\n")
 	for i := 0; i < len(lines); i++ {
 		line := strings.TrimSpace(lines[i])
 		if line == "" {
@@ -308,6 +664,133 @@ func parseAssertions(content string, baseDir string) []Assertion {
 			continue
 		}
 
+		// GraphQL errors assertion: "GraphQL errors contain `Not authorized`"
+		graphQLErrorsPattern := regexp.MustCompile("^GraphQL errors contain `([^`]+)`$")
+		if matches := graphQLErrorsPattern.FindStringSubmatch(line); matches != nil {
+			assertions = append(assertions, Assertion{
+				Type:  "graphql_errors_contain",
+				Value: matches[1],
+			})
+			continue
+		}
+
+		// Event stream assertions: "Event count is 3", "Event 1 type is `update`",
+		// "Event `done` data field `status` equals `"ok"`", "Event last data is `[DONE]`"
+		eventCountPattern := regexp.MustCompile(`^Event count is (\d+)$`)
+		if matches := eventCountPattern.FindStringSubmatch(line); matches != nil {
+			assertions = append(assertions, Assertion{
+				Type:  "event_count",
+				Value: matches[1],
+			})
+			continue
+		}
+
+		eventFieldPattern := regexp.MustCompile("^Event (\\d+|last|`[^`]+`) data field `([^`]+)` equals `([^`]+)`")
+		if matches := eventFieldPattern.FindStringSubmatch(line); matches != nil {
+			assertions = append(assertions, Assertion{
+				Type:  "event_field",
+				Event: strings.Trim(matches[1], "`"),
+				Field: matches[2],
+				Value: matches[3],
+			})
+			continue
+		}
+
+		eventPattern := regexp.MustCompile("^Event (\\d+|last|`[^`]+`) (type is|id is|data is|data includes text) `([^`]*)`$")
+		if matches := eventPattern.FindStringSubmatch(line); matches != nil {
+			assertions = append(assertions, Assertion{
+				Type:  "event_" + strings.ReplaceAll(strings.TrimSuffix(strings.TrimSuffix(matches[2], " is"), " text"), " ", "_"),
+				Event: strings.Trim(matches[1], "`"),
+				Value: matches[3],
+			})
+			continue
+		}
+
+		// TLS assertions: "TLS version is 1.3", "Certificate expires in more than 30 days"
+		tlsVersionPattern := regexp.MustCompile("(?i)^TLS version is `?(1\\.[0-3])`?$")
+		if matches := tlsVersionPattern.FindStringSubmatch(line); matches != nil {
+			assertions = append(assertions, Assertion{
+				Type:  "tls_version",
+				Value: matches[1],
+			})
+			continue
+		}
+
+		certExpiryPattern := regexp.MustCompile(`(?i)^Certificate expires in more than (\d+) days?$`)
+		if matches := certExpiryPattern.FindStringSubmatch(line); matches != nil {
+			assertions = append(assertions, Assertion{
+				Type:  "cert_expiry",
+				Value: matches[1],
+			})
+			continue
+		}
+
+		// gRPC status assertion: "gRPC status is NOT_FOUND" (or a code, e.g. 5)
+		grpcStatusPattern := regexp.MustCompile("(?i)^gRPC status is `?(\\w+)`?$")
+		if matches := grpcStatusPattern.FindStringSubmatch(line); matches != nil {
+			assertions = append(assertions, Assertion{
+				Type:  "grpc_status",
+				Value: matches[1],
+			})
+			continue
+		}
+
+		// Binary-safe assertions: "Body size is 1024 bytes", "Body sha256 equals `9f86d0...`"
+		bodySizePattern := regexp.MustCompile("^Body size is (\\d+) bytes?$")
+		if matches := bodySizePattern.FindStringSubmatch(line); matches != nil {
+			assertions = append(assertions, Assertion{
+				Type:  "body_size",
+				Value: matches[1],
+			})
+			continue
+		}
+		bodySHA256Pattern := regexp.MustCompile("^Body sha256 equals `?([0-9a-fA-F]{64})`?$")
+		if matches := bodySHA256Pattern.FindStringSubmatch(line); matches != nil {
+			assertions = append(assertions, Assertion{
+				Type:  "body_sha256",
+				Value: matches[1],
+			})
+			continue
+		}
+
+		// Text assertions for any body: "Body includes text `Welcome`", "Body matches regex `^<!DOCTYPE`"
+		bodyTextPattern := regexp.MustCompile("^Body (includes text|matches regex) `([^`]+)`$")
+		if matches := bodyTextPattern.FindStringSubmatch(line); matches != nil {
+			assertionType := "body_includes_text"
+			if matches[1] == "matches regex" {
+				assertionType = "body_matches_regex"
+			}
+			assertions = append(assertions, Assertion{
+				Type:  assertionType,
+				Value: matches[2],
+			})
+			continue
+		}
+
+		// XPath and CSS selector assertions for XML and HTML bodies:
+		// "XPath `//user/name` equals `Ada`", "Element `h1.title` has text `Welcome`",
+		// "Element `li` count is 3", "XPath `//error` exists"
+		markupPattern := regexp.MustCompile("^(XPath|Element) `([^`]+)` (?:(exists)|count is (\\d+)|(?:equals|has text) `([^`]*)`)$")
+		if matches := markupPattern.FindStringSubmatch(line); matches != nil {
+			kind := strings.ToLower(matches[1])
+			assertion := Assertion{Field: matches[2]}
+			switch {
+			case matches[3] != "":
+				assertion.Type = kind + "_exists"
+			case matches[4] != "":
+				assertion.Type = kind + "_count"
+				assertion.Value = matches[4]
+			case kind == "xpath":
+				assertion.Type = "xpath_equals"
+				assertion.Value = matches[5]
+			default:
+				assertion.Type = "element_text"
+				assertion.Value = matches[5]
+			}
+			assertions = append(assertions, assertion)
+			continue
+		}
+
 		// Field equals assertion: "Field `path` equals `value`"
 		fieldEqualsPattern := regexp.MustCompile("^Field `([^`]+)` equals `([^`]+)`")
 		if matches := fieldEqualsPattern.FindStringSubmatch(line); matches != nil {
@@ -329,17 +812,51 @@ func parseAssertions(content string, baseDir string) []Assertion {
 			continue
 		}
 
+		// Duration statistic assertion across repetitions: "p95 duration less than 300ms",
+		// "Average duration less than 200ms", "Max time less than 1s"
+		durationStatPattern := regexp.MustCompile("(?i)^(p\\d+(?:\\.\\d+)?|average|avg|min|max) (?:duration|time) less than (.+)$")
+		if matches := durationStatPattern.FindStringSubmatch(line); matches != nil {
+			stat := strings.ToLower(matches[1])
+			if stat == "average" {
+				stat = "avg"
+			}
+			assertions = append(assertions, Assertion{
+				Type:  "duration_stat",
+				Field: stat,
+				Value: matches[2],
+			})
+			continue
+		}
+
 		// Body matches file assertion: "Body matches file `path/to/file.json`"
-		bodyMatchesFilePattern := regexp.MustCompile("^Body matches file `([^`]+)`")
+		// Optionally followed by fields to leave out and/or array order:
+		// "ignoring `id`, `created_at`", "ignoring array order", "ignoring `id` and array order"
+		bodyMatchesFilePattern := regexp.MustCompile("^Body matches file `([^`]+)`(?:\\s+ignoring\\s+(.+))?")
 		if matches := bodyMatchesFilePattern.FindStringSubmatch(line); matches != nil {
 			filePath := matches[1]
 			// Resolve relative path from test file's directory
 			if !filepath.IsAbs(filePath) {
 				filePath = filepath.Join(baseDir, filePath)
 			}
+			ignore, ignoreOrder := parseIgnoreClause(matches[2])
+			assertions = append(assertions, Assertion{
+				Type:        "body_matches_file",
+				Value:       filePath,
+				Ignore:      ignore,
+				IgnoreOrder: ignoreOrder,
+			})
+			continue
+		}
+
+		// Body matches snapshot assertion: "Body matches snapshot ignoring `id`, `created_at`"
+		// The snapshot path is filled in once the test file's path is known
+		bodyMatchesSnapshotPattern := regexp.MustCompile("^Body matches snapshot(?:\\s+ignoring\\s+(.+))?$")
+		if matches := bodyMatchesSnapshotPattern.FindStringSubmatch(line); matches != nil {
+			ignore, ignoreOrder := parseIgnoreClause(matches[1])
 			assertions = append(assertions, Assertion{
-				Type:  "body_matches_file",
-				Value: filePath,
+				Type:        "body_matches_snapshot",
+				Ignore:      ignore,
+				IgnoreOrder: ignoreOrder,
 			})
 			continue
 		}
@@ -369,6 +886,12 @@ func parseAssertions(content string, baseDir string) []Assertion {
 						Type:  "body_partial_match",
 						Value: strings.Join(markedLines, "\n"),
 					})
+				} else if strings.TrimSpace(blockContent) != "" {
+					// Without >> lines the whole block is a JSON document the response must contain
+					assertions = append(assertions, Assertion{
+						Type:  "body_subset_match",
+						Value: blockContent,
+					})
 				}
 				// Skip past the code block
 				for j := i + 1; j < len(lines); j++ {
@@ -402,13 +925,18 @@ func parseSaveFields(content string) []SaveField {
 		return saveFields
 	}
 
-	// Get content after "Save(s):"
-	saveContent := content[loc[1]:]
+	return parseSaveList(content[loc[1]:])
+}
+
+// parseSaveList parses the save field bullet points at the start of content, such as
+// the lines after "Save:"
+func parseSaveList(content string) []SaveField {
+	var saveFields []SaveField
 
 	// Parse each save field line: "- Field `path` as `variable`"
 	saveFieldPattern := regexp.MustCompile("^Field `([^`]+)` as `([^`]+)`")
 
-	lines := strings.Split(saveContent, "\n")
+	lines := strings.Split(content, "\n")
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
 		if line == "" {
@@ -430,3 +958,19 @@ func parseSaveFields(content string) []SaveField {
 
 	return saveFields
 }
+
+// parseIgnoreClause parses what follows "ignoring" in a body comparison: backtick-quoted
+// field paths, and "array order" to compare arrays regardless of item order
+func parseIgnoreClause(s string) ([]string, bool) {
+	ignoreOrder := regexp.MustCompile(`(?i)\barray order\b`).MatchString(regexp.MustCompile("`[^`]*`").ReplaceAllString(s, ""))
+	return parseBacktickList(s), ignoreOrder
+}
+
+// parseBacktickList returns the backtick-quoted items in a list like "`id`, `created_at`"
+func parseBacktickList(s string) []string {
+	var items []string
+	for _, match := range regexp.MustCompile("`([^`]+)`").FindAllStringSubmatch(s, -1) {
+		items = append(items, match[1])
+	}
+	return items
+}
@@ -0,0 +1,817 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// protoSchema holds message, enum and service definitions from .proto files or
+// server reflection, keyed by fully-qualified name (e.g. "shop.v1.Order")
+type protoSchema struct {
+	Messages map[string]*protoMessage
+	Enums    map[string]*protoEnum
+	Services map[string]*protoService
+}
+
+// protoMessage is a message type
+type protoMessage struct {
+	Name     string
+	Fields   []*protoField
+	MapEntry bool // Synthetic key/value message behind a map field
+}
+
+// protoField is a field of a message
+type protoField struct {
+	Name     string
+	JSONName string
+	Number   int
+	Kind     string // Scalar type ("int32", "string", ...), "message" or "enum"
+	TypeName string // Message or enum type as written, until resolved
+	Scope    string // Where TypeName is looked up from
+	Repeated bool
+	Packed   bool // Repeated numbers are packed into one value
+	Optional bool // Has presence (oneof member or optional), so no default is shown when unset
+	Message  *protoMessage
+	Enum     *protoEnum
+}
+
+// protoEnum is an enum type
+type protoEnum struct {
+	Name   string
+	Values map[string]int32
+	Names  map[int32]string
+	First  string // Name of the first value, the default
+}
+
+// protoService is a gRPC service
+type protoService struct {
+	Name    string
+	Methods map[string]*protoMethod
+}
+
+// protoMethod is an rpc of a service
+type protoMethod struct {
+	Name            string
+	Input           string // Request message, fully-qualified once resolved
+	Output          string // Response message, fully-qualified once resolved
+	Scope           string
+	ClientStreaming bool
+	ServerStreaming bool
+}
+
+// protoScalarKinds are the built-in field types
+var protoScalarKinds = map[string]bool{
+	"double": true, "float": true, "int32": true, "int64": true, "uint32": true, "uint64": true,
+	"sint32": true, "sint64": true, "fixed32": true, "fixed64": true, "sfixed32": true, "sfixed64": true,
+	"bool": true, "string": true, "bytes": true,
+}
+
+func newProtoSchema() *protoSchema {
+	return &protoSchema{
+		Messages: make(map[string]*protoMessage),
+		Enums:    make(map[string]*protoEnum),
+		Services: make(map[string]*protoService),
+	}
+}
+
+// field finds a field by its JSON name or its name in the .proto file
+func (m *protoMessage) field(name string) *protoField {
+	for _, f := range m.Fields {
+		if f.JSONName == name || f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// fieldByNumber finds a field by its number
+func (m *protoMessage) fieldByNumber(number int) *protoField {
+	for _, f := range m.Fields {
+		if f.Number == number {
+			return f
+		}
+	}
+	return nil
+}
+
+// protoJSONName converts a field name to its JSON name, e.g. "user_id" -> "userId"
+func protoJSONName(name string) string {
+	var b strings.Builder
+	upper := false
+	for _, r := range name {
+		if r == '_' {
+			upper = true
+			continue
+		}
+		if upper && r >= 'a' && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		upper = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// qualifyProtoName joins a scope and a name, e.g. ("shop.v1", "Order") -> "shop.v1.Order"
+func qualifyProtoName(scope, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "." + name
+}
+
+// lookup resolves a type name used in scope the way protoc does: from the innermost
+// enclosing scope outwards. Names starting with "." are already fully-qualified.
+func (s *protoSchema) lookup(scope, name string) string {
+	if strings.HasPrefix(name, ".") {
+		name = name[1:]
+		if s.Messages[name] != nil || s.Enums[name] != nil {
+			return name
+		}
+		return ""
+	}
+	for {
+		candidate := qualifyProtoName(scope, name)
+		if s.Messages[candidate] != nil || s.Enums[candidate] != nil {
+			return candidate
+		}
+		if scope == "" {
+			return ""
+		}
+		if i := strings.LastIndex(scope, "."); i >= 0 {
+			scope = scope[:i]
+		} else {
+			scope = ""
+		}
+	}
+}
+
+// resolve links message and enum fields, and service methods, to their types
+func (s *protoSchema) resolve() error {
+	for _, msg := range s.Messages {
+		for _, field := range msg.Fields {
+			if field.Kind != "" {
+				continue
+			}
+			name := s.lookup(field.Scope, field.TypeName)
+			if name == "" {
+				return fmt.Errorf("unknown type %q for field %s.%s", field.TypeName, msg.Name, field.Name)
+			}
+			if m := s.Messages[name]; m != nil {
+				field.Kind, field.Message = "message", m
+			} else {
+				field.Kind, field.Enum = "enum", s.Enums[name]
+			}
+		}
+	}
+
+	for _, svc := range s.Services {
+		for _, method := range svc.Methods {
+			for _, typeName := range []*string{&method.Input, &method.Output} {
+				name := s.lookup(method.Scope, *typeName)
+				if name == "" || s.Messages[name] == nil {
+					return fmt.Errorf("unknown message type %q for %s/%s", *typeName, svc.Name, method.Name)
+				}
+				*typeName = name
+			}
+		}
+	}
+	return nil
+}
+
+// setKind sets a field's type from its name in a .proto file or descriptor
+func (f *protoField) setKind(typeName, scope string) {
+	if protoScalarKinds[typeName] {
+		f.Kind = typeName
+		return
+	}
+	f.TypeName = typeName
+	f.Scope = scope
+}
+
+// cachedProtoSchema is a parsed .proto file, kept until one of its files changes
+type cachedProtoSchema struct {
+	Schema   *protoSchema
+	ModTimes map[string]time.Time
+}
+
+var (
+	protoSchemaCache   = make(map[string]cachedProtoSchema)
+	protoSchemaCacheMu sync.Mutex
+)
+
+// loadProtoSchema parses a .proto file and the files it imports. Imports are found
+// relative to the importing file's directory and its parents; well-known types
+// (google/protobuf/timestamp.proto, ...) are built in. Imports that can't be found
+// are skipped, and only fail if a type from them is used.
+func loadProtoSchema(path string) (*protoSchema, error) {
+	protoSchemaCacheMu.Lock()
+	defer protoSchemaCacheMu.Unlock()
+	if cached, ok := protoSchemaCache[path]; ok && !protoFilesChanged(cached.ModTimes) {
+		return cached.Schema, nil
+	}
+
+	schema := newProtoSchema()
+	loaded := make(map[string]bool)
+	modTimes := make(map[string]time.Time)
+	var load func(path, importName string) error
+	load = func(path, importName string) error {
+		var content string
+		if source, ok := builtinProtos[importName]; ok {
+			content = source
+			path = importName
+		} else {
+			info, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			content = string(data)
+			modTimes[path] = info.ModTime()
+		}
+		if loaded[path] {
+			return nil
+		}
+		loaded[path] = true
+
+		imports, err := parseProto(schema, path, content)
+		if err != nil {
+			return err
+		}
+		for _, imp := range imports {
+			if _, ok := builtinProtos[imp]; ok {
+				if err := load(imp, imp); err != nil {
+					return err
+				}
+				continue
+			}
+			// Imports are usually relative to a root some levels above the importing file
+			for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+				candidate := filepath.Join(dir, imp)
+				if _, err := os.Stat(candidate); err == nil {
+					if err := load(candidate, imp); err != nil {
+						return err
+					}
+					break
+				}
+				if parent := filepath.Dir(dir); parent == dir {
+					break
+				}
+			}
+		}
+		return nil
+	}
+
+	if err := load(path, path); err != nil {
+		return nil, err
+	}
+	if err := schema.resolve(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	protoSchemaCache[path] = cachedProtoSchema{Schema: schema, ModTimes: modTimes}
+	return schema, nil
+}
+
+// protoFilesChanged reports whether any of the files a schema was loaded from has
+// changed since, so "marcus watch" picks up edits
+func protoFilesChanged(modTimes map[string]time.Time) bool {
+	for path, modTime := range modTimes {
+		if info, err := os.Stat(path); err != nil || !info.ModTime().Equal(modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+// protoTokenPattern splits .proto source into tokens: whitespace and comments (skipped),
+// strings, identifiers (which may be dotted), numbers and single symbols
+var protoTokenPattern = regexp.MustCompile(`\s+|//[^\n]*|(?s:/\*.*?\*/)|"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'|[A-Za-z_.][\w.]*|[-+]?[0-9][\w.+-]*|.`)
+
+// protoParser parses the subset of the .proto language needed to encode messages:
+// messages, enums, oneofs, maps and services. Options, extensions and reserved
+// ranges are skipped.
+type protoParser struct {
+	schema *protoSchema
+	path   string
+	tokens []string
+	lines  []int
+	pos    int
+	pkg    string
+	proto3 bool
+}
+
+// parseProto adds the definitions in a .proto file to schema and returns its imports
+func parseProto(schema *protoSchema, path, content string) ([]string, error) {
+	p := &protoParser{schema: schema, path: path}
+	line := 1
+	for _, token := range protoTokenPattern.FindAllString(content, -1) {
+		first := token[0]
+		isSpace := first == ' ' || first == '\t' || first == '\n' || first == '\r'
+		if !isSpace && !strings.HasPrefix(token, "//") && !strings.HasPrefix(token, "/*") {
+			p.tokens = append(p.tokens, token)
+			p.lines = append(p.lines, line)
+		}
+		line += strings.Count(token, "\n")
+	}
+
+	var imports []string
+	for p.pos < len(p.tokens) {
+		var err error
+		switch token := p.next(); token {
+		case ";":
+		case "syntax", "edition":
+			if err = p.expect("="); err == nil {
+				p.proto3 = unquoteProto(p.next()) != "proto2"
+				err = p.expect(";")
+			}
+		case "package":
+			p.pkg = p.next()
+			err = p.expect(";")
+		case "import":
+			name := p.next()
+			if name == "public" || name == "weak" {
+				name = p.next()
+			}
+			imports = append(imports, unquoteProto(name))
+			err = p.expect(";")
+		case "option", "extend":
+			err = p.skipStatement()
+		case "message":
+			err = p.parseMessage(p.pkg)
+		case "enum":
+			err = p.parseEnum(p.pkg)
+		case "service":
+			err = p.parseService()
+		default:
+			err = p.errorf("unexpected %q", token)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return imports, nil
+}
+
+func (p *protoParser) peek(offset int) string {
+	if p.pos+offset < len(p.tokens) {
+		return p.tokens[p.pos+offset]
+	}
+	return ""
+}
+
+func (p *protoParser) next() string {
+	token := p.peek(0)
+	p.pos++
+	return token
+}
+
+func (p *protoParser) expect(token string) error {
+	if got := p.next(); got != token {
+		if got == "" {
+			return p.errorf("expected %q, got end of file", token)
+		}
+		return p.errorf("expected %q, got %q", token, got)
+	}
+	return nil
+}
+
+func (p *protoParser) errorf(format string, args ...interface{}) error {
+	line := 0
+	if i := p.pos - 1; i >= 0 && i < len(p.lines) {
+		line = p.lines[i]
+	} else if len(p.lines) > 0 {
+		line = p.lines[len(p.lines)-1]
+	}
+	return fmt.Errorf("%s:%d: %s", p.path, line, fmt.Sprintf(format, args...))
+}
+
+// skipStatement skips to the end of a statement: its ";", or the "}" closing a block
+// it opened
+func (p *protoParser) skipStatement() error {
+	depth := 0
+	for {
+		switch p.next() {
+		case "":
+			return p.errorf("unexpected end of file")
+		case "{":
+			depth++
+		case "}":
+			depth--
+			if depth <= 0 {
+				return nil
+			}
+		case ";":
+			if depth == 0 {
+				return nil
+			}
+		}
+	}
+}
+
+func (p *protoParser) parseMessage(scope string) error {
+	msg := &protoMessage{Name: qualifyProtoName(scope, p.next())}
+	p.schema.Messages[msg.Name] = msg
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+
+	for {
+		var err error
+		token := p.peek(0)
+		switch {
+		case token == "":
+			return p.errorf("unexpected end of file in message %s", msg.Name)
+		case token == "}":
+			p.next()
+			return nil
+		case token == ";":
+			p.next()
+		case token == "message" && p.peek(2) == "{":
+			p.next()
+			err = p.parseMessage(msg.Name)
+		case token == "enum" && p.peek(2) == "{":
+			p.next()
+			err = p.parseEnum(msg.Name)
+		case token == "oneof" && p.peek(2) == "{":
+			p.pos += 3
+			for err == nil && p.peek(0) != "}" {
+				switch p.peek(0) {
+				case "":
+					err = p.errorf("unexpected end of file in oneof")
+				case ";":
+					p.next()
+				case "option":
+					err = p.skipStatement()
+				default:
+					err = p.parseField(msg, true)
+				}
+			}
+			p.next()
+		case token == "map" && p.peek(1) == "<":
+			err = p.parseMapField(msg)
+		case token == "option" || token == "reserved" || token == "extensions" || token == "extend":
+			err = p.skipStatement()
+		default:
+			err = p.parseField(msg, false)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// parseField parses "[repeated|optional|required] type name = number [options];"
+func (p *protoParser) parseField(msg *protoMessage, inOneof bool) error {
+	label := ""
+	if token := p.peek(0); !inOneof && (token == "repeated" || token == "optional" || token == "required") {
+		label = p.next()
+	}
+	typeName := p.next()
+	if typeName == "group" {
+		return p.errorf("groups are not supported")
+	}
+	field := &protoField{
+		Name:     p.next(),
+		Repeated: label == "repeated",
+		Optional: inOneof || label == "optional",
+	}
+	field.setKind(typeName, msg.Name)
+	return p.finishField(msg, field)
+}
+
+// parseMapField parses "map<key, value> name = number;" into a repeated field of a
+// synthetic entry message, the way protoc does
+func (p *protoParser) parseMapField(msg *protoMessage) error {
+	p.pos += 2 // "map" "<"
+	keyType := p.next()
+	if err := p.expect(","); err != nil {
+		return err
+	}
+	valueType := p.next()
+	if err := p.expect(">"); err != nil {
+		return err
+	}
+	name := p.next()
+
+	entryName := protoJSONName("_" + name)
+	entry := &protoMessage{Name: msg.Name + "." + entryName + "Entry", MapEntry: true}
+	key := &protoField{Name: "key", JSONName: "key", Number: 1}
+	key.setKind(keyType, msg.Name)
+	value := &protoField{Name: "value", JSONName: "value", Number: 2}
+	value.setKind(valueType, msg.Name)
+	entry.Fields = []*protoField{key, value}
+	p.schema.Messages[entry.Name] = entry
+
+	field := &protoField{Name: name, Kind: "message", Repeated: true, Message: entry}
+	return p.finishField(msg, field)
+}
+
+// finishField parses "= number [options];" and adds the field to msg
+func (p *protoParser) finishField(msg *protoMessage, field *protoField) error {
+	if err := p.expect("="); err != nil {
+		return err
+	}
+	number, err := strconv.Atoi(p.next())
+	if err != nil {
+		return p.errorf("invalid number for field %s", field.Name)
+	}
+	field.Number = number
+
+	options := make(map[string]string)
+	if p.peek(0) == "[" {
+		p.next()
+		for {
+			var key []string
+			for p.peek(0) != "=" && p.peek(0) != "" {
+				key = append(key, p.next())
+			}
+			p.next()
+			if value := p.next(); value == "{" {
+				p.pos--
+				if err := p.skipStatement(); err != nil {
+					return err
+				}
+			} else {
+				options[strings.Join(key, "")] = unquoteProto(value)
+			}
+			if token := p.next(); token == "]" {
+				break
+			} else if token != "," {
+				return p.errorf("expected \",\" or \"]\" in options of field %s", field.Name)
+			}
+		}
+	}
+	if err := p.expect(";"); err != nil {
+		return err
+	}
+
+	field.JSONName = options["json_name"]
+	if field.JSONName == "" {
+		field.JSONName = protoJSONName(field.Name)
+	}
+	field.Packed = field.Repeated && (options["packed"] == "true" || (p.proto3 && options["packed"] != "false"))
+	msg.Fields = append(msg.Fields, field)
+	return nil
+}
+
+func (p *protoParser) parseEnum(scope string) error {
+	enum := &protoEnum{
+		Name:   qualifyProtoName(scope, p.next()),
+		Values: make(map[string]int32),
+		Names:  make(map[int32]string),
+	}
+	p.schema.Enums[enum.Name] = enum
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+
+	for {
+		switch token := p.next(); token {
+		case "":
+			return p.errorf("unexpected end of file in enum %s", enum.Name)
+		case "}":
+			return nil
+		case ";":
+		case "option", "reserved":
+			if err := p.skipStatement(); err != nil {
+				return err
+			}
+		default:
+			if err := p.expect("="); err != nil {
+				return err
+			}
+			number, err := strconv.ParseInt(p.next(), 0, 32)
+			if err != nil {
+				return p.errorf("invalid number for enum value %s", token)
+			}
+			enum.addValue(token, int32(number))
+			if p.peek(0) == "[" {
+				for p.peek(0) != "]" && p.peek(0) != "" {
+					p.next()
+				}
+				p.next()
+			}
+			if err := p.expect(";"); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// addValue adds a value to an enum. With aliases, the first name for a number wins.
+func (e *protoEnum) addValue(name string, number int32) {
+	if e.First == "" {
+		e.First = name
+	}
+	e.Values[name] = number
+	if _, ok := e.Names[number]; !ok {
+		e.Names[number] = name
+	}
+}
+
+func (p *protoParser) parseService() error {
+	svc := &protoService{Name: qualifyProtoName(p.pkg, p.next()), Methods: make(map[string]*protoMethod)}
+	p.schema.Services[svc.Name] = svc
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+
+	for {
+		switch token := p.next(); token {
+		case "":
+			return p.errorf("unexpected end of file in service %s", svc.Name)
+		case "}":
+			return nil
+		case ";":
+		case "option":
+			if err := p.skipStatement(); err != nil {
+				return err
+			}
+		case "rpc":
+			method := &protoMethod{Name: p.next(), Scope: p.pkg}
+			for i, target := range []*string{&method.Input, &method.Output} {
+				if i == 1 {
+					if err := p.expect("returns"); err != nil {
+						return err
+					}
+				}
+				if err := p.expect("("); err != nil {
+					return err
+				}
+				stream := p.peek(0) == "stream" && p.peek(1) != ")"
+				if stream {
+					p.next()
+				}
+				*target = p.next()
+				if i == 0 {
+					method.ClientStreaming = stream
+				} else {
+					method.ServerStreaming = stream
+				}
+				if err := p.expect(")"); err != nil {
+					return err
+				}
+			}
+			if p.peek(0) == "{" {
+				if err := p.skipStatement(); err != nil {
+					return err
+				}
+			} else if err := p.expect(";"); err != nil {
+				return err
+			}
+			svc.Methods[method.Name] = method
+		default:
+			return p.errorf("unexpected %q in service %s", token, svc.Name)
+		}
+	}
+}
+
+// unquoteProto removes the quotes from a string literal; other tokens are returned as-is
+func unquoteProto(token string) string {
+	if len(token) >= 2 && (token[0] == '"' || token[0] == '\'') {
+		if token[0] == '\'' {
+			token = `"` + strings.ReplaceAll(token[1:len(token)-1], `"`, `\"`) + `"`
+		}
+		if s, err := strconv.Unquote(token); err == nil {
+			return s
+		}
+		return token[1 : len(token)-1]
+	}
+	return token
+}
+
+// builtinProtos are the well-known types, and the parts of descriptor.proto and the
+// reflection service needed to load schemas from a server, so .proto files that
+// import them work without a protobuf installation
+var builtinProtos = map[string]string{
+	"google/protobuf/empty.proto": `syntax = "proto3";
+package google.protobuf;
+message Empty {}`,
+
+	"google/protobuf/timestamp.proto": `syntax = "proto3";
+package google.protobuf;
+message Timestamp { int64 seconds = 1; int32 nanos = 2; }`,
+
+	"google/protobuf/duration.proto": `syntax = "proto3";
+package google.protobuf;
+message Duration { int64 seconds = 1; int32 nanos = 2; }`,
+
+	"google/protobuf/wrappers.proto": `syntax = "proto3";
+package google.protobuf;
+message DoubleValue { double value = 1; }
+message FloatValue { float value = 1; }
+message Int64Value { int64 value = 1; }
+message UInt64Value { uint64 value = 1; }
+message Int32Value { int32 value = 1; }
+message UInt32Value { uint32 value = 1; }
+message BoolValue { bool value = 1; }
+message StringValue { string value = 1; }
+message BytesValue { bytes value = 1; }`,
+
+	"google/protobuf/struct.proto": `syntax = "proto3";
+package google.protobuf;
+message Struct { map<string, Value> fields = 1; }
+message Value {
+  oneof kind {
+    NullValue null_value = 1;
+    double number_value = 2;
+    string string_value = 3;
+    bool bool_value = 4;
+    Struct struct_value = 5;
+    ListValue list_value = 6;
+  }
+}
+enum NullValue { NULL_VALUE = 0; }
+message ListValue { repeated Value values = 1; }`,
+
+	"google/protobuf/descriptor.proto": `syntax = "proto2";
+package google.protobuf;
+message FileDescriptorProto {
+  optional string name = 1;
+  optional string package = 2;
+  repeated string dependency = 3;
+  repeated DescriptorProto message_type = 4;
+  repeated EnumDescriptorProto enum_type = 5;
+  repeated ServiceDescriptorProto service = 6;
+  optional string syntax = 12;
+}
+message DescriptorProto {
+  optional string name = 1;
+  repeated FieldDescriptorProto field = 2;
+  repeated DescriptorProto nested_type = 3;
+  repeated EnumDescriptorProto enum_type = 4;
+  optional MessageOptions options = 7;
+}
+message FieldDescriptorProto {
+  enum Type {
+    TYPE_DOUBLE = 1; TYPE_FLOAT = 2; TYPE_INT64 = 3; TYPE_UINT64 = 4; TYPE_INT32 = 5;
+    TYPE_FIXED64 = 6; TYPE_FIXED32 = 7; TYPE_BOOL = 8; TYPE_STRING = 9; TYPE_GROUP = 10;
+    TYPE_MESSAGE = 11; TYPE_BYTES = 12; TYPE_UINT32 = 13; TYPE_ENUM = 14; TYPE_SFIXED32 = 15;
+    TYPE_SFIXED64 = 16; TYPE_SINT32 = 17; TYPE_SINT64 = 18;
+  }
+  enum Label { LABEL_OPTIONAL = 1; LABEL_REQUIRED = 2; LABEL_REPEATED = 3; }
+  optional string name = 1;
+  optional int32 number = 3;
+  optional Label label = 4;
+  optional Type type = 5;
+  optional string type_name = 6;
+  optional FieldOptions options = 8;
+  optional int32 oneof_index = 9;
+  optional string json_name = 10;
+  optional bool proto3_optional = 17;
+}
+message EnumDescriptorProto {
+  optional string name = 1;
+  repeated EnumValueDescriptorProto value = 2;
+}
+message EnumValueDescriptorProto {
+  optional string name = 1;
+  optional int32 number = 2;
+}
+message ServiceDescriptorProto {
+  optional string name = 1;
+  repeated MethodDescriptorProto method = 2;
+}
+message MethodDescriptorProto {
+  optional string name = 1;
+  optional string input_type = 2;
+  optional string output_type = 3;
+  optional bool client_streaming = 5;
+  optional bool server_streaming = 6;
+}
+message MessageOptions { optional bool map_entry = 7; }
+message FieldOptions { optional bool packed = 2; }`,
+
+	"grpc/reflection/v1/reflection.proto": `syntax = "proto3";
+package grpc.reflection.v1;
+import "google/protobuf/descriptor.proto";
+service ServerReflection {
+  rpc ServerReflectionInfo(stream ServerReflectionRequest) returns (stream ServerReflectionResponse);
+}
+message ServerReflectionRequest {
+  string host = 1;
+  oneof message_request {
+    string file_by_filename = 3;
+    string file_containing_symbol = 4;
+  }
+}
+message ServerReflectionResponse {
+  string valid_host = 1;
+  ServerReflectionRequest original_request = 2;
+  oneof message_response {
+    FileDescriptorResponse file_descriptor_response = 4;
+    ErrorResponse error_response = 7;
+  }
+}
+message FileDescriptorResponse { repeated bytes file_descriptor_proto = 1; }
+message ErrorResponse { int32 error_code = 1; string error_message = 2; }`,
+}
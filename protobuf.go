@@ -0,0 +1,640 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// protoWireType returns the wire type a field kind is encoded with
+func protoWireType(kind string) int {
+	switch kind {
+	case "double", "fixed64", "sfixed64":
+		return wireFixed64
+	case "float", "fixed32", "sfixed32":
+		return wireFixed32
+	case "string", "bytes", "message":
+		return wireBytes
+	}
+	return wireVarint
+}
+
+// encodeProto encodes a JSON value (decoded with UseNumber) as a message, following
+// the protobuf JSON mapping: fields by JSON or .proto name, enums by name or number,
+// 64-bit integers as numbers or strings, and bytes as base64
+func encodeProto(msg *protoMessage, value interface{}) ([]byte, error) {
+	value, err := wellKnownFromJSON(msg.Name, value)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a JSON object for %s, got %s", msg.Name, describeJSONValue(value))
+	}
+	for key := range obj {
+		if msg.field(key) == nil {
+			return nil, fmt.Errorf("unknown field %q in %s", key, msg.Name)
+		}
+	}
+
+	var buf []byte
+	for _, field := range msg.Fields {
+		v, ok := obj[field.JSONName]
+		if !ok {
+			v, ok = obj[field.Name]
+		}
+		if !ok || v == nil {
+			continue
+		}
+		if buf, err = appendProtoField(buf, field, v); err != nil {
+			return nil, fmt.Errorf("%s: %w", field.JSONName, err)
+		}
+	}
+	return buf, nil
+}
+
+func appendProtoField(buf []byte, field *protoField, value interface{}) ([]byte, error) {
+	if field.Message != nil && field.Message.MapEntry {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected a JSON object, got %s", describeJSONValue(value))
+		}
+		keys := make([]string, 0, len(obj))
+		for key := range obj {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			entry, err := encodeProto(field.Message, map[string]interface{}{"key": key, "value": obj[key]})
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			buf = appendProtoBytes(appendProtoTag(buf, field.Number, wireBytes), entry)
+		}
+		return buf, nil
+	}
+
+	if !field.Repeated {
+		return appendProtoValue(buf, field, value)
+	}
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a JSON array, got %s", describeJSONValue(value))
+	}
+	if field.Packed && protoWireType(field.Kind) != wireBytes {
+		var packed []byte
+		for i, item := range items {
+			var err error
+			if packed, err = appendProtoScalar(packed, field, item); err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+		}
+		return appendProtoBytes(appendProtoTag(buf, field.Number, wireBytes), packed), nil
+	}
+	for i, item := range items {
+		var err error
+		if buf, err = appendProtoValue(buf, field, item); err != nil {
+			return nil, fmt.Errorf("[%d]: %w", i, err)
+		}
+	}
+	return buf, nil
+}
+
+// appendProtoValue appends one tagged value of a field
+func appendProtoValue(buf []byte, field *protoField, value interface{}) ([]byte, error) {
+	if field.Kind == "message" {
+		encoded, err := encodeProto(field.Message, value)
+		if err != nil {
+			return nil, err
+		}
+		return appendProtoBytes(appendProtoTag(buf, field.Number, wireBytes), encoded), nil
+	}
+	return appendProtoScalar(appendProtoTag(buf, field.Number, protoWireType(field.Kind)), field, value)
+}
+
+// appendProtoScalar appends an untagged scalar or enum value
+func appendProtoScalar(buf []byte, field *protoField, value interface{}) ([]byte, error) {
+	switch field.Kind {
+	case "enum":
+		if name, ok := value.(string); ok {
+			number, ok := field.Enum.Values[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown value %q for enum %s", name, field.Enum.Name)
+			}
+			return binary.AppendUvarint(buf, uint64(int64(number))), nil
+		}
+		n, err := jsonToInt(value, 32)
+		return binary.AppendUvarint(buf, uint64(n)), err
+	case "int32", "int64":
+		n, err := jsonToInt(value, protoKindBits(field.Kind))
+		return binary.AppendUvarint(buf, uint64(n)), err
+	case "sint32", "sint64":
+		n, err := jsonToInt(value, protoKindBits(field.Kind))
+		return binary.AppendUvarint(buf, uint64(n<<1)^uint64(n>>63)), err
+	case "uint32", "uint64":
+		n, err := jsonToUint(value, protoKindBits(field.Kind))
+		return binary.AppendUvarint(buf, n), err
+	case "fixed32":
+		n, err := jsonToUint(value, 32)
+		return binary.LittleEndian.AppendUint32(buf, uint32(n)), err
+	case "sfixed32":
+		n, err := jsonToInt(value, 32)
+		return binary.LittleEndian.AppendUint32(buf, uint32(n)), err
+	case "fixed64":
+		n, err := jsonToUint(value, 64)
+		return binary.LittleEndian.AppendUint64(buf, n), err
+	case "sfixed64":
+		n, err := jsonToInt(value, 64)
+		return binary.LittleEndian.AppendUint64(buf, uint64(n)), err
+	case "float":
+		f, err := jsonToFloat(value)
+		return binary.LittleEndian.AppendUint32(buf, math.Float32bits(float32(f))), err
+	case "double":
+		f, err := jsonToFloat(value)
+		return binary.LittleEndian.AppendUint64(buf, math.Float64bits(f)), err
+	case "bool":
+		b, ok := value.(bool)
+		if s, isString := value.(string); isString && (s == "true" || s == "false") {
+			b, ok = s == "true", true
+		}
+		if !ok {
+			return nil, fmt.Errorf("expected a boolean, got %s", describeJSONValue(value))
+		}
+		if b {
+			return append(buf, 1), nil
+		}
+		return append(buf, 0), nil
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a string, got %s", describeJSONValue(value))
+		}
+		return appendProtoBytes(buf, []byte(s)), nil
+	case "bytes":
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a base64 string, got %s", describeJSONValue(value))
+		}
+		data, err := decodeBase64(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64: %w", err)
+		}
+		return appendProtoBytes(buf, data), nil
+	}
+	return nil, fmt.Errorf("unsupported field type %s", field.Kind)
+}
+
+func appendProtoTag(buf []byte, number, wireType int) []byte {
+	return binary.AppendUvarint(buf, uint64(number)<<3|uint64(wireType))
+}
+
+func appendProtoBytes(buf, data []byte) []byte {
+	return append(binary.AppendUvarint(buf, uint64(len(data))), data...)
+}
+
+func protoKindBits(kind string) int {
+	if strings.HasSuffix(kind, "64") {
+		return 64
+	}
+	return 32
+}
+
+// jsonToInt reads a signed integer from a JSON number or numeric string
+func jsonToInt(value interface{}, bits int) (int64, error) {
+	s, err := jsonNumberText(value)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseInt(s, 10, bits)
+	if err != nil {
+		// Allow integral numbers written with an exponent or fraction, e.g. 1e3 or 2.0
+		f, ferr := strconv.ParseFloat(s, 64)
+		if ferr != nil || f != math.Trunc(f) || f < -math.Exp2(float64(bits-1)) || f >= math.Exp2(float64(bits-1)) {
+			return 0, fmt.Errorf("expected a %d-bit integer, got %s", bits, s)
+		}
+		n = int64(f)
+	}
+	return n, nil
+}
+
+// jsonToUint reads an unsigned integer from a JSON number or numeric string
+func jsonToUint(value interface{}, bits int) (uint64, error) {
+	s, err := jsonNumberText(value)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseUint(s, 10, bits)
+	if err != nil {
+		f, ferr := strconv.ParseFloat(s, 64)
+		if ferr != nil || f != math.Trunc(f) || f < 0 || f >= math.Exp2(float64(bits)) {
+			return 0, fmt.Errorf("expected an unsigned %d-bit integer, got %s", bits, s)
+		}
+		n = uint64(f)
+	}
+	return n, nil
+}
+
+// jsonToFloat reads a float from a JSON number or string, including "NaN",
+// "Infinity" and "-Infinity"
+func jsonToFloat(value interface{}) (float64, error) {
+	switch value {
+	case "NaN":
+		return math.NaN(), nil
+	case "Infinity":
+		return math.Inf(1), nil
+	case "-Infinity":
+		return math.Inf(-1), nil
+	}
+	s, err := jsonNumberText(value)
+	if err != nil {
+		return 0, err
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("expected a number, got %s", s)
+	}
+	return f, nil
+}
+
+func jsonNumberText(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case json.Number:
+		return v.String(), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case string:
+		return strings.TrimSpace(v), nil
+	}
+	return "", fmt.Errorf("expected a number, got %s", describeJSONValue(value))
+}
+
+// describeJSONValue names the type of a JSON value for error messages
+func describeJSONValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(v)
+	case json.Number, float64:
+		return fmt.Sprintf("%v", v)
+	case string:
+		return strconv.Quote(v)
+	case []interface{}:
+		return "an array"
+	case map[string]interface{}:
+		return "an object"
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimRight(s, "=")
+	if strings.ContainsAny(s, "-_") {
+		return base64.RawURLEncoding.DecodeString(s)
+	}
+	return base64.RawStdEncoding.DecodeString(s)
+}
+
+// decodeProto decodes a message to a JSON value following the protobuf JSON mapping.
+// Unlike most encoders it includes fields left at their default value (0, "", false,
+// empty lists), so assertions on them work; unset message fields and fields with
+// presence (oneofs, optional) are left out. Unknown fields are skipped.
+func decodeProto(msg *protoMessage, data []byte) (interface{}, error) {
+	obj := make(map[string]interface{})
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, fmt.Errorf("invalid field tag in %s", msg.Name)
+		}
+		data = data[n:]
+		number, wireType := int(tag>>3), int(tag&7)
+
+		var raw uint64
+		var payload []byte
+		switch wireType {
+		case wireVarint:
+			raw, n = binary.Uvarint(data)
+			if n <= 0 {
+				return nil, fmt.Errorf("invalid varint for field %d of %s", number, msg.Name)
+			}
+			data = data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return nil, fmt.Errorf("truncated field %d of %s", number, msg.Name)
+			}
+			raw, data = binary.LittleEndian.Uint64(data), data[8:]
+		case wireFixed32:
+			if len(data) < 4 {
+				return nil, fmt.Errorf("truncated field %d of %s", number, msg.Name)
+			}
+			raw, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		case wireBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return nil, fmt.Errorf("truncated field %d of %s", number, msg.Name)
+			}
+			payload, data = data[n:n+int(length)], data[n+int(length):]
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d for field %d of %s", wireType, number, msg.Name)
+		}
+
+		field := msg.fieldByNumber(number)
+		if field == nil {
+			continue
+		}
+
+		// Repeated numbers may also be packed into one length-delimited field
+		expected := protoWireType(field.Kind)
+		packed := wireType == wireBytes && expected != wireBytes && field.Repeated
+		if wireType != expected && !packed {
+			return nil, fmt.Errorf("wrong wire type %d for field %s of %s, expected %d", wireType, field.Name, msg.Name, expected)
+		}
+
+		var values []interface{}
+		if packed {
+			// Packed repeated numbers
+			for len(payload) > 0 {
+				var value interface{}
+				switch expected {
+				case wireVarint:
+					raw, n = binary.Uvarint(payload)
+					if n <= 0 {
+						return nil, fmt.Errorf("invalid packed field %s of %s", field.Name, msg.Name)
+					}
+					payload = payload[n:]
+				case wireFixed64:
+					if len(payload) < 8 {
+						return nil, fmt.Errorf("invalid packed field %s of %s", field.Name, msg.Name)
+					}
+					raw, payload = binary.LittleEndian.Uint64(payload), payload[8:]
+				case wireFixed32:
+					if len(payload) < 4 {
+						return nil, fmt.Errorf("invalid packed field %s of %s", field.Name, msg.Name)
+					}
+					raw, payload = uint64(binary.LittleEndian.Uint32(payload)), payload[4:]
+				}
+				value = protoScalarToJSON(field, raw, nil)
+				values = append(values, value)
+			}
+		} else if field.Kind == "message" {
+			value, err := decodeProto(field.Message, payload)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		} else {
+			values = append(values, protoScalarToJSON(field, raw, payload))
+		}
+
+		// An empty packed field adds nothing; the list is filled in as empty below
+		if len(values) == 0 {
+			continue
+		}
+		switch {
+		case field.Message != nil && field.Message.MapEntry:
+			entries, _ := obj[field.JSONName].(map[string]interface{})
+			if entries == nil {
+				entries = make(map[string]interface{})
+				obj[field.JSONName] = entries
+			}
+			entry := values[0].(map[string]interface{})
+			entries[fmt.Sprintf("%v", entry["key"])] = entry["value"]
+		case field.Repeated:
+			list, _ := obj[field.JSONName].([]interface{})
+			obj[field.JSONName] = append(list, values...)
+		default:
+			obj[field.JSONName] = values[len(values)-1]
+		}
+	}
+
+	for _, field := range msg.Fields {
+		if _, ok := obj[field.JSONName]; ok {
+			continue
+		}
+		switch {
+		case field.Message != nil && field.Message.MapEntry:
+			obj[field.JSONName] = map[string]interface{}{}
+		case field.Repeated:
+			obj[field.JSONName] = []interface{}{}
+		case field.Optional:
+		case field.Kind == "message":
+			// A map value is never left out, even when it's empty
+			if msg.MapEntry {
+				value, err := decodeProto(field.Message, nil)
+				if err != nil {
+					return nil, err
+				}
+				obj[field.JSONName] = value
+			}
+		default:
+			obj[field.JSONName] = protoScalarToJSON(field, 0, nil)
+		}
+	}
+	return wellKnownToJSON(msg.Name, obj)
+}
+
+// protoScalarToJSON converts a scalar or enum read from the wire to its JSON value
+func protoScalarToJSON(field *protoField, raw uint64, payload []byte) interface{} {
+	switch field.Kind {
+	case "enum":
+		if name, ok := field.Enum.Names[int32(raw)]; ok {
+			return name
+		}
+		return int64(int32(raw))
+	case "int32", "sfixed32":
+		return int64(int32(raw))
+	case "uint32", "fixed32":
+		return int64(uint32(raw))
+	case "sint32":
+		return int64(int32(uint32(raw>>1) ^ -uint32(raw&1)))
+	// 64-bit integers are strings in the JSON mapping, since JSON numbers can't hold them all
+	case "int64", "sfixed64":
+		return strconv.FormatInt(int64(raw), 10)
+	case "uint64", "fixed64":
+		return strconv.FormatUint(raw, 10)
+	case "sint64":
+		return strconv.FormatInt(int64(raw>>1)^-int64(raw&1), 10)
+	case "float":
+		return protoFloatToJSON(float64(math.Float32frombits(uint32(raw))), 32)
+	case "double":
+		return protoFloatToJSON(math.Float64frombits(raw), 64)
+	case "bool":
+		return raw != 0
+	case "string":
+		return string(payload)
+	case "bytes":
+		return base64.StdEncoding.EncodeToString(payload)
+	}
+	return nil
+}
+
+func protoFloatToJSON(f float64, bits int) interface{} {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	}
+	// Format at the field's precision, so a float 0.1 reads as 0.1 rather than 0.10000000149011612
+	return json.Number(strconv.FormatFloat(f, 'g', -1, bits))
+}
+
+// wellKnownFromJSON converts the JSON form of a well-known type to its message form,
+// e.g. a Timestamp's "2024-01-02T03:04:05Z" to {"seconds": ..., "nanos": ...}
+func wellKnownFromJSON(typeName string, value interface{}) (interface{}, error) {
+	switch typeName {
+	case "google.protobuf.Timestamp":
+		s, ok := value.(string)
+		if !ok {
+			break
+		}
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp %q, expected RFC 3339", s)
+		}
+		return protoSecondsAndNanos(t.Unix(), int32(t.Nanosecond())), nil
+	case "google.protobuf.Duration":
+		s, ok := value.(string)
+		if !ok {
+			break
+		}
+		seconds, nanos, err := parseProtoDuration(s)
+		if err != nil {
+			return nil, err
+		}
+		return protoSecondsAndNanos(seconds, nanos), nil
+	case "google.protobuf.DoubleValue", "google.protobuf.FloatValue", "google.protobuf.Int64Value",
+		"google.protobuf.UInt64Value", "google.protobuf.Int32Value", "google.protobuf.UInt32Value",
+		"google.protobuf.BoolValue", "google.protobuf.StringValue", "google.protobuf.BytesValue":
+		return map[string]interface{}{"value": value}, nil
+	case "google.protobuf.Struct":
+		return map[string]interface{}{"fields": value}, nil
+	case "google.protobuf.ListValue":
+		return map[string]interface{}{"values": value}, nil
+	case "google.protobuf.Value":
+		switch v := value.(type) {
+		case nil:
+			return map[string]interface{}{"nullValue": "NULL_VALUE"}, nil
+		case bool:
+			return map[string]interface{}{"boolValue": v}, nil
+		case string:
+			return map[string]interface{}{"stringValue": v}, nil
+		case json.Number, float64:
+			return map[string]interface{}{"numberValue": v}, nil
+		case []interface{}:
+			return map[string]interface{}{"listValue": v}, nil
+		case map[string]interface{}:
+			return map[string]interface{}{"structValue": v}, nil
+		}
+	}
+	return value, nil
+}
+
+// protoSecondsAndNanos is the message form of a Timestamp or Duration. Zero parts are
+// left out, as protobuf encoders do.
+func protoSecondsAndNanos(seconds int64, nanos int32) map[string]interface{} {
+	value := make(map[string]interface{})
+	if seconds != 0 {
+		value["seconds"] = json.Number(strconv.FormatInt(seconds, 10))
+	}
+	if nanos != 0 {
+		value["nanos"] = json.Number(strconv.Itoa(int(nanos)))
+	}
+	return value
+}
+
+// wellKnownToJSON converts a decoded well-known type to its JSON form
+func wellKnownToJSON(typeName string, obj map[string]interface{}) (interface{}, error) {
+	switch typeName {
+	case "google.protobuf.Timestamp":
+		seconds, _ := jsonToInt(obj["seconds"], 64)
+		nanos, _ := jsonToInt(obj["nanos"], 32)
+		return time.Unix(seconds, nanos).UTC().Format(time.RFC3339Nano), nil
+	case "google.protobuf.Duration":
+		seconds, _ := jsonToInt(obj["seconds"], 64)
+		nanos, _ := jsonToInt(obj["nanos"], 32)
+		return formatProtoDuration(seconds, int32(nanos)), nil
+	case "google.protobuf.DoubleValue", "google.protobuf.FloatValue", "google.protobuf.Int64Value",
+		"google.protobuf.UInt64Value", "google.protobuf.Int32Value", "google.protobuf.UInt32Value",
+		"google.protobuf.BoolValue", "google.protobuf.StringValue", "google.protobuf.BytesValue":
+		return obj["value"], nil
+	case "google.protobuf.Struct":
+		return obj["fields"], nil
+	case "google.protobuf.ListValue":
+		return obj["values"], nil
+	case "google.protobuf.Value":
+		for _, kind := range []string{"numberValue", "stringValue", "boolValue", "structValue", "listValue"} {
+			if v, ok := obj[kind]; ok {
+				return v, nil
+			}
+		}
+		return nil, nil
+	}
+	return obj, nil
+}
+
+// parseProtoDuration parses the JSON form of a Duration, e.g. "1.5s" or "-0.001s"
+func parseProtoDuration(s string) (int64, int32, error) {
+	invalid := fmt.Errorf("invalid duration %q, expected seconds like \"1.5s\"", s)
+	text := strings.TrimSuffix(s, "s")
+	if text == s || text == "" {
+		return 0, 0, invalid
+	}
+	negative := strings.HasPrefix(text, "-")
+	text = strings.TrimPrefix(text, "-")
+	whole, fraction, _ := strings.Cut(text, ".")
+	if len(fraction) > 9 {
+		return 0, 0, invalid
+	}
+	seconds, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, 0, invalid
+	}
+	var nanos int64
+	if fraction != "" {
+		if nanos, err = strconv.ParseInt(fraction+strings.Repeat("0", 9-len(fraction)), 10, 32); err != nil {
+			return 0, 0, invalid
+		}
+	}
+	if negative {
+		seconds, nanos = -seconds, -nanos
+	}
+	return seconds, int32(nanos), nil
+}
+
+// formatProtoDuration formats a Duration as JSON, e.g. "1.5s"
+func formatProtoDuration(seconds int64, nanos int32) string {
+	sign := ""
+	if seconds < 0 || nanos < 0 {
+		sign = "-"
+	}
+	if seconds < 0 {
+		seconds = -seconds
+	}
+	if nanos < 0 {
+		nanos = -nanos
+	}
+	text := strconv.FormatInt(seconds, 10)
+	if nanos != 0 {
+		text += "." + strings.TrimRight(fmt.Sprintf("%09d", nanos), "0")
+	}
+	return sign + text + "s"
+}
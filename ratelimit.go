@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket that spaces out requests to a fixed rate
+// It is safe for concurrent use, so a single limiter can be shared by all workers
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // Tokens added per second
+	tokens float64 // Available tokens; negative when callers are queued
+	last   time.Time
+}
+
+// newRateLimiter creates a limiter allowing perSecond requests per second
+// The bucket holds a single token, so requests are evenly spaced rather than bursting
+func newRateLimiter(perSecond float64) *rateLimiter {
+	return &rateLimiter{rate: perSecond, tokens: 1, last: time.Now()}
+}
+
+// Wait blocks until a request is allowed
+func (l *rateLimiter) Wait() {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > 1 {
+		l.tokens = 1
+	}
+	l.last = now
+
+	// Take a token, reserving a future one if none are left
+	l.tokens--
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	time.Sleep(wait)
+}
+
+// Rate limiters shared across all tests in a run
+var (
+	globalLimiter *rateLimiter
+	hostLimiters  = make(map[string]*rateLimiter)
+	limitersMu    sync.Mutex
+)
+
+// configureRateLimits sets up the global limiter (0 = unlimited) and the per-host
+// limiters declared in each file's frontmatter. When several files limit the same
+// host, the strictest rate wins.
+func configureRateLimits(globalRate float64, testFiles []TestFile) {
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+
+	globalLimiter = nil
+	if globalRate > 0 {
+		globalLimiter = newRateLimiter(globalRate)
+	}
+
+	hostLimiters = make(map[string]*rateLimiter)
+	for _, tf := range testFiles {
+		for host, rate := range tf.RateLimits {
+			if existing, ok := hostLimiters[host]; !ok || rate < existing.rate {
+				hostLimiters[host] = newRateLimiter(rate)
+			}
+		}
+	}
+}
+
+// waitForRateLimit blocks until a request to host is allowed by the global and
+// per-host limiters. host may include a port; limits match either form.
+func waitForRateLimit(host string) {
+	limitersMu.Lock()
+	global := globalLimiter
+	hostLimiter, ok := hostLimiters[host]
+	if !ok {
+		if i := strings.LastIndex(host, ":"); i != -1 {
+			hostLimiter = hostLimiters[host[:i]]
+		}
+	}
+	limitersMu.Unlock()
+
+	if hostLimiter != nil {
+		hostLimiter.Wait()
+	}
+	if global != nil {
+		global.Wait()
+	}
+}
+
+// parseRate parses a rate like "20/s", "100/m", "1000/h" or "5" (per second)
+// and returns it in requests per second
+func parseRate(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	count, unit, _ := strings.Cut(s, "/")
+
+	n, err := strconv.ParseFloat(strings.TrimSpace(count), 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+
+	switch strings.TrimSpace(unit) {
+	case "", "s", "sec", "second":
+		return n, nil
+	case "m", "min", "minute":
+		return n / 60, nil
+	case "h", "hour":
+		return n / 3600, nil
+	default:
+		return 0, fmt.Errorf("invalid rate unit in %q", s)
+	}
+}
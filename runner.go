@@ -1,7 +1,9 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -25,9 +27,11 @@ const (
 func formatError(err error, quiet bool) string {
 	msg := err.Error()
 	if quiet {
-		// Strip response body from status assertion errors
-		if idx := strings.Index(msg, "\n       Response:"); idx != -1 {
-			msg = msg[:idx]
+		// Strip response body from status assertion errors and diffs from body assertions
+		for _, label := range []string{"\n       Response:", "\n       Diff:"} {
+			if idx := strings.Index(msg, label); idx != -1 {
+				msg = msg[:idx]
+			}
 		}
 	}
 	return msg
@@ -41,45 +45,175 @@ func formatDuration(d time.Duration) string {
 	return fmt.Sprintf("%.2fs", d.Seconds())
 }
 
+// percentile returns the p-th percentile (0-100) of durations using the nearest-rank method
+func percentile(durations []time.Duration, p float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, len(durations))
+	copy(sorted, durations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
+
+// averageDuration returns the mean of durations
+func averageDuration(durations []time.Duration) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	var sum time.Duration
+	for _, d := range durations {
+		sum += d
+	}
+	return sum / time.Duration(len(durations))
+}
+
+// formatDurationStats summarizes the durations of a repeated test
+func formatDurationStats(durations []time.Duration) string {
+	return fmt.Sprintf("%d runs: min %s, avg %s, max %s, p50 %s, p95 %s, p99 %s",
+		len(durations),
+		formatDuration(percentile(durations, 0)),
+		formatDuration(averageDuration(durations)),
+		formatDuration(percentile(durations, 100)),
+		formatDuration(percentile(durations, 50)),
+		formatDuration(percentile(durations, 95)),
+		formatDuration(percentile(durations, 99)),
+	)
+}
+
+// runHooks runs setup or teardown requests in order, sharing variables with the tests
+// Setup hooks stop at the first failure; teardown hooks always run every request
+// so that cleanup is attempted even if an earlier step failed
+func runHooks(hooks []Test, vars map[string]interface{}, stopOnError bool) (map[string]interface{}, error) {
+	var errs []error
+	for _, hook := range hooks {
+		var err error
+		vars, err = runTest(hook, vars)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", hook.Name, err))
+			if stopOnError {
+				break
+			}
+		}
+	}
+	return vars, errors.Join(errs...)
+}
+
+// runTestWithHooks runs a test surrounded by the file's "before each" and "after each" hooks
+// The "after each" hooks run even when the test fails. The returned durations cover
+// each repetition of the test itself, not its hooks.
+func runTestWithHooks(test Test, hooks Hooks, vars map[string]interface{}) (map[string]interface{}, []time.Duration, error) {
+	var durations []time.Duration
+	vars, err := runHooks(hooks.BeforeEach, vars, true)
+	if err != nil {
+		err = fmt.Errorf("before each hook failed: %w", err)
+	} else {
+		vars, durations, err = runTestTimed(test, vars)
+	}
+
+	var teardownErr error
+	vars, teardownErr = runHooks(hooks.AfterEach, vars, false)
+	if err == nil && teardownErr != nil {
+		err = fmt.Errorf("after each hook failed: %w", teardownErr)
+	}
+	return vars, durations, err
+}
+
+// runFile runs the tests in a file in order, sharing saved variables between them
+// and wrapping them in the file's hooks. report is called with each test result,
+// and with any setup or teardown failure, as soon as it is known.
+func runFile(tf TestFile, fileIndex int, report func(TestResult)) {
+	// Variables are shared within a file but reset between files
+	var vars map[string]interface{}
+
+	var setupErr error
+	vars, setupErr = runHooks(tf.Hooks.BeforeAll, vars, true)
+	if setupErr != nil {
+		report(TestResult{FilePath: tf.Path, FileIndex: fileIndex, Test: Test{Name: "Before all"}, Index: -1, Err: setupErr})
+	}
+
+	for i, test := range tf.Tests {
+		var samples []time.Duration
+		var err error
+		if setupErr != nil {
+			err = fmt.Errorf("skipped: before all hook failed")
+		} else {
+			vars, samples, err = runTestWithHooks(test, tf.Hooks, vars)
+		}
+		var duration time.Duration
+		for _, d := range samples {
+			duration += d
+		}
+		report(TestResult{FilePath: tf.Path, FileIndex: fileIndex, Test: test, Index: i, Err: err, Duration: duration, Samples: samples})
+	}
+
+	// Teardown always runs, even when setup or tests failed
+	if _, err := runHooks(tf.Hooks.AfterAll, vars, false); err != nil {
+		report(TestResult{FilePath: tf.Path, FileIndex: fileIndex, Test: Test{Name: "After all"}, Index: -1, Err: err})
+	}
+}
+
+// printResult prints a single test result and reports whether it passed
+// In quiet mode the file header is printed before the first failure in a file
+func printResult(result TestResult, quiet, showFile bool, filePrinted *bool) bool {
+	name := result.Test.Name
+	if result.Test.Note != "" {
+		name += " (" + result.Test.Note + ")"
+	}
+
+	if result.Err != nil {
+		if quiet && showFile && !*filePrinted {
+			fmt.Printf("%s\n", result.FilePath)
+			*filePrinted = true
+		}
+		fmt.Printf("  %s✗%s %s\n", colorRed, colorReset, name)
+		fmt.Printf("    %s→ %s%s\n", colorRed, formatError(result.Err, quiet), colorReset)
+		return false
+	}
+	if !quiet {
+		fmt.Printf("  %s✓%s %s\n", colorGreen, colorReset, name)
+		// Repeated tests also show a summary of their durations
+		if len(result.Samples) > 1 {
+			fmt.Printf("    %s%s%s\n", colorDim, formatDurationStats(result.Samples), colorReset)
+		}
+	}
+	return true
+}
+
 // runTestsSequential runs all tests one after another
-func runTestsSequential(testFiles []TestFile, quiet bool) (passed, failed int, totalDuration time.Duration) {
+// failures holds the result of every failed test and hook
+func runTestsSequential(testFiles []TestFile, quiet bool) (passed, failed int, failures []TestResult, totalDuration time.Duration) {
 	suiteStart := time.Now()
+	showFile := len(testFiles) > 1
 
-	for _, tf := range testFiles {
+	for fi, tf := range testFiles {
 		fileStart := time.Now()
-		fileHasFailure := false
+		filePrinted := false
 
-		if !quiet && len(testFiles) > 1 {
+		if !quiet && showFile {
 			fmt.Printf("%s\n", tf.Path)
+			filePrinted = true
 		}
 
-		// Variables are shared within a file but reset between files
-		var vars map[string]interface{}
-
-		for _, test := range tf.Tests {
-			var err error
-			vars, err = runTest(test, vars)
-			if err != nil {
-				// In quiet mode, print file header before first failure
-				if quiet && !fileHasFailure && len(testFiles) > 1 {
-					fmt.Printf("%s\n", tf.Path)
-				}
-				fileHasFailure = true
-				fmt.Printf("  %s✗%s %s\n", colorRed, colorReset, test.Name)
-				fmt.Printf("    %s→ %s%s\n", colorRed, formatError(err, quiet), colorReset)
-				failed++
-			} else {
-				if !quiet {
-					fmt.Printf("  %s✓%s %s\n", colorGreen, colorReset, test.Name)
-				}
+		runFile(tf, fi, func(result TestResult) {
+			if printResult(result, quiet, showFile, &filePrinted) {
 				passed++
+			} else {
+				failed++
+				failures = append(failures, result)
 			}
-		}
+		})
 
 		fileDuration := time.Since(fileStart)
-		if !quiet && len(testFiles) > 1 {
-			fmt.Printf("  %s%s%s\n\n", colorDim, formatDuration(fileDuration), colorReset)
-		} else if quiet && fileHasFailure && len(testFiles) > 1 {
+		if showFile && filePrinted {
 			fmt.Printf("  %s%s%s\n\n", colorDim, formatDuration(fileDuration), colorReset)
 		}
 	}
@@ -89,119 +223,68 @@ func runTestsSequential(testFiles []TestFile, quiet bool) (passed, failed int, t
 	}
 
 	totalDuration = time.Since(suiteStart)
-	return passed, failed, totalDuration
+	return passed, failed, failures, totalDuration
 }
 
-// runTestsParallel runs all tests concurrently, limited by CPU cores
-func runTestsParallel(testFiles []TestFile, quiet bool) (passed, failed int, totalDuration time.Duration) {
+// runTestsParallel runs up to concurrency test files at once (0 = one per CPU core)
+// Tests within a file still run in order so saved variables can be chained
+func runTestsParallel(testFiles []TestFile, quiet bool, concurrency int) (passed, failed int, failures []TestResult, totalDuration time.Duration) {
 	suiteStart := time.Now()
-	maxWorkers := runtime.NumCPU()
-	sem := make(chan struct{}, maxWorkers)
-
-	// Build flat list of all tests with their file context
-	type testJob struct {
-		filePath  string
-		fileIndex int
-		testIndex int
-		test      Test
-	}
-
-	var jobs []testJob
-	for fi, tf := range testFiles {
-		for ti, test := range tf.Tests {
-			jobs = append(jobs, testJob{
-				filePath:  tf.Path,
-				fileIndex: fi,
-				testIndex: ti,
-				test:      test,
-			})
-		}
+	maxWorkers := concurrency
+	if maxWorkers <= 0 {
+		maxWorkers = runtime.NumCPU()
 	}
+	sem := make(chan struct{}, maxWorkers)
 
-	// Results slice
-	results := make([]TestResult, len(jobs))
+	// Results are collected per file and printed once everything has finished
+	results := make([][]TestResult, len(testFiles))
+	fileDurations := make([]time.Duration, len(testFiles))
 	var wg sync.WaitGroup
 
-	for i, job := range jobs {
+	for i, tf := range testFiles {
 		wg.Add(1)
-		go func(idx int, j testJob) {
+		go func(fi int, tf TestFile) {
 			defer wg.Done()
 			sem <- struct{}{}        // Acquire
 			defer func() { <-sem }() // Release
 
 			start := time.Now()
-			// In parallel mode, each test gets fresh variables (no sharing)
-			_, err := runTest(j.test, nil)
-			results[idx] = TestResult{
-				FilePath:  j.filePath,
-				FileIndex: j.fileIndex,
-				Test:      j.test,
-				Index:     idx,
-				Err:       err,
-				Duration:  time.Since(start),
-			}
-		}(i, job)
+			runFile(tf, fi, func(result TestResult) {
+				results[fi] = append(results[fi], result)
+			})
+			fileDurations[fi] = time.Since(start)
+		}(i, tf)
 	}
 
 	wg.Wait()
 
-	// Calculate per-file durations (max test duration since they run in parallel)
-	fileDurations := make(map[int]time.Duration)
-	fileHasFailure := make(map[int]bool)
-	for _, result := range results {
-		if result.Duration > fileDurations[result.FileIndex] {
-			fileDurations[result.FileIndex] = result.Duration
-		}
-		if result.Err != nil {
-			fileHasFailure[result.FileIndex] = true
-		}
-	}
-
 	// Print results in order, grouped by file
-	currentFile := ""
-	currentFileIndex := -1
-	filePrinted := make(map[int]bool)
-	for i, job := range jobs {
-		if len(testFiles) > 1 && job.filePath != currentFile {
-			// Print previous file's duration
-			if currentFile != "" && (!quiet || fileHasFailure[currentFileIndex]) {
-				fmt.Printf("  %s%s%s\n\n", colorDim, formatDuration(fileDurations[currentFileIndex]), colorReset)
-			}
-			if !quiet {
-				fmt.Printf("%s\n", job.filePath)
-				filePrinted[job.fileIndex] = true
-			}
-			currentFile = job.filePath
-			currentFileIndex = job.fileIndex
+	showFile := len(testFiles) > 1
+	for fi, tf := range testFiles {
+		filePrinted := false
+		if !quiet && showFile {
+			fmt.Printf("%s\n", tf.Path)
+			filePrinted = true
 		}
-
-		result := results[i]
-		if result.Err != nil {
-			// In quiet mode, print file header before first failure in this file
-			if quiet && !filePrinted[job.fileIndex] && len(testFiles) > 1 {
-				fmt.Printf("%s\n", job.filePath)
-				filePrinted[job.fileIndex] = true
-			}
-			fmt.Printf("  %s✗%s %s\n", colorRed, colorReset, result.Test.Name)
-			fmt.Printf("    %s→ %s%s\n", colorRed, formatError(result.Err, quiet), colorReset)
-			failed++
-		} else {
-			if !quiet {
-				fmt.Printf("  %s✓%s %s\n", colorGreen, colorReset, result.Test.Name)
+		for _, result := range results[fi] {
+			if printResult(result, quiet, showFile, &filePrinted) {
+				passed++
+			} else {
+				failed++
+				failures = append(failures, result)
 			}
-			passed++
+		}
+		if showFile && filePrinted {
+			fmt.Printf("  %s%s%s\n\n", colorDim, formatDuration(fileDurations[fi]), colorReset)
 		}
 	}
 
-	// Print last file's duration if multiple files
-	if len(testFiles) > 1 && (!quiet || fileHasFailure[currentFileIndex]) {
-		fmt.Printf("  %s%s%s\n\n", colorDim, formatDuration(fileDurations[currentFileIndex]), colorReset)
-	} else if !quiet {
+	if !quiet && !showFile {
 		fmt.Println()
 	}
 
 	totalDuration = time.Since(suiteStart)
-	return passed, failed, totalDuration
+	return passed, failed, failures, totalDuration
 }
 
 // collectTestFiles gathers all test files from a file or directory path
@@ -224,10 +307,14 @@ func collectTestFiles(path string) ([]TestFile, error) {
 				if err != nil {
 					return err
 				}
-				baseDir := filepath.Dir(p)
-				tests := parseTests(string(content), baseDir)
-				if len(tests) > 0 {
-					testFiles = append(testFiles, TestFile{Path: p, Tests: tests})
+				tf := parseTestFile(string(content), filepath.Dir(p))
+				if len(tf.Errors) > 0 {
+					return fmt.Errorf("%s: %s", p, tf.Errors[0])
+				}
+				if len(tf.Tests) > 0 {
+					tf.Path = p
+					setSnapshotPaths(&tf)
+					testFiles = append(testFiles, tf)
 				}
 			}
 			return nil
@@ -245,10 +332,14 @@ func collectTestFiles(path string) ([]TestFile, error) {
 		if err != nil {
 			return nil, err
 		}
-		baseDir := filepath.Dir(path)
-		tests := parseTests(string(content), baseDir)
-		if len(tests) > 0 {
-			testFiles = append(testFiles, TestFile{Path: path, Tests: tests})
+		tf := parseTestFile(string(content), filepath.Dir(path))
+		if len(tf.Errors) > 0 {
+			return nil, fmt.Errorf("%s: %s", path, tf.Errors[0])
+		}
+		if len(tf.Tests) > 0 {
+			tf.Path = path
+			setSnapshotPaths(&tf)
+			testFiles = append(testFiles, tf)
 		}
 	}
 
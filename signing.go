@@ -0,0 +1,293 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// requestSigner signs a request once its headers and body are final
+type requestSigner interface {
+	Sign(req *http.Request, body []byte, now time.Time) error
+}
+
+// signers creates a signer for each signing type, from a config with environment
+// variables already filled in
+var signers = map[string]func(SigningConfig) (requestSigner, error){
+	"hmac":      newHMACSigner,
+	"aws-sigv4": newSigV4Signer,
+}
+
+// defaultCanonical is what the HMAC signer signs unless the frontmatter says otherwise
+const defaultCanonical = "{method}\n{path}\n{timestamp}\n{body}"
+
+// signRequest signs a request as the test's signing config says, if it has one
+func signRequest(req *http.Request, body []byte, signing *SigningConfig) error {
+	if signing == nil {
+		return nil
+	}
+	config, err := signing.expanded()
+	if err != nil {
+		return err
+	}
+	newSigner, ok := signers[config.Type]
+	if !ok {
+		return fmt.Errorf("signing failed: unknown type %q, expected hmac or aws-sigv4", config.Type)
+	}
+	signer, err := newSigner(config)
+	if err != nil {
+		return fmt.Errorf("signing failed: %w", err)
+	}
+	if err := signer.Sign(req, body, time.Now()); err != nil {
+		return fmt.Errorf("signing failed: %w", err)
+	}
+	return nil
+}
+
+// expanded returns a copy of signing with environment variables filled in
+func (signing SigningConfig) expanded() (SigningConfig, error) {
+	for _, value := range []*string{&signing.Secret, &signing.AccessKey, &signing.SecretKey, &signing.SessionToken, &signing.Region, &signing.Service} {
+		var err error
+		if *value, err = expandEnv(*value); err != nil {
+			return signing, fmt.Errorf("signing failed: %w", err)
+		}
+	}
+	return signing, nil
+}
+
+// hmacSigner sends an HMAC-SHA256 of a canonical string built from the request
+type hmacSigner struct {
+	SigningConfig
+}
+
+func newHMACSigner(config SigningConfig) (requestSigner, error) {
+	if config.Secret == "" {
+		return nil, fmt.Errorf("hmac needs a secret")
+	}
+	if config.Header == "" {
+		config.Header = "X-Signature"
+	}
+	if config.Canonical == "" {
+		config.Canonical = defaultCanonical
+	}
+	if config.TimestampHeader == "" {
+		config.TimestampHeader = "X-Timestamp"
+	}
+	if config.Encoding != "" && config.Encoding != "hex" && config.Encoding != "base64" {
+		return nil, fmt.Errorf("unknown encoding %q, expected hex or base64", config.Encoding)
+	}
+	return hmacSigner{config}, nil
+}
+
+// placeholderPattern matches {name} and {header:Name} in a canonical string
+var placeholderPattern = regexp.MustCompile(`\{(\w+)(?::([^}]+))?\}`)
+
+func (s hmacSigner) Sign(req *http.Request, body []byte, now time.Time) error {
+	// The timestamp header is set first, so {header:...} can sign it too
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	if strings.Contains(s.Canonical, "{timestamp}") {
+		req.Header.Set(s.TimestampHeader, timestamp)
+	}
+
+	var unknown []string
+	canonical := placeholderPattern.ReplaceAllStringFunc(s.Canonical, func(placeholder string) string {
+		matches := placeholderPattern.FindStringSubmatch(placeholder)
+		switch matches[1] {
+		case "method":
+			return req.Method
+		case "path":
+			return req.URL.EscapedPath()
+		case "query":
+			return req.URL.RawQuery
+		case "url":
+			return req.URL.String()
+		case "host":
+			return req.URL.Host
+		case "timestamp":
+			return timestamp
+		case "body":
+			return string(body)
+		case "body_sha256":
+			return sha256Hex(body)
+		case "header":
+			return req.Header.Get(matches[2])
+		}
+		unknown = append(unknown, placeholder)
+		return placeholder
+	})
+	if len(unknown) > 0 {
+		return fmt.Errorf("unknown placeholder %s in canonical string", strings.Join(unknown, ", "))
+	}
+
+	mac := hmac.New(sha256.New, []byte(s.Secret))
+	mac.Write([]byte(canonical))
+	signature := hex.EncodeToString(mac.Sum(nil))
+	if s.Encoding == "base64" {
+		signature = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+	req.Header.Set(s.Header, s.Prefix+signature)
+	return nil
+}
+
+// sigV4Signer signs requests with AWS Signature Version 4
+type sigV4Signer struct {
+	SigningConfig
+}
+
+// newSigV4Signer falls back to the standard AWS environment variables for settings
+// the frontmatter leaves out
+func newSigV4Signer(config SigningConfig) (requestSigner, error) {
+	fallback := func(value *string, names ...string) {
+		for _, name := range names {
+			if *value == "" {
+				*value = os.Getenv(name)
+			}
+		}
+	}
+	fallback(&config.AccessKey, "AWS_ACCESS_KEY_ID")
+	fallback(&config.SecretKey, "AWS_SECRET_ACCESS_KEY")
+	fallback(&config.SessionToken, "AWS_SESSION_TOKEN")
+	fallback(&config.Region, "AWS_REGION", "AWS_DEFAULT_REGION")
+	if config.AccessKey == "" || config.SecretKey == "" {
+		return nil, fmt.Errorf("aws-sigv4 needs an access_key and secret_key (or AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY)")
+	}
+	if config.Region == "" || config.Service == "" {
+		return nil, fmt.Errorf("aws-sigv4 needs a region and service")
+	}
+	return sigV4Signer{config}, nil
+}
+
+func (s sigV4Signer) Sign(req *http.Request, body []byte, now time.Time) error {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+	if s.Service == "s3" {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	// Every header set so far is signed, along with the host
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "authorization" || name == "user-agent" {
+			continue
+		}
+		trimmed := make([]string, len(values))
+		for i, value := range values {
+			trimmed[i] = strings.Join(strings.Fields(value), " ")
+		}
+		headers[name] = strings.Join(trimmed, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s.canonicalURI(req.URL.Path),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/" + s.Service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	for _, part := range []string{s.Region, s.Service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.AccessKey, scope, signedHeaders, signature))
+	return nil
+}
+
+// canonicalURI encodes each path segment, twice for every service but S3
+func (s sigV4Signer) canonicalURI(path string) string {
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = awsEscape(segment)
+		if s.Service != "s3" {
+			segments[i] = awsEscape(segments[i])
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// canonicalQuery sorts query parameters by name, then value
+func canonicalQuery(query map[string][]string) string {
+	type pair struct{ name, value string }
+	var pairs []pair
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, pair{awsEscape(name), awsEscape(value)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].name != pairs[j].name {
+			return pairs[i].name < pairs[j].name
+		}
+		return pairs[i].value < pairs[j].value
+	})
+	encoded := make([]string, len(pairs))
+	for i, p := range pairs {
+		encoded[i] = p.name + "=" + p.value
+	}
+	return strings.Join(encoded, "&")
+}
+
+// awsEscape percent-encodes everything except unreserved characters, as SigV4 requires
+func awsEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// updateSnapshots rewrites snapshots with the current responses instead of
+// comparing against them (--update-snapshots)
+var updateSnapshots bool
+
+// snapshotName turns a test name into a file name, e.g. "Get user #1" -> "get-user-1"
+func snapshotName(testName string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(testName) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	name := strings.TrimSuffix(b.String(), "-")
+	if name == "" {
+		name = "test"
+	}
+	return name
+}
+
+// setSnapshotPaths points each Body matches snapshot assertion in a test file at
+// __snapshots__/<file>/<test>.json next to the test file. Tests with the same name
+// get a numeric suffix so they don't share a snapshot.
+func setSnapshotPaths(tf *TestFile) {
+	dir := filepath.Join(filepath.Dir(tf.Path), "__snapshots__", strings.TrimSuffix(filepath.Base(tf.Path), ".md"))
+	used := make(map[string]int)
+
+	for i := range tf.Tests {
+		test := &tf.Tests[i]
+		name := snapshotName(test.Name)
+		used[name]++
+		if used[name] > 1 {
+			name = fmt.Sprintf("%s-%d", name, used[name])
+		}
+
+		for j := range test.Assertions {
+			if test.Assertions[j].Type == "body_matches_snapshot" {
+				test.Assertions[j].Value = filepath.Join(dir, name+".json")
+			}
+		}
+	}
+}
+
+// validateSnapshot compares a response body against its snapshot. The snapshot is
+// written instead when it doesn't exist yet or snapshots are being updated.
+func validateSnapshot(assertion Assertion, body []byte) error {
+	if assertion.Value == "" {
+		return fmt.Errorf("body matches snapshot assertion failed: no snapshot path for this test")
+	}
+
+	expected, err := os.ReadFile(assertion.Value)
+	if updateSnapshots || os.IsNotExist(err) {
+		if err := writeSnapshot(assertion.Value, body); err != nil {
+			return fmt.Errorf("body matches snapshot assertion failed: could not write snapshot: %w", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("body matches snapshot assertion failed: could not read snapshot '%s': %w", assertion.Value, err)
+	}
+
+	diff, err := compareBodies(expected, body, assertion.Ignore, jsonMatchOptions{IgnoreOrder: assertion.IgnoreOrder})
+	if err != nil {
+		return fmt.Errorf("body matches snapshot assertion failed: %w", err)
+	}
+	if diff != nil {
+		return fmt.Errorf("body matches snapshot assertion failed: response does not match snapshot '%s' (run with --update-snapshots to accept it)%s", assertion.Value, diffDetail(diff))
+	}
+	return nil
+}
+
+// writeSnapshot saves a response body, pretty-printing JSON so snapshots diff well
+func writeSnapshot(path string, body []byte) error {
+	content := body
+	var parsed interface{}
+	if err := json.Unmarshal(body, &parsed); err == nil {
+		if indented, err := json.MarshalIndent(parsed, "", "  "); err == nil {
+			content = append(indented, '\n')
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0o644)
+}
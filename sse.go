@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// defaultEventTimeout is how long an event stream is read when the test doesn't say
+const defaultEventTimeout = 30 * time.Second
+
+// sseEvent is one event from a text/event-stream response
+type sseEvent struct {
+	Type string // The event: field, "message" when there is none
+	Data string // The data: lines, joined with newlines
+	ID   string
+}
+
+// isEventStream reports whether a response is a Server-Sent Events stream
+func isEventStream(header http.Header) bool {
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
+
+// readEvents reads events until the test's count or event type is reached, the
+// stream ends or the timeout passes. It returns the events along with the raw
+// stream, which is used as the response body.
+func readEvents(resp *http.Response, test Test) ([]sseEvent, []byte, error) {
+	timeout := test.EventTimeout
+	if timeout == 0 {
+		timeout = defaultEventTimeout
+	}
+	// Closing the body is the only way to interrupt a read that is waiting on the server
+	var timedOut atomic.Bool
+	timer := time.AfterFunc(timeout, func() {
+		timedOut.Store(true)
+		resp.Body.Close()
+	})
+	defer timer.Stop()
+
+	var raw bytes.Buffer
+	var events []sseEvent
+	var event sseEvent
+	var data []string
+	hasData := false
+
+	// dispatch completes the current event and reports whether reading can stop
+	dispatch := func() bool {
+		if !hasData && event.Type == "" {
+			return false
+		}
+		if event.Type == "" {
+			event.Type = "message"
+		}
+		event.Data = strings.Join(data, "\n")
+		events = append(events, event)
+		done := (test.EventCount > 0 && len(events) >= test.EventCount) ||
+			(test.EventUntil != "" && event.Type == test.EventUntil)
+		event, data, hasData = sseEvent{}, nil, false
+		return done
+	}
+
+	reader := bufio.NewReader(io.TeeReader(resp.Body, &raw))
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			// A final event without a trailing blank line still counts
+			if line != "" {
+				parseEventLine(strings.TrimRight(line, "\r\n"), &event, &data, &hasData)
+			}
+			if dispatch() {
+				return events, raw.Bytes(), nil
+			}
+			if timedOut.Load() {
+				if test.EventCount > 0 || test.EventUntil != "" {
+					return events, raw.Bytes(), fmt.Errorf("timed out after %s waiting for %s (got %d events)", timeout, describeEventCondition(test), len(events))
+				}
+				return events, raw.Bytes(), nil
+			}
+			if err != io.EOF {
+				return events, raw.Bytes(), fmt.Errorf("failed to read event stream: %w", err)
+			}
+			if test.EventCount > 0 || test.EventUntil != "" {
+				return events, raw.Bytes(), fmt.Errorf("event stream ended after %d events without %s", len(events), describeEventCondition(test))
+			}
+			return events, raw.Bytes(), nil
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if dispatch() {
+				return events, raw.Bytes(), nil
+			}
+			continue
+		}
+		parseEventLine(line, &event, &data, &hasData)
+	}
+}
+
+// parseEventLine applies one "field: value" line to the event being read
+func parseEventLine(line string, event *sseEvent, data *[]string, hasData *bool) {
+	if strings.HasPrefix(line, ":") {
+		return // Comment, often used as a keep-alive
+	}
+	field, value, _ := strings.Cut(line, ":")
+	value = strings.TrimPrefix(value, " ")
+	switch field {
+	case "event":
+		event.Type = value
+	case "data":
+		*data = append(*data, value)
+		*hasData = true
+	case "id":
+		event.ID = value
+	}
+}
+
+func describeEventCondition(test Test) string {
+	if test.EventUntil != "" {
+		return fmt.Sprintf("event `%s`", test.EventUntil)
+	}
+	return fmt.Sprintf("%d events", test.EventCount)
+}
+
+// checkEventAssertion checks an event_* assertion against the events that were read
+func checkEventAssertion(assertion Assertion, events []sseEvent) error {
+	if assertion.Type == "event_count" {
+		expected, err := strconv.Atoi(assertion.Value)
+		if err != nil {
+			return fmt.Errorf("invalid event count in assertion: %s", assertion.Value)
+		}
+		if len(events) != expected {
+			return fmt.Errorf("event count assertion failed: expected %d, got %d", expected, len(events))
+		}
+		return nil
+	}
+
+	event, err := findEvent(assertion.Event, events)
+	if err != nil {
+		return err
+	}
+
+	switch assertion.Type {
+	case "event_type":
+		if event.Type != assertion.Value {
+			return fmt.Errorf("event %s type assertion failed: expected `%s`, got `%s`", assertion.Event, assertion.Value, event.Type)
+		}
+	case "event_id":
+		if event.ID != assertion.Value {
+			return fmt.Errorf("event %s id assertion failed: expected `%s`, got `%s`", assertion.Event, assertion.Value, event.ID)
+		}
+	case "event_data":
+		if event.Data != assertion.Value {
+			return fmt.Errorf("event %s data assertion failed: expected `%s`, got `%s`", assertion.Event, assertion.Value, event.Data)
+		}
+	case "event_data_includes":
+		if !strings.Contains(event.Data, assertion.Value) {
+			return fmt.Errorf("event %s data assertion failed: expected to include `%s`, got `%s`", assertion.Event, assertion.Value, event.Data)
+		}
+	case "event_field":
+		var jsonData map[string]interface{}
+		if err := json.Unmarshal([]byte(event.Data), &jsonData); err != nil {
+			return fmt.Errorf("event %s data is not a JSON object: %s", assertion.Event, event.Data)
+		}
+		fieldAssertion := Assertion{Type: "field_equals", Field: assertion.Field, Value: assertion.Value}
+		if err := validateAssertion(fieldAssertion, 0, []byte(event.Data), jsonData, 0); err != nil {
+			return fmt.Errorf("event %s: %w", assertion.Event, err)
+		}
+	}
+	return nil
+}
+
+// findEvent picks an event by number (from 1), "last", or type (the first of that type)
+func findEvent(selector string, events []sseEvent) (sseEvent, error) {
+	if selector == "last" {
+		if len(events) == 0 {
+			return sseEvent{}, fmt.Errorf("event assertion failed: no events received")
+		}
+		return events[len(events)-1], nil
+	}
+	if n, err := strconv.Atoi(selector); err == nil {
+		if n < 1 || n > len(events) {
+			return sseEvent{}, fmt.Errorf("event assertion failed: no event %d (got %d events)", n, len(events))
+		}
+		return events[n-1], nil
+	}
+	for _, event := range events {
+		if event.Type == selector {
+			return event, nil
+		}
+	}
+	return sseEvent{}, fmt.Errorf("event assertion failed: no `%s` event (got %d events)", selector, len(events))
+}
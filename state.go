@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// lastRunPath is where the failures from the most recent run are recorded
+const lastRunPath = ".marcus/last-run.json"
+
+// LastRun is the state saved after each run, used by --failed to rerun failures
+type LastRun struct {
+	FinishedAt time.Time       `json:"finished_at"`
+	Failures   []FailedTestRef `json:"failures"`
+}
+
+// FailedTestRef identifies a failed test by its file and name
+type FailedTestRef struct {
+	File string `json:"file"`
+	Test string `json:"test"`
+}
+
+// loadLastRun reads the saved state of the previous run
+func loadLastRun(path string) (LastRun, error) {
+	var state LastRun
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, fmt.Errorf("no previous run recorded (%s not found)", path)
+		}
+		return state, err
+	}
+	if err := json.Unmarshal(content, &state); err != nil {
+		return state, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+	return state, nil
+}
+
+// saveLastRun records the failures from a run. Tests that didn't run this time keep
+// their previous state, so running a subset of tests doesn't forget other failures.
+func saveLastRun(path string, ran []TestFile, failures []TestResult) error {
+	previous, _ := loadLastRun(path)
+
+	didRun := make(map[FailedTestRef]bool)
+	for _, tf := range ran {
+		for _, test := range tf.Tests {
+			didRun[FailedTestRef{File: filepath.Clean(tf.Path), Test: test.Name}] = true
+		}
+	}
+
+	state := LastRun{FinishedAt: time.Now(), Failures: []FailedTestRef{}}
+	for _, ref := range previous.Failures {
+		if !didRun[ref] {
+			state.Failures = append(state.Failures, ref)
+		}
+	}
+	// A failed hook (e.g., an "after all" teardown) has no test of its own, so it's
+	// recorded against every test that ran in its file; --failed then runs them, and
+	// the hook, again
+	recorded := make(map[FailedTestRef]bool)
+	record := func(ref FailedTestRef) {
+		if !recorded[ref] {
+			recorded[ref] = true
+			state.Failures = append(state.Failures, ref)
+		}
+	}
+	for _, result := range failures {
+		file := filepath.Clean(result.FilePath)
+		if result.Index >= 0 {
+			record(FailedTestRef{File: file, Test: result.Test.Name})
+			continue
+		}
+		for _, tf := range ran {
+			if filepath.Clean(tf.Path) == file {
+				for _, test := range tf.Tests {
+					record(FailedTestRef{File: file, Test: test.Name})
+				}
+			}
+		}
+	}
+
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(content, '\n'), 0o644)
+}
+
+// filterFailed keeps only the tests that failed in the previous run
+func filterFailed(testFiles []TestFile, state LastRun) []TestFile {
+	failed := make(map[FailedTestRef]bool)
+	for _, ref := range state.Failures {
+		failed[ref] = true
+	}
+
+	var filtered []TestFile
+	for _, tf := range testFiles {
+		var tests []Test
+		for _, test := range tf.Tests {
+			if failed[FailedTestRef{File: filepath.Clean(tf.Path), Test: test.Name}] {
+				tests = append(tests, test)
+			}
+		}
+		if len(tests) > 0 {
+			tf.Tests = tests
+			filtered = append(filtered, tf)
+		}
+	}
+	return filtered
+}
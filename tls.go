@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cliTLS holds the --ca-cert, --client-cert, --client-key and --insecure options,
+// which apply to every file. A file's tls: block takes precedence, setting by setting.
+var cliTLS TLSConfig
+
+// tlsTransports reuses one transport (and its connections) per TLS configuration
+var (
+	tlsTransports   = make(map[TLSConfig]*http.Transport)
+	tlsTransportsMu sync.Mutex
+)
+
+// parseTLSFlag sets a TLS command line option, reporting whether arg was one
+func parseTLSFlag(arg string) bool {
+	switch {
+	case strings.HasPrefix(arg, "--ca-cert="):
+		cliTLS.CACert = strings.TrimPrefix(arg, "--ca-cert=")
+	case strings.HasPrefix(arg, "--client-cert="):
+		cliTLS.ClientCert = strings.TrimPrefix(arg, "--client-cert=")
+	case strings.HasPrefix(arg, "--client-key="):
+		cliTLS.ClientKey = strings.TrimPrefix(arg, "--client-key=")
+	case arg == "--insecure":
+		cliTLS.InsecureSkipVerify = true
+	default:
+		return false
+	}
+	return true
+}
+
+// tlsTransport returns the transport for a test's TLS settings, or nil if neither
+// the test nor the command line has any, so the default transport is used
+func tlsTransport(test Test) (http.RoundTripper, error) {
+	config := cliTLS
+	if test.TLS != nil {
+		if test.TLS.CACert != "" {
+			config.CACert = test.TLS.CACert
+		}
+		if test.TLS.ClientCert != "" {
+			config.ClientCert, config.ClientKey = test.TLS.ClientCert, test.TLS.ClientKey
+		}
+		if test.TLS.InsecureSet {
+			config.InsecureSkipVerify = test.TLS.InsecureSkipVerify
+		}
+	}
+	if config == (TLSConfig{}) {
+		return nil, nil
+	}
+
+	tlsTransportsMu.Lock()
+	defer tlsTransportsMu.Unlock()
+	if transport, ok := tlsTransports[config]; ok {
+		return transport, nil
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify}
+	if config.CACert != "" {
+		pem, err := os.ReadFile(config.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		// The CA is trusted as well as the system's, so public hosts keep working
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", config.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if config.ClientCert != "" || config.ClientKey != "" {
+		if config.ClientCert == "" || config.ClientKey == "" {
+			return nil, fmt.Errorf("a client certificate needs both client_cert and client_key")
+		}
+		cert, err := tls.LoadX509KeyPair(config.ClientCert, config.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	// HTTP/2 is still attempted, as with the default transport, so gRPC calls work
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig, ForceAttemptHTTP2: true}
+	tlsTransports[config] = transport
+	return transport, nil
+}
+
+// tlsVersionNames maps the versions crypto/tls negotiates to how assertions write them
+var tlsVersionNames = map[uint16]string{
+	tls.VersionTLS10: "1.0",
+	tls.VersionTLS11: "1.1",
+	tls.VersionTLS12: "1.2",
+	tls.VersionTLS13: "1.3",
+}
+
+// checkTLSAssertion checks a tls_version or cert_expiry assertion against the
+// connection a response came over
+func checkTLSAssertion(assertion Assertion, state *tls.ConnectionState) error {
+	if state == nil {
+		return fmt.Errorf("TLS assertion failed: the response didn't come over TLS")
+	}
+
+	switch assertion.Type {
+	case "tls_version":
+		version := tlsVersionNames[state.Version]
+		if version == "" {
+			version = fmt.Sprintf("0x%04x", state.Version)
+		}
+		if version != assertion.Value {
+			return fmt.Errorf("TLS version assertion failed: expected %s, got %s", assertion.Value, version)
+		}
+
+	case "cert_expiry":
+		if len(state.PeerCertificates) == 0 {
+			return fmt.Errorf("certificate expiry assertion failed: the server sent no certificate")
+		}
+		days, err := strconv.Atoi(assertion.Value)
+		if err != nil {
+			return fmt.Errorf("invalid number of days in assertion: %s", assertion.Value)
+		}
+		cert := state.PeerCertificates[0]
+		if remaining := time.Until(cert.NotAfter); remaining <= time.Duration(days)*24*time.Hour {
+			return fmt.Errorf("certificate expiry assertion failed: expected to expire in more than %d days, expires %s (in %.1f days)", days, cert.NotAfter.UTC().Format("2006-01-02"), remaining.Hours()/24)
+		}
+	}
+	return nil
+}
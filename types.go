@@ -5,26 +5,67 @@ import "time"
 // Test represents a single API test parsed from markdown
 type Test struct {
 	Name        string
+	Tags        []string // Tags from the header (e.g., "## Create user @smoke" -> ["smoke"])
+	Line        int      // Line of the ## header in the test file (1-indexed)
+	EndLine     int      // Last line of the test's block
+	Note        string   // Shown after the name in output (e.g., "#3" for --only, "prerequisite")
 	Method      string
 	URL         string
 	Headers     map[string]string
 	Body        string
 	ContentType string
+	PayloadFile string           // Resolved path of a FILE: payload, if the body came from one
+	RawBody     bool             // Body is a binary FILE: payload, sent byte for byte without interpolation
+	Multipart   []MultipartField // Parts of a ```multipart body, encoded when the request is sent
+	GraphQL     bool             // Body is a GraphQL query, sent as {"query": ..., "variables": ...}
+	Variables   string           // JSON from a ```variables block, for GraphQL queries
+	ProtoFile   string           // Resolved path of the .proto file for a GRPC call (default: server reflection)
+	Steps       []WebSocketStep  // Send: and Expect: steps of a WS test, in order
+	Auth        *AuthConfig      // From the frontmatter auth: block, if any
+	Signing     *SigningConfig   // From the frontmatter signing: block, if any
+	TLS         *TLSConfig       // From the frontmatter tls: block, with paths resolved
 	Assertions  []Assertion
-	SaveFields  []SaveField // Fields to save for use in subsequent tests
+	SaveFields  []SaveField // Fields to save for use in subsequent tests (for WS tests, every step's)
 	// Retry configuration for polling async endpoints
 	WaitForStatus int           // Status code to wait for (0 = no waiting)
 	WaitForField  string        // Field path to wait for (e.g., "message.code")
 	WaitForValue  string        // Value the field should equal
 	RetryDelay    time.Duration // Delay between retries (default: 1s)
 	RetryMax      int           // Max retry attempts (default: 10)
+	Repeat        int           // Number of times to run the test (0 or 1 = once)
+	// When to stop reading a text/event-stream response (by default, when it ends)
+	EventCount   int           // Stop after this many events
+	EventUntil   string        // Stop after an event of this type
+	EventTimeout time.Duration // Stop after this long (default: 30s)
 }
 
 // Assertion represents a single assertion to validate
 type Assertion struct {
-	Type  string // "status", "body_contains", "field_equals"
-	Field string // for field_equals: the field path (e.g., "json.username")
-	Value string // expected value
+	Type        string   // "status", "body_contains", "field_equals", "duration_stat", ...
+	Field       string   // for field_equals and event_field: the field path (e.g., "json.username"); for duration_stat: "p95", "avg", "min" or "max"
+	Value       string   // expected value
+	Ignore      []string // for body_matches_file and body_matches_snapshot: field paths to leave out of the comparison
+	IgnoreOrder bool     // for body_matches_file and body_matches_snapshot: arrays match regardless of item order
+	Event       string   // for event_* assertions: which event, by number ("2"), type ("done") or "last"
+}
+
+// MultipartField is one part of a multipart/form-data body
+// e.g. "name=Alice" or "avatar=@payloads/avatar.png;type=image/png"
+type MultipartField struct {
+	Name        string
+	Value       string // Field value, for parts that aren't files
+	File        string // Resolved path of the file to upload, if the part is a file
+	Filename    string // File name sent with the part (default: the file's base name)
+	ContentType string // Content-Type of a file part (default: guessed from the extension)
+}
+
+// WebSocketStep is a message to send, or a message to wait for, in a WS test
+type WebSocketStep struct {
+	Action     string        // "send" or "expect"
+	Message    string        // for send: the message, with {{variables}} filled in when it's sent
+	Assertions []Assertion   // for expect: what the message must match (any message if empty)
+	Timeout    time.Duration // for expect: how long to wait for a matching message (default: 5s)
+	SaveFields []SaveField   // for expect: fields to save from the matching message
 }
 
 // SaveField represents a field to save from the response
@@ -35,14 +76,73 @@ type SaveField struct {
 
 // TestFile represents a markdown file containing tests
 type TestFile struct {
-	Path  string
-	Tests []Test
+	Path       string
+	Tests      []Test
+	Hooks      Hooks
+	RateLimits map[string]float64 // Requests per second allowed per host, shared by all files
+	Errors     []string           // Invalid frontmatter settings, reported when the file is loaded
+}
+
+// Hooks holds setup and teardown requests that run around the tests in a file
+type Hooks struct {
+	BeforeAll  []Test // Run once before the first test
+	AfterAll   []Test // Run once after the last test, even if tests fail
+	BeforeEach []Test // Run before every test
+	AfterEach  []Test // Run after every test, even if it fails
 }
 
 // Defaults holds default settings parsed from frontmatter
 type Defaults struct {
-	Root    string
-	Headers map[string]string
+	Root       string
+	Headers    map[string]string
+	RateLimits map[string]float64 // Host -> requests per second
+	Errors     []string           // Invalid settings (e.g., "invalid rate limit for ...")
+	Proto      string             // .proto file for GRPC tests, relative to the test file
+	Auth       *AuthConfig
+	Signing    *SigningConfig
+	TLS        *TLSConfig
+}
+
+// AuthConfig is a frontmatter auth: block. Values may reference environment
+// variables as ${NAME}, which are read when a request is sent.
+type AuthConfig struct {
+	Type         string // "basic", "bearer" or "oauth2"
+	Username     string // For basic auth and the OAuth2 password grant
+	Password     string
+	Token        string // For bearer auth
+	Grant        string // OAuth2 grant: "client_credentials" (default) or "password"
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scope        string
+}
+
+// TLSConfig is a frontmatter tls: block, or the matching command line options
+type TLSConfig struct {
+	CACert             string // PEM file of CAs to trust, as well as the system's
+	ClientCert         string // PEM client certificate and key, for mutual TLS
+	ClientKey          string
+	InsecureSkipVerify bool
+	InsecureSet        bool // insecure_skip_verify was given, so it overrides --insecure either way
+}
+
+// SigningConfig is a frontmatter signing: block. Like auth: values, secrets may be
+// read from environment variables as ${NAME}.
+type SigningConfig struct {
+	Type string // "hmac" or "aws-sigv4"
+	// HMAC-SHA256
+	Secret          string
+	Header          string // Header the signature is sent in (default: X-Signature)
+	Canonical       string // What is signed, with {placeholders} (default: defaultCanonical)
+	TimestampHeader string // Header the {timestamp} is sent in (default: X-Timestamp)
+	Prefix          string // Put before the signature (e.g., "sha256=")
+	Encoding        string // "hex" (default) or "base64"
+	// AWS Signature Version 4, defaulting to the standard AWS environment variables
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	Region       string
+	Service      string
 }
 
 // TestResult holds the outcome of a single test execution
@@ -52,5 +152,6 @@ type TestResult struct {
 	Test      Test
 	Index     int
 	Err       error
-	Duration  time.Duration
+	Duration  time.Duration   // Total time spent on the test's requests
+	Samples   []time.Duration // Duration of each repetition
 }
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// watcher polls test files and the files they reference (FILE: payloads, multipart
+// uploads and Body matches file expectations) for changes. Polling works the same on every
+// platform without relying on filesystem notifications.
+type watcher struct {
+	target   string
+	modTimes map[string]time.Time // Last seen modification time of every watched file
+	refs     map[string][]string  // Test file -> files it references
+}
+
+func newWatcher(target string) *watcher {
+	return &watcher{
+		target:   target,
+		modTimes: make(map[string]time.Time),
+		refs:     make(map[string][]string),
+	}
+}
+
+// referencedFiles returns the files a test file depends on besides itself
+func referencedFiles(tf TestFile) []string {
+	var files []string
+	tests := append([]Test{}, tf.Tests...)
+	tests = append(tests, tf.Hooks.BeforeAll...)
+	tests = append(tests, tf.Hooks.AfterAll...)
+	tests = append(tests, tf.Hooks.BeforeEach...)
+	tests = append(tests, tf.Hooks.AfterEach...)
+
+	for _, test := range tests {
+		if test.PayloadFile != "" {
+			files = append(files, test.PayloadFile)
+		}
+		if test.ProtoFile != "" {
+			files = append(files, test.ProtoFile)
+		}
+		for _, field := range test.Multipart {
+			if field.File != "" {
+				files = append(files, field.File)
+			}
+		}
+		for _, assertion := range test.Assertions {
+			if assertion.Type == "body_matches_file" {
+				files = append(files, assertion.Value)
+			}
+		}
+	}
+	return files
+}
+
+// listTestFiles returns the markdown files under the watch target
+func (w *watcher) listTestFiles() ([]string, error) {
+	info, err := os.Stat(w.target)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{w.target}, nil
+	}
+
+	var paths []string
+	err = filepath.Walk(w.target, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(p, ".md") {
+			paths = append(paths, p)
+		}
+		return nil
+	})
+	return paths, err
+}
+
+// modTime returns a file's modification time, or the zero time if it doesn't exist
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// scan returns the test files that are new, have changed, or reference a file that
+// has changed since the last scan. The first scan returns every test file.
+func (w *watcher) scan() ([]string, error) {
+	paths, err := w.listTestFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	changed := make(map[string]bool)
+	present := make(map[string]bool)
+	for _, path := range paths {
+		present[path] = true
+		mt := modTime(path)
+		if prev, ok := w.modTimes[path]; ok && mt.Equal(prev) {
+			continue
+		}
+		w.modTimes[path] = mt
+		changed[path] = true
+
+		// Re-parse changed files to pick up new or removed references
+		content, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		w.refs[path] = referencedFiles(parseTestFile(string(content), filepath.Dir(path)))
+	}
+
+	// Forget test files that were deleted
+	for path := range w.refs {
+		if !present[path] {
+			delete(w.refs, path)
+			delete(w.modTimes, path)
+		}
+	}
+
+	// Check each referenced file once, then rerun every test file that uses a changed one
+	changedRefs := make(map[string]bool)
+	for _, refs := range w.refs {
+		for _, ref := range refs {
+			if _, checked := changedRefs[ref]; checked {
+				continue
+			}
+			mt := modTime(ref)
+			prev, seen := w.modTimes[ref]
+			changedRefs[ref] = seen && !mt.Equal(prev)
+			w.modTimes[ref] = mt
+		}
+	}
+	for path, refs := range w.refs {
+		for _, ref := range refs {
+			if changedRefs[ref] {
+				changed[path] = true
+			}
+		}
+	}
+
+	var result []string
+	for path := range changed {
+		result = append(result, path)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// runWatchCommand implements "marcus watch [options] <file-or-directory>"
+func runWatchCommand(args []string) {
+	usage := "Usage: marcus watch [--interval=D] [--rate=N/s] [--ca-cert=FILE] [--client-cert=FILE --client-key=FILE] [--insecure] <file-or-directory>"
+
+	interval := 500 * time.Millisecond
+	rate := 0.0
+	target := ""
+
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--interval=") {
+			d, err := parseDuration(strings.TrimPrefix(arg, "--interval="))
+			if err != nil || d <= 0 {
+				fmt.Fprintln(os.Stderr, "Error: --interval requires a duration (e.g., --interval=1s)")
+				os.Exit(1)
+			}
+			interval = d
+		} else if strings.HasPrefix(arg, "--rate=") {
+			r, err := parseRate(strings.TrimPrefix(arg, "--rate="))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error: --rate requires a rate per second, minute or hour (e.g., --rate=20/s)")
+				os.Exit(1)
+			}
+			rate = r
+		} else if parseTLSFlag(arg) {
+			// TLS options apply to every request, see cliTLS
+		} else if target == "" {
+			target = arg
+		}
+	}
+
+	if target == "" {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(1)
+	}
+
+	w := newWatcher(target)
+	for {
+		changed, err := w.scan()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if len(changed) > 0 {
+			runWatchIteration(changed, rate)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// runWatchIteration clears the screen and reruns the given test files, showing
+// only failures and a summary
+func runWatchIteration(paths []string, rate float64) {
+	fmt.Print("\033[H\033[2J")
+
+	var testFiles []TestFile
+	for _, path := range paths {
+		files, err := collectTestFiles(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			continue
+		}
+		testFiles = append(testFiles, files...)
+	}
+
+	fmt.Printf("%s%s%s %s\n\n", colorBold, time.Now().Format("15:04:05"), colorReset, strings.Join(paths, ", "))
+
+	if len(testFiles) == 0 {
+		fmt.Println("No tests found.")
+	} else {
+		configureRateLimits(rate, testFiles)
+		passed, failed, failures, totalDuration := runTestsSequential(testFiles, true)
+		if err := saveLastRun(lastRunPath, testFiles, failures); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not save %s: %v\n", lastRunPath, err)
+		}
+
+		if failed == 0 {
+			fmt.Printf("%s%d passed%s %sin %s%s\n", colorGreen, passed, colorReset, colorDim, formatDuration(totalDuration), colorReset)
+		} else {
+			fmt.Printf("%s%d passed%s, %s%d failed%s %sin %s%s\n", colorGreen, passed, colorReset, colorRed, failed, colorReset, colorDim, formatDuration(totalDuration), colorReset)
+		}
+	}
+
+	fmt.Printf("\n%sWatching for changes...%s\n", colorDim, colorReset)
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// webSocketGUID is hashed with the handshake key to prove the server speaks WebSocket
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// defaultExpectTimeout is how long an Expect: step waits for a matching message
+const defaultExpectTimeout = 5 * time.Second
+
+// maxWebSocketMessage guards against a broken length field allocating gigabytes
+const maxWebSocketMessage = 64 << 20
+
+// WebSocket frame opcodes
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// webSocketConn is the client side of a WebSocket connection. Received messages are
+// read in the background, so an Expect: step can give up waiting for one.
+type webSocketConn struct {
+	conn     io.ReadWriteCloser
+	writeMu  sync.Mutex
+	done     chan struct{}
+	Messages chan []byte // Text and binary messages, closed when the connection ends
+	Err      error       // Why the connection ended, once Messages is closed
+}
+
+// runWebSocket runs the Send: and Expect: steps of a WS test over one connection
+func runWebSocket(test Test, vars map[string]interface{}) (map[string]interface{}, time.Duration, error) {
+	start := time.Now()
+	conn, err := dialWebSocket(test)
+	if err != nil {
+		return vars, time.Since(start), err
+	}
+	defer conn.Close()
+
+	expects := 0
+	for _, step := range test.Steps {
+		if step.Action == "send" {
+			if err := conn.writeFrame(wsText, []byte(interpolateVariables(step.Message, vars))); err != nil {
+				return vars, time.Since(start), fmt.Errorf("websocket send failed: %w", err)
+			}
+			continue
+		}
+
+		expects++
+		message, err := conn.expect(step)
+		if err != nil {
+			return vars, time.Since(start), fmt.Errorf("expect #%d failed: %w", expects, err)
+		}
+		for _, sf := range step.SaveFields {
+			value, err := getJSONField(message, sf.Field)
+			if err != nil {
+				return vars, time.Since(start), fmt.Errorf("save field failed: %w", err)
+			}
+			vars[sf.Variable] = value
+		}
+	}
+	return vars, time.Since(start), nil
+}
+
+// dialWebSocket opens a test's WebSocket connection. ws:// and wss:// URLs are dialed
+// as http:// and https://, and headers, auth, signing and TLS settings apply to the
+// handshake.
+func dialWebSocket(test Test) (*webSocketConn, error) {
+	rawURL := test.URL
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WebSocket URL %q: %w", rawURL, err)
+	}
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	case "http", "https":
+	default:
+		return nil, fmt.Errorf("invalid WebSocket URL %q, expected ws:// or wss://", rawURL)
+	}
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for k, v := range test.Headers {
+		req.Header.Set(k, v)
+	}
+	transport, err := tlsTransport(test)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyAuth(req, test.Auth, transport); err != nil {
+		return nil, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+
+	waitForRateLimit(req.URL.Host)
+	if err := signRequest(req, nil, test.Signing); err != nil {
+		return nil, err
+	}
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 500))
+		resp.Body.Close()
+		return nil, fmt.Errorf("websocket handshake failed: expected status 101, got %d\n       Response: %s", resp.StatusCode, body)
+	}
+
+	accept := sha1.Sum([]byte(key + webSocketGUID))
+	rwc, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok || resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(accept[:]) {
+		resp.Body.Close()
+		return nil, errors.New("websocket handshake failed: invalid Sec-WebSocket-Accept")
+	}
+
+	conn := &webSocketConn{conn: rwc, done: make(chan struct{}), Messages: make(chan []byte, 64)}
+	go conn.readLoop(bufio.NewReader(rwc))
+	return conn, nil
+}
+
+// expect waits for a message that passes a step's assertions. Messages that don't
+// are skipped, so heartbeats and unrelated notifications don't fail the test.
+func (c *webSocketConn) expect(step WebSocketStep) (map[string]interface{}, error) {
+	timeout := step.Timeout
+	if timeout == 0 {
+		timeout = defaultExpectTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	start := time.Now()
+	var lastErr error
+	for {
+		select {
+		case message, ok := <-c.Messages:
+			if !ok {
+				return nil, fmt.Errorf("%v before a matching message", c.Err)
+			}
+			var jsonBody map[string]interface{}
+			json.Unmarshal(message, &jsonBody) // Ignore error - might not be JSON
+
+			lastErr = nil
+			for _, assertion := range step.Assertions {
+				if err := validateAssertion(assertion, http.StatusSwitchingProtocols, message, jsonBody, time.Since(start)); err != nil {
+					lastErr = err
+					break
+				}
+			}
+			if lastErr == nil {
+				return jsonBody, nil
+			}
+		case <-timer.C:
+			if lastErr != nil {
+				return nil, fmt.Errorf("no matching message within %s, the last one failed: %w", timeout, lastErr)
+			}
+			return nil, fmt.Errorf("no message within %s", timeout)
+		}
+	}
+}
+
+// writeFrame sends a single masked frame, as clients must
+func (c *webSocketConn) writeFrame(opcode byte, payload []byte) error {
+	frame := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	case n <= 0xFFFF:
+		frame = binary.BigEndian.AppendUint16(append(frame, 0x80|126), uint16(n))
+	default:
+		frame = binary.BigEndian.AppendUint64(append(frame, 0x80|127), uint64(n))
+	}
+	var mask [4]byte
+	rand.Read(mask[:])
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// readLoop reads frames until the connection ends, answering pings and putting
+// complete messages on Messages
+func (c *webSocketConn) readLoop(r *bufio.Reader) {
+	defer close(c.Messages)
+
+	var message []byte
+	for {
+		var header [2]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			c.Err = connectionError(err)
+			return
+		}
+		fin, opcode := header[0]&0x80 != 0, header[0]&0x0F
+		length := uint64(header[1] & 0x7F)
+		switch length {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(r, ext[:]); err != nil {
+				c.Err = connectionError(err)
+				return
+			}
+			length = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(r, ext[:]); err != nil {
+				c.Err = connectionError(err)
+				return
+			}
+			length = binary.BigEndian.Uint64(ext[:])
+		}
+		if length > maxWebSocketMessage || uint64(len(message))+length > maxWebSocketMessage {
+			c.Err = fmt.Errorf("message larger than %d bytes", maxWebSocketMessage)
+			return
+		}
+
+		var mask [4]byte
+		masked := header[1]&0x80 != 0
+		if masked {
+			if _, err := io.ReadFull(r, mask[:]); err != nil {
+				c.Err = connectionError(err)
+				return
+			}
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			c.Err = connectionError(err)
+			return
+		}
+		if masked {
+			for i := range payload {
+				payload[i] ^= mask[i%4]
+			}
+		}
+
+		switch opcode {
+		case wsPing:
+			c.writeFrame(wsPong, payload)
+		case wsPong:
+		case wsClose:
+			code := 1005 // No status code
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			c.Err = fmt.Errorf("connection closed by server (code %d)", code)
+			c.writeFrame(wsClose, payload[:min(len(payload), 2)])
+			return
+		case wsText, wsBinary, wsContinuation:
+			message = append(message, payload...)
+			if !fin {
+				continue
+			}
+			select {
+			case c.Messages <- message:
+			case <-c.done:
+				return
+			}
+			message = nil
+		}
+	}
+}
+
+// Close sends a normal closure and closes the connection
+func (c *webSocketConn) Close() error {
+	close(c.done)
+	c.writeFrame(wsClose, binary.BigEndian.AppendUint16(nil, 1000))
+	return c.conn.Close()
+}
+
+func connectionError(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return errors.New("connection closed")
+	}
+	return fmt.Errorf("connection failed: %w", err)
+}